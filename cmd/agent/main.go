@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/api"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/client"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/config"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/crash"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/health"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/metrics"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/quota"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/reconcile"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
 )
 
 func main() {
@@ -35,11 +45,42 @@ func main() {
 			log.Printf("Error closing Docker manager: %v", err)
 		}
 	}()
+	dockerManager.SetDataVolume(docker.DataVolume{
+		HostDir:       cfg.ServerDataDir,
+		ContainerPath: cfg.ContainerDataPath,
+		UID:           cfg.ServerUID,
+		GID:           cfg.ServerGID,
+	})
+	dockerManager.SetRegistryAuth(docker.RegistryAuth{
+		Server:   cfg.RegistryServer,
+		Username: cfg.RegistryUsername,
+		Password: cfg.RegistryPassword,
+	})
+
+	// Load the server registry
+	servers, err := registry.Open(cfg.DataDir, dockerManager)
+	if err != nil {
+		log.Fatalf("Error loading server registry: %v", err)
+	}
+
+	// Track server power states; observed states come from the containers
+	states := state.NewMachine(state.ObserveContainers(servers, dockerManager))
+
+	// Actions are registered once and served over both HTTP and the WebSocket
+	commands := command.NewRegistry()
 
 	// Initialize API server
-	apiServer := api.NewServer(cfg, dockerManager)
+	apiServer := api.NewServer(cfg, dockerManager, servers, states, commands)
 
-	// Start combined API/Health server in background
+	// Initialize WebSocket client; it registers the power and console actions
+	wsClient := client.NewClient(cfg, dockerManager, servers, states, commands)
+
+	wsClient.SetConnectionHandler(healthServer.SetConnectionStatus)
+	wsClient.SetBinaryHandler(apiServer.HandleUploadFrame)
+	apiServer.SetEventSink(wsClient)
+
+	// Start combined API/Health server in background once every action is
+	// registered
 	go func() {
 		log.Printf("Starting combined API/Health server on :%s", cfg.HealthPort)
 		if err := apiServer.StartServer(cfg.HealthPort, healthServer); err != nil {
@@ -47,25 +88,70 @@ func main() {
 		}
 	}()
 
-	// Initialize WebSocket client
-	wsClient := client.NewClient(cfg, dockerManager)
+	// Background services share a context cancelled on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Push resource usage of running servers to the panel; the last samples
+	// also go into crash reports
+	var statsHistory crash.StatsHistory
+	var statsStreamer *metrics.Streamer
+	if cfg.StatsInterval > 0 {
+		statsStreamer = metrics.NewStreamer(dockerManager, wsClient, cfg.StatsInterval)
+		statsHistory = statsStreamer
+		go statsStreamer.Run(ctx)
+	}
+
+	// Follow container events to report crashes and exits as they happen
+	watcher := watch.NewWatcher(dockerManager, states, wsClient)
+	watcher.OnStart(wsClient.WatchConsole)
+	if statsStreamer != nil {
+		watcher.OnStart(statsStreamer.Track)
+		watcher.OnExit(statsStreamer.HandleExit)
+		wsClient.SetDeleteHandler(statsStreamer.Forget)
+	}
+
+	// Keep the evidence of every crash in the server's data directory
+	if cfg.CrashReportLines > 0 {
+		reporter := crash.NewReporter(dockerManager, statsHistory, cfg.ServerDataDir, cfg.CrashReportLines)
+		watcher.SetCrashReporter(reporter.HandleCrash)
+	}
+
+	// Restart crashed servers according to their restart policy
+	supervisor := crash.NewSupervisor(dockerManager, states, wsClient, docker.RestartPolicy{
+		Policy:      cfg.CrashRestartPolicy,
+		MaxRestarts: &cfg.CrashMaxRestarts,
+		Window:      int(cfg.CrashRestartWindow.Seconds()),
+		Backoff:     int(cfg.CrashRestartBackoff.Seconds()),
+	})
+	supervisor.SetReadyCheck(wsClient.ReadyCheck)
+	wsClient.SetStopHandler(supervisor.Cancel)
+	watcher.OnExit(supervisor.HandleExit)
+	go watcher.Run(ctx)
+	<-watcher.Subscribed()
+
+	// Reconcile managed containers with the registry once the watcher sees
+	// the starts and stops it causes; events are buffered by the client and
+	// delivered once the panel connection is up
+	reconciler := reconcile.NewReconciler(dockerManager, servers, wsClient, wsClient, cfg.ReconcileRestore)
+	reconcileCtx, cancelReconcile := context.WithTimeout(ctx, 2*time.Minute)
+	if _, err := reconciler.Run(reconcileCtx); err != nil {
+		log.Printf("Warning: Startup reconciliation failed: %v", err)
+	}
+	cancelReconcile()
+
+	// Police disk limits the storage driver could not enforce
+	if cfg.DiskCheckInterval > 0 {
+		diskMonitor := quota.NewMonitor(dockerManager, wsClient, wsClient, cfg.DiskCheckInterval)
+		go diskMonitor.Run(ctx)
+	}
 
-	// Try to connect to panel (but don't fail if it's not available)
-	if err := wsClient.Connect(); err != nil {
-		log.Printf("Warning: Could not connect to panel: %v", err)
-		log.Printf("Agent will continue running with HTTP API only")
-		healthServer.SetConnectionStatus(false)
+	// Start the supervised connection loop; it keeps redialing the panel in the
+	// background, so the agent continues running with the HTTP API meanwhile
+	if err := wsClient.Start(); err != nil {
+		log.Printf("Warning: Error starting WebSocket client: %v", err)
 	} else {
-		// Update health status to connected
-		healthServer.SetConnectionStatus(true)
-
-		// Start client
-		if err := wsClient.Start(); err != nil {
-			log.Printf("Warning: Error starting WebSocket client: %v", err)
-			healthServer.SetConnectionStatus(false)
-		} else {
-			log.Printf("Agent connected to panel at %s as node %s", cfg.PanelURL, cfg.NodeID)
-		}
+		log.Printf("Agent connecting to panel at %s as node %s", cfg.PanelURL, cfg.NodeID)
 	}
 
 	log.Printf("Health check available at http://localhost:%s/health", cfg.HealthPort)
@@ -82,6 +168,7 @@ func main() {
 	healthServer.SetConnectionStatus(false)
 
 	// Graceful shutdown
+	cancel()
 	if wsClient != nil {
 		wsClient.Stop()
 	}
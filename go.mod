@@ -1,12 +1,15 @@
 module github.com/scarecr0w12/ctrl-alt-play-agent
 
-go 1.23.0
+go 1.25.0
 
-toolchain go1.24.5
+toolchain go1.25.3
 
 require (
+	github.com/distribution/reference v0.6.0
 	github.com/docker/docker v28.3.2+incompatible
+	github.com/docker/go-connections v0.5.0
 	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.20.1
 	github.com/stretchr/testify v1.10.0
 )
 
@@ -16,8 +19,6 @@ require (
 	github.com/containerd/errdefs/pkg v0.3.0 // indirect
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/distribution/reference v0.6.0 // indirect
-	github.com/docker/go-connections v0.5.0 // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.3 // indirect
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+// archiveProgressInterval is how often a running archive operation reports
+// its progress; operations finishing sooner report none
+const archiveProgressInterval = time.Second
+
+// archiveProgress returns a callback sending archive_progress events for
+// the archive at p, at most once per archiveProgressInterval
+func (s *Server) archiveProgress(req *command.Request, operation, p string) func(files.ArchiveProgress) {
+	if s.events == nil {
+		return nil
+	}
+
+	last := time.Now()
+	return func(progress files.ArchiveProgress) {
+		if time.Since(last) < archiveProgressInterval {
+			return
+		}
+		last = time.Now()
+
+		var percent int64
+		if progress.Total > 0 {
+			percent = progress.Bytes * 100 / progress.Total
+		}
+		s.events.SendEvent("archive_progress", map[string]interface{}{
+			"serverId":  req.ServerID,
+			"commandId": req.ID,
+			"operation": operation,
+			"path":      p,
+			"bytes":     progress.Bytes,
+			"total":     progress.Total,
+			"percent":   percent,
+		})
+	}
+}
+
+func (s *Server) handleCompressFiles(ctx context.Context, req *command.Request, p *messages.CompressFilesPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	result, err := dir.Compress(p.Paths, p.Destination, p.Format, s.archiveProgress(req, "compress", p.Destination))
+	if err != nil {
+		return nil, err
+	}
+
+	format := p.Format
+	if format == "" {
+		format = files.DetectFormat(p.Destination)
+	}
+	data := map[string]interface{}{
+		"serverId": req.ServerID,
+		"archive":  p.Destination,
+		"format":   format,
+		"entries":  result.Entries,
+		"bytes":    result.Bytes,
+		"skipped":  result.Skipped,
+	}
+	if info, err := dir.Stat(p.Destination); err == nil {
+		data["size"] = info.Size()
+	}
+
+	return &command.Result{
+		Message: fmt.Sprintf("Compressed %d entries into %s", result.Entries, p.Destination),
+		Data:    data,
+	}, nil
+}
+
+func (s *Server) handleDecompressFile(ctx context.Context, req *command.Request, p *messages.DecompressFilePayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	destination := p.Destination
+	if destination == "" {
+		destination = path.Dir(p.Path)
+	}
+
+	limits := files.ArchiveLimits{MaxBytes: s.config.ArchiveMaxSize, MaxEntries: s.config.ArchiveMaxEntries}
+	result, err := dir.Extract(p.Path, destination, p.Format, limits, s.archiveProgress(req, "decompress", p.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: fmt.Sprintf("Extracted %d entries into %s", result.Entries, destination),
+		Data: map[string]interface{}{
+			"serverId":    req.ServerID,
+			"path":        p.Path,
+			"destination": destination,
+			"entries":     result.Entries,
+			"bytes":       result.Bytes,
+			"skipped":     result.Skipped,
+		},
+	}, nil
+}
@@ -1,73 +1,64 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
+	"io/fs"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
 )
 
 // FileManager handles file operations that the panel expects
 type FileManager struct {
 	baseDir string
+	owner   files.Owner // container user that created files are handed to
 }
 
 // NewFileManager creates a new file manager
-func NewFileManager(baseDir string) *FileManager {
+func NewFileManager(baseDir string, owner files.Owner) *FileManager {
 	if baseDir == "" {
 		baseDir = "/opt/gameservers"
 	}
 	return &FileManager{
 		baseDir: baseDir,
+		owner:   owner,
 	}
 }
 
-// File operations that the panel expects
-func (s *Server) handleListFiles(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
+// open opens the data directory of a server; the caller must close it
+func (fm *FileManager) open(serverID string) (*files.Dir, error) {
+	return files.OpenServerDir(fm.baseDir, serverID, &fm.owner)
+}
 
-	pathStr, _ := data["path"].(string)
+// File operations that the panel expects
+func (s *Server) handleListFiles(ctx context.Context, req *command.Request, p *messages.DirectoryPayload) (*command.Result, error) {
+	pathStr := p.Path
 	if pathStr == "" {
 		pathStr = "/"
 	}
 
-	// Build the full path (serverId as subdirectory)
-	serverDir := filepath.Join("/opt/gameservers", serverID)
-	fullPath := filepath.Join(serverDir, pathStr)
-
-	// Ensure we don't go outside the server directory
-	if !strings.HasPrefix(fullPath, serverDir) {
-		return CommandResponse{
-			Success: false,
-			Error:   "Invalid path - cannot access files outside server directory",
-		}
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
+	defer dir.Close()
 
 	// Check if directory exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Path does not exist: %s", pathStr),
-		}
+	if _, err := dir.Stat(pathStr); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("path does not exist: %s", pathStr)
 	}
 
 	// List directory contents
-	entries, err := os.ReadDir(fullPath)
+	entries, err := dir.ReadDir(pathStr)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to list directory: %v", err),
-		}
+		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
 
-	var files []map[string]interface{}
+	var list []map[string]interface{}
 	for _, entry := range entries {
 		info, err := entry.Info()
 		if err != nil {
@@ -85,276 +76,126 @@ func (s *Server) handleListFiles(data map[string]interface{}) CommandResponse {
 			fileInfo["type"] = "directory"
 		}
 
-		files = append(files, fileInfo)
+		list = append(list, fileInfo)
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"serverId": serverID,
+			"serverId": req.ServerID,
 			"path":     pathStr,
-			"files":    files,
+			"files":    list,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleReadFile(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	filePath, ok := data["path"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file path",
-		}
-	}
-
-	// Build the full path
-	serverDir := filepath.Join("/opt/gameservers", serverID)
-	fullPath := filepath.Join(serverDir, filePath)
-
-	// Ensure we don't go outside the server directory
-	if !strings.HasPrefix(fullPath, serverDir) {
-		return CommandResponse{
-			Success: false,
-			Error:   "Invalid path - cannot access files outside server directory",
-		}
+func (s *Server) handleReadFile(ctx context.Context, req *command.Request, p *messages.FilePayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
+	defer dir.Close()
 
 	// Read the file
-	content, err := os.ReadFile(fullPath)
+	content, err := dir.ReadFile(p.Path)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to read file: %v", err),
-		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Get file info
-	info, err := os.Stat(fullPath)
+	info, err := dir.Stat(p.Path)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to get file info: %v", err),
-		}
+		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"path":     filePath,
+			"serverId": req.ServerID,
+			"path":     p.Path,
 			"content":  string(content),
 			"size":     info.Size(),
 			"modified": info.ModTime(),
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleWriteFile(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	filePath, ok := data["path"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file path",
-		}
-	}
-
-	content, ok := data["content"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file content",
-		}
-	}
-
-	// Build the full path
-	serverDir := filepath.Join("/opt/gameservers", serverID)
-	fullPath := filepath.Join(serverDir, filePath)
-
-	// Ensure we don't go outside the server directory
-	if !strings.HasPrefix(fullPath, serverDir) {
-		return CommandResponse{
-			Success: false,
-			Error:   "Invalid path - cannot access files outside server directory",
-		}
-	}
-
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to create directory: %v", err),
-		}
-	}
-
-	// Write the file
-	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to write file: %v", err),
-		}
+func (s *Server) handleWriteFile(ctx context.Context, req *command.Request, p *messages.FileContentPayload) (*command.Result, error) {
+	if err := s.files.write(req.ServerID, p.Path, []byte(p.Content)); err != nil {
+		return nil, err
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
+		Message: "File written successfully",
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"path":     filePath,
-			"message":  "File written successfully",
+			"serverId": req.ServerID,
+			"path":     p.Path,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleUploadFile(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	filePath, ok := data["path"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file path",
-		}
-	}
-
-	// Expect base64 encoded content
-	contentB64, ok := data["content"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file content",
-		}
-	}
-
-	// Decode base64 content
-	content, err := base64.StdEncoding.DecodeString(contentB64)
+func (s *Server) handleUploadFile(ctx context.Context, req *command.Request, p *messages.UploadFilePayload) (*command.Result, error) {
+	// Content is base64, checked when the payload was validated
+	content, err := base64.StdEncoding.DecodeString(p.Content)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Invalid base64 content: %v", err),
-		}
-	}
-
-	// Build the full path
-	serverDir := filepath.Join("/opt/gameservers", serverID)
-	fullPath := filepath.Join(serverDir, filePath)
-
-	// Ensure we don't go outside the server directory
-	if !strings.HasPrefix(fullPath, serverDir) {
-		return CommandResponse{
-			Success: false,
-			Error:   "Invalid path - cannot access files outside server directory",
-		}
+		return nil, err
 	}
 
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to create directory: %v", err),
-		}
+	if err := s.files.write(req.ServerID, p.Path, content); err != nil {
+		return nil, err
 	}
 
-	// Write the file
-	if err := os.WriteFile(fullPath, content, 0644); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to upload file: %v", err),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
+		Message: "File uploaded successfully",
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"path":     filePath,
+			"serverId": req.ServerID,
+			"path":     p.Path,
 			"size":     len(content),
-			"message":  "File uploaded successfully",
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleDownloadFile(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	filePath, ok := data["path"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid file path",
-		}
-	}
-
-	// Build the full path
-	serverDir := filepath.Join("/opt/gameservers", serverID)
-	fullPath := filepath.Join(serverDir, filePath)
-
-	// Ensure we don't go outside the server directory
-	if !strings.HasPrefix(fullPath, serverDir) {
-		return CommandResponse{
-			Success: false,
-			Error:   "Invalid path - cannot access files outside server directory",
-		}
+func (s *Server) handleDownloadFile(ctx context.Context, req *command.Request, p *messages.FilePayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
+	defer dir.Close()
 
 	// Read the file
-	content, err := os.ReadFile(fullPath)
+	content, err := dir.ReadFile(p.Path)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to read file: %v", err),
-		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Get file info
-	info, err := os.Stat(fullPath)
+	info, err := dir.Stat(p.Path)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to get file info: %v", err),
-		}
+		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// Encode content as base64 for safe transport
-	contentB64 := base64.StdEncoding.EncodeToString(content)
-
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"path":     filePath,
-			"content":  contentB64,
+			"serverId": req.ServerID,
+			"path":     p.Path,
+			// Encode content as base64 for safe transport
+			"content":  base64.StdEncoding.EncodeToString(content),
 			"size":     info.Size(),
 			"modified": info.ModTime(),
 			"encoding": "base64",
 		},
+	}, nil
+}
+
+// write stores content at a path inside a server's data directory,
+// creating parent directories as needed
+func (fm *FileManager) write(serverID, path string, content []byte) error {
+	dir, err := fm.open(serverID)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if err := dir.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+// FileResult is the outcome for one path of a batch file operation
+type FileResult struct {
+	Path    string `json:"path"`
+	Target  string `json:"target,omitempty"` // where the path was moved to
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"` // panel error code, set with error
+}
+
+// fileResult records the outcome of one path
+func fileResult(p, target string, err error) FileResult {
+	if err != nil {
+		return FileResult{Path: p, Target: target, Error: err.Error(), Code: command.ErrorCode(err)}
+	}
+	return FileResult{Path: p, Target: target, Success: true}
+}
+
+// batchResult summarizes a batch file operation. It succeeds even when some
+// paths failed; each path reports its own outcome.
+func batchResult(serverID, done string, dryRun bool, results []FileResult) *command.Result {
+	succeeded := 0
+	for _, r := range results {
+		if r.Success {
+			succeeded++
+		}
+	}
+
+	message := fmt.Sprintf("%d of %d files %s", succeeded, len(results), done)
+	if dryRun {
+		message = fmt.Sprintf("Dry run: %d of %d files would be %s", succeeded, len(results), done)
+	}
+
+	return &command.Result{
+		Message: message,
+		Data: map[string]interface{}{
+			"serverId":  serverID,
+			"dryRun":    dryRun,
+			"results":   results,
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+		},
+	}
+}
+
+// singleResult reports a file operation on a single path
+func singleResult(serverID, message string, dryRun bool, data map[string]interface{}) *command.Result {
+	if dryRun {
+		message = "Dry run: " + message
+	}
+	data["serverId"] = serverID
+	data["dryRun"] = dryRun
+	return &command.Result{Message: message, Data: data}
+}
+
+func (s *Server) handleDeleteFiles(ctx context.Context, req *command.Request, p *messages.DeleteFilesPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	results := make([]FileResult, 0, len(p.Paths))
+	for _, target := range p.Paths {
+		err := dir.CheckRemove(target, p.Recursive)
+		if err == nil && !p.DryRun {
+			if p.Recursive {
+				err = dir.RemoveAll(target)
+			} else {
+				err = dir.Remove(target)
+			}
+		}
+		results = append(results, fileResult(target, "", err))
+	}
+
+	return batchResult(req.ServerID, "deleted", p.DryRun, results), nil
+}
+
+func (s *Server) handleRenameFile(ctx context.Context, req *command.Request, p *messages.FileTransferPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	if p.DryRun {
+		err = dir.CheckTransfer(p.From, p.To)
+	} else {
+		err = dir.Rename(p.From, p.To)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return singleResult(req.ServerID, "File renamed", p.DryRun, map[string]interface{}{
+		"from": p.From,
+		"to":   p.To,
+	}), nil
+}
+
+func (s *Server) handleMoveFiles(ctx context.Context, req *command.Request, p *messages.MoveFilesPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	info, err := dir.Stat(p.Destination)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &command.Error{Code: "INVALID_PATH", Message: fmt.Sprintf("destination %s is not a directory", p.Destination)}
+	}
+
+	results := make([]FileResult, 0, len(p.Paths))
+	for _, source := range p.Paths {
+		name, err := files.Clean(source)
+		if err != nil {
+			results = append(results, fileResult(source, "", err))
+			continue
+		}
+
+		target := path.Join("/", p.Destination, path.Base(name))
+		if p.DryRun {
+			err = dir.CheckTransfer(source, target)
+		} else {
+			err = dir.Rename(source, target)
+		}
+		results = append(results, fileResult(source, target, err))
+	}
+
+	return batchResult(req.ServerID, "moved", p.DryRun, results), nil
+}
+
+func (s *Server) handleCopyFile(ctx context.Context, req *command.Request, p *messages.FileTransferPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	if p.DryRun {
+		err = dir.CheckTransfer(p.From, p.To)
+	} else {
+		err = dir.Copy(p.From, p.To)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return singleResult(req.ServerID, "File copied", p.DryRun, map[string]interface{}{
+		"from": p.From,
+		"to":   p.To,
+	}), nil
+}
+
+func (s *Server) handleCreateDirectory(ctx context.Context, req *command.Request, p *messages.CreateDirectoryPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	// An existing directory is fine, a file in its place is not
+	info, err := dir.Stat(p.Path)
+	switch {
+	case err == nil && !info.IsDir():
+		return nil, fmt.Errorf("%s: %w", p.Path, fs.ErrExist)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	if !p.DryRun {
+		if err := dir.MkdirAll(p.Path, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return singleResult(req.ServerID, "Directory created", p.DryRun, map[string]interface{}{
+		"path": p.Path,
+	}), nil
+}
+
+func (s *Server) handleChmodFile(ctx context.Context, req *command.Request, p *messages.ChmodFilePayload) (*command.Result, error) {
+	// The mode was checked when the payload was validated
+	mode, err := p.FileMode()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	if _, err := dir.Stat(p.Path); err != nil {
+		return nil, err
+	}
+	if !p.DryRun {
+		if err := dir.Chmod(p.Path, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	return singleResult(req.ServerID, "File permissions changed", p.DryRun, map[string]interface{}{
+		"path": p.Path,
+		"mode": fmt.Sprintf("%04o", mode),
+	}), nil
+}
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"strings"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
 )
 
 // ModManager handles mod installation and management that the panel expects
@@ -22,6 +27,9 @@ func NewModManager(baseDir string) *ModManager {
 	}
 }
 
+// modsDir is the directory of mod info files inside a server's data directory
+const modsDir = "mods"
+
 // ModInfo represents information about an installed mod
 type ModInfo struct {
 	ID          string `json:"id"`
@@ -32,124 +40,77 @@ type ModInfo struct {
 }
 
 // Mod management operations that the panel expects
-func (s *Server) handleInstallMod(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	modID, ok := data["modId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid modId",
-		}
-	}
-
-	modURL, _ := data["modUrl"].(string)
-	modVersion, _ := data["version"].(string)
-
+func (s *Server) handleInstallMod(ctx context.Context, req *command.Request, p *messages.ModPayload) (*command.Result, error) {
 	// For now, simulate mod installation
 	// In a real implementation, this would download and install the mod
-	serverDir := filepath.Join("/opt/gameservers", serverID, "mods")
-	if err := os.MkdirAll(serverDir, 0755); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to create mods directory: %v", err),
-		}
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
+	defer dir.Close()
 
 	// Create a simple mod info file to track installation
-	modInfoPath := filepath.Join(serverDir, modID+".mod")
-	modContent := fmt.Sprintf("id=%s\nversion=%s\nurl=%s\ninstalled=true\n", modID, modVersion, modURL)
+	modInfoPath := path.Join(modsDir, p.ModID+".mod")
+	modContent := fmt.Sprintf("id=%s\nversion=%s\nurl=%s\ninstalled=true\n", p.ModID, p.Version, p.ModURL)
 
-	if err := os.WriteFile(modInfoPath, []byte(modContent), 0644); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to install mod: %v", err),
-		}
+	if err := dir.WriteFile(modInfoPath, []byte(modContent), 0644); err != nil {
+		return nil, fmt.Errorf("failed to install mod: %w", err)
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
+		Message: "Mod installed successfully",
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"modId":    modID,
-			"version":  modVersion,
-			"message":  "Mod installed successfully",
+			"serverId": req.ServerID,
+			"modId":    p.ModID,
+			"version":  p.Version,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleUninstallMod(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	modID, ok := data["modId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid modId",
-		}
+func (s *Server) handleUninstallMod(ctx context.Context, req *command.Request, p *messages.ModPayload) (*command.Result, error) {
+	// Remove the mod info file
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
+	defer dir.Close()
 
-	// Remove the mod info file
-	modInfoPath := filepath.Join("/opt/gameservers", serverID, "mods", modID+".mod")
-	if err := os.Remove(modInfoPath); err != nil && !os.IsNotExist(err) {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to uninstall mod: %v", err),
-		}
+	modInfoPath := path.Join(modsDir, p.ModID+".mod")
+	if err := dir.Remove(modInfoPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to uninstall mod: %w", err)
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
+		Message: "Mod uninstalled successfully",
 		Data: map[string]interface{}{
-			"serverId": serverID,
-			"modId":    modID,
-			"message":  "Mod uninstalled successfully",
+			"serverId": req.ServerID,
+			"modId":    p.ModID,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleListMods(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
+func (s *Server) handleListMods(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	dir, err := s.files.open(req.ServerID)
+	if err != nil {
+		return nil, err
 	}
-
-	modsDir := filepath.Join("/opt/gameservers", serverID, "mods")
+	defer dir.Close()
 
 	// Check if mods directory exists
-	if _, err := os.Stat(modsDir); os.IsNotExist(err) {
-		return CommandResponse{
-			Success: true,
+	if _, err := dir.Stat(modsDir); errors.Is(err, fs.ErrNotExist) {
+		return &command.Result{
 			Data: map[string]interface{}{
-				"serverId": serverID,
+				"serverId": req.ServerID,
 				"mods":     []ModInfo{},
 				"count":    0,
 			},
-		}
+		}, nil
 	}
 
 	// List all .mod files
-	entries, err := os.ReadDir(modsDir)
+	entries, err := dir.ReadDir(modsDir)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to list mods: %v", err),
-		}
+		return nil, fmt.Errorf("failed to list mods: %w", err)
 	}
 
 	var mods []ModInfo
@@ -158,8 +119,7 @@ func (s *Server) handleListMods(data map[string]interface{}) CommandResponse {
 			modID := strings.TrimSuffix(entry.Name(), ".mod")
 
 			// Read mod info from file
-			modInfoPath := filepath.Join(modsDir, entry.Name())
-			content, err := os.ReadFile(modInfoPath)
+			content, err := dir.ReadFile(path.Join(modsDir, entry.Name()))
 			if err != nil {
 				continue
 			}
@@ -194,12 +154,11 @@ func (s *Server) handleListMods(data map[string]interface{}) CommandResponse {
 		}
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"serverId": serverID,
+			"serverId": req.ServerID,
 			"mods":     mods,
 			"count":    len(mods),
 		},
-	}
+	}, nil
 }
@@ -10,36 +10,124 @@ import (
 	"strings"
 	"time"
 
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/config"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/health"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/signedurl"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
 )
 
 // Server provides REST API endpoints for the panel
 type Server struct {
 	config        *config.Config
 	dockerManager *docker.Manager
+	servers       *registry.Registry
+	states        *state.Machine
+	files         *FileManager
+	uploads       *files.Uploads
+	signer        *signedurl.Signer // nil when signed URLs are disabled
+	commands      *command.Registry
+	events        messages.EventSink
 }
 
-// NewServer creates a new API server
-func NewServer(cfg *config.Config, dockerManager *docker.Manager) *Server {
-	return &Server{
+// NewServer creates a new API server and registers its file, mod, status
+// and system actions in commands
+func NewServer(cfg *config.Config, dockerManager *docker.Manager, servers *registry.Registry, states *state.Machine, commands *command.Registry) *Server {
+	s := &Server{
 		config:        cfg,
 		dockerManager: dockerManager,
+		servers:       servers,
+		states:        states,
+		files:         NewFileManager(cfg.ServerDataDir, files.Owner{UID: cfg.ServerUID, GID: cfg.ServerGID}),
+		uploads:       files.NewUploads(cfg.ServerDataDir, files.Owner{UID: cfg.ServerUID, GID: cfg.ServerGID}, cfg.UploadTTL),
+		commands:      commands,
 	}
+	if cfg.SignedURLTTL > 0 {
+		s.signer = signedurl.NewSigner(cfg.Secret, cfg.SignedURLTTL)
+	}
+	s.registerCommands()
+	return s
+}
+
+// SetEventSink sets where progress of long-running file operations is reported
+func (s *Server) SetEventSink(events messages.EventSink) {
+	s.events = events
+}
+
+// registerCommands adds the actions served by the API server to the
+// command registry shared with the WebSocket client
+func (s *Server) registerCommands() {
+	s.commands.Register(
+		// Legacy Docker commands (for backward compatibility)
+		command.New("docker.list", s.handleDockerList),
+		command.New("docker.start", s.handleDockerStart),
+		command.New("docker.stop", s.handleDockerStop),
+		command.New("docker.remove", s.handleDockerRemove),
+		command.New("docker.inspect", s.handleDockerInspect),
+
+		// Server status commands
+		command.ForServer("get_server_status", s.handleGetServerStatus),
+		command.ForServer("get_server_metrics", s.handleGetServerMetrics),
+		command.New("list_servers", s.handleListServers),
+
+		// File management commands
+		command.ForServer("list_files", s.handleListFiles),
+		command.ForServer("read_file", s.handleReadFile),
+		command.ForServer("write_file", s.handleWriteFile),
+		command.ForServer("upload_file", s.handleUploadFile),
+		command.ForServer("download_file", s.handleDownloadFile),
+		command.ForServer("delete_files", s.handleDeleteFiles),
+		command.ForServer("rename_file", s.handleRenameFile),
+		command.ForServer("move_files", s.handleMoveFiles),
+		command.ForServer("copy_file", s.handleCopyFile),
+		command.ForServer("create_directory", s.handleCreateDirectory),
+		command.ForServer("chmod_file", s.handleChmodFile),
+		command.ForServer("upload_begin", s.handleUploadBegin),
+		command.ForServer("upload_status", s.handleUploadStatus),
+		command.ForServer("upload_complete", s.handleUploadComplete),
+		command.ForServer("upload_abort", s.handleUploadAbort),
+		command.ForServer("sign_file_url", s.handleSignFileURL),
+		command.ForServer("compress_files", s.handleCompressFiles),
+		command.ForServer("decompress_file", s.handleDecompressFile),
+
+		// Mod management commands
+		command.ForServer("install_mod", s.handleInstallMod),
+		command.ForServer("uninstall_mod", s.handleUninstallMod),
+		command.ForServer("list_mods", s.handleListMods),
+
+		// System commands
+		command.New("system.status", s.handleSystemStatus),
+		command.New("system.ping", s.handleSystemPing),
+	)
 }
 
-// CommandRequest represents a command request from the panel
+// CommandRequest represents a command request from the panel. The server
+// may be given at the top level or, as older panels do, inside data.
 type CommandRequest struct {
-	Action string                 `json:"action"`
-	Data   map[string]interface{} `json:"data"`
+	Action   string                 `json:"action"`
+	ServerID string                 `json:"serverId,omitempty"`
+	Data     map[string]interface{} `json:"data"`
 }
 
 // CommandResponse represents a command response to the panel
 type CommandResponse struct {
 	Success bool                   `json:"success"`
+	Message string                 `json:"message,omitempty"`
 	Data    map[string]interface{} `json:"data,omitempty"`
 	Error   string                 `json:"error,omitempty"`
+	Code    string                 `json:"code,omitempty"` // panel error code, set with error
+
+	Fields []messages.FieldError `json:"fields,omitempty"` // every invalid field of a VALIDATION_ERROR
+}
+
+// errorResponse reports a failed command with its panel error code
+func errorResponse(err error) CommandResponse {
+	info := command.ErrorInfo(err)
+	return CommandResponse{Success: false, Error: info.Message, Code: info.Code, Fields: info.Fields}
 }
 
 // authenticateRequest validates the request using the agent secret
@@ -86,243 +174,160 @@ func (s *Server) CommandHandler() http.HandlerFunc {
 		}
 
 		// Execute the command
-		response := s.executeCommand(cmdReq)
+		response := s.executeCommand(r.Context(), cmdReq)
 		s.sendResponse(w, response, http.StatusOK)
 	}
 }
 
-// executeCommand processes the command request
-func (s *Server) executeCommand(req CommandRequest) CommandResponse {
-	log.Printf("Executing command: %s", req.Action)
-
-	switch req.Action {
-	// Legacy Docker commands (for backward compatibility)
-	case "docker.list":
-		return s.handleDockerList()
-	case "docker.start":
-		return s.handleDockerStart(req.Data)
-	case "docker.stop":
-		return s.handleDockerStop(req.Data)
-	case "docker.remove":
-		return s.handleDockerRemove(req.Data)
-	case "docker.inspect":
-		return s.handleDockerInspect(req.Data)
-
-	// Server lifecycle commands (panel expected)
-	case "start_server":
-		return s.handleStartServer(req.Data)
-	case "stop_server":
-		return s.handleStopServer(req.Data)
-	case "restart_server":
-		return s.handleRestartServer(req.Data)
-	case "kill_server":
-		return s.handleKillServer(req.Data)
-	case "get_server_status":
-		return s.handleGetServerStatus(req.Data)
-	case "get_server_metrics":
-		return s.handleGetServerMetrics(req.Data)
-	case "list_servers":
-		return s.handleListServers()
-
-	// File management commands (panel expected)
-	case "list_files":
-		return s.handleListFiles(req.Data)
-	case "read_file":
-		return s.handleReadFile(req.Data)
-	case "write_file":
-		return s.handleWriteFile(req.Data)
-	case "upload_file":
-		return s.handleUploadFile(req.Data)
-	case "download_file":
-		return s.handleDownloadFile(req.Data)
-
-	// Mod management commands (panel expected)
-	case "install_mod":
-		return s.handleInstallMod(req.Data)
-	case "uninstall_mod":
-		return s.handleUninstallMod(req.Data)
-	case "list_mods":
-		return s.handleListMods(req.Data)
-
-	// System commands
-	case "system.status":
-		return s.handleSystemStatus()
-	case "system.ping":
-		return CommandResponse{
-			Success: true,
-			Data: map[string]interface{}{
-				"message":   "pong",
-				"timestamp": time.Now(),
-			},
+// executeCommand runs a command request through the command registry
+func (s *Server) executeCommand(ctx context.Context, cmdReq CommandRequest) CommandResponse {
+	log.Printf("Executing command: %s", cmdReq.Action)
+
+	req, err := commandRequest(cmdReq)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// Actions outlive the HTTP request, as they do over the WebSocket
+	result, err := s.commands.Execute(context.WithoutCancel(ctx), req)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return CommandResponse{Success: true, Message: result.Message, Data: result.Data}
+}
+
+// commandRequest converts an HTTP command request to a registry request,
+// lifting serverId out of data
+func commandRequest(cmdReq CommandRequest) (*command.Request, error) {
+	req := &command.Request{Action: cmdReq.Action, ServerID: cmdReq.ServerID}
+
+	data := make(map[string]interface{}, len(cmdReq.Data))
+	for key, value := range cmdReq.Data {
+		data[key] = value
+	}
+	if serverID, ok := data["serverId"]; ok {
+		id, isString := serverID.(string)
+		if !isString {
+			return nil, &messages.ValidationError{Fields: []messages.FieldError{{Field: "serverId", Message: "must be a string"}}}
 		}
-	default:
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Unknown action: %s", req.Action),
+		if req.ServerID == "" {
+			req.ServerID = id
 		}
+		delete(data, "serverId")
 	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	req.Payload = payload
+	return req, nil
 }
 
 // Docker command handlers
-func (s *Server) handleDockerList() CommandResponse {
-	ctx := context.Background()
+func (s *Server) handleDockerList(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
 	containers, err := s.dockerManager.ListContainers(ctx)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
+		return nil, err
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
 			"containers": containers,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleDockerStart(data map[string]interface{}) CommandResponse {
-	containerID, ok := data["containerId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid containerId",
-		}
-	}
-
-	ctx := context.Background()
-	err := s.dockerManager.StartContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
+func (s *Server) handleDockerStart(ctx context.Context, req *command.Request, p *messages.ContainerPayload) (*command.Result, error) {
+	if err := s.dockerManager.StartContainer(ctx, p.ContainerID); err != nil {
+		return nil, err
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"containerId": containerID,
+			"containerId": p.ContainerID,
 			"status":      "started",
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleDockerStop(data map[string]interface{}) CommandResponse {
-	containerID, ok := data["containerId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid containerId",
-		}
+func (s *Server) handleDockerStop(ctx context.Context, req *command.Request, p *messages.ContainerPayload) (*command.Result, error) {
+	if _, err := s.dockerManager.StopContainer(ctx, p.ContainerID, docker.StopOptions{}); err != nil {
+		return nil, err
 	}
 
-	ctx := context.Background()
-	err := s.dockerManager.StopContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"containerId": containerID,
+			"containerId": p.ContainerID,
 			"status":      "stopped",
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleDockerRemove(data map[string]interface{}) CommandResponse {
-	containerID, ok := data["containerId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid containerId",
-		}
-	}
-
-	ctx := context.Background()
-	err := s.dockerManager.RemoveContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
+func (s *Server) handleDockerRemove(ctx context.Context, req *command.Request, p *messages.ContainerPayload) (*command.Result, error) {
+	if err := s.dockerManager.RemoveContainer(ctx, p.ContainerID); err != nil {
+		return nil, err
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
-			"containerId": containerID,
+			"containerId": p.ContainerID,
 			"status":      "removed",
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleDockerInspect(data map[string]interface{}) CommandResponse {
-	containerID, ok := data["containerId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid containerId",
-		}
-	}
-
+func (s *Server) handleDockerInspect(ctx context.Context, req *command.Request, p *messages.ContainerPayload) (*command.Result, error) {
 	// For inspection, we'll get container info from the list
-	ctx := context.Background()
 	containers, err := s.dockerManager.ListContainers(ctx)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   err.Error(),
-		}
+		return nil, err
 	}
 
 	// Find the specific container
 	for _, container := range containers {
-		if container.ID == containerID || strings.HasPrefix(container.ID, containerID) {
-			return CommandResponse{
-				Success: true,
+		if container.ID == p.ContainerID || strings.HasPrefix(container.ID, p.ContainerID) {
+			return &command.Result{
 				Data: map[string]interface{}{
 					"container": container,
 				},
-			}
+			}, nil
 		}
 	}
 
-	return CommandResponse{
-		Success: false,
-		Error:   "Container not found",
-	}
+	return nil, fmt.Errorf("container %s not found", p.ContainerID)
 }
 
-func (s *Server) handleSystemStatus() CommandResponse {
+func (s *Server) handleSystemStatus(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
 	// Get system information
 	systemInfo := make(map[string]interface{})
 
 	// Get uptime
-	if output, err := exec.Command("uptime").Output(); err == nil {
+	if output, err := exec.CommandContext(ctx, "uptime").Output(); err == nil {
 		systemInfo["uptime"] = strings.TrimSpace(string(output))
 	}
 
 	// Get memory info
-	if output, err := exec.Command("free", "-h").Output(); err == nil {
+	if output, err := exec.CommandContext(ctx, "free", "-h").Output(); err == nil {
 		systemInfo["memory"] = strings.TrimSpace(string(output))
 	}
 
 	// Get disk info
-	if output, err := exec.Command("df", "-h").Output(); err == nil {
+	if output, err := exec.CommandContext(ctx, "df", "-h").Output(); err == nil {
 		systemInfo["disk"] = strings.TrimSpace(string(output))
 	}
 
-	return CommandResponse{
-		Success: true,
-		Data:    systemInfo,
-	}
+	return &command.Result{Data: systemInfo}, nil
+}
+
+func (s *Server) handleSystemPing(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	return &command.Result{
+		Data: map[string]interface{}{
+			"message":   "pong",
+			"timestamp": time.Now(),
+		},
+	}, nil
 }
 
 // sendResponse sends a JSON response
@@ -335,6 +340,22 @@ func (s *Server) sendResponse(w http.ResponseWriter, response CommandResponse, s
 	}
 }
 
+// withCORS adds CORS headers for browser requests and answers preflight requests
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Range, X-Chunk-SHA256")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Accept-Ranges")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r)
+	}
+}
+
 // StartServer starts the API server with health endpoint
 func (s *Server) StartServer(port string, healthServer *health.Server) error {
 	// Add health endpoint
@@ -343,23 +364,17 @@ func (s *Server) StartServer(port string, healthServer *health.Server) error {
 	// Add API command endpoint
 	http.HandleFunc("/api/command", s.CommandHandler())
 
-	// Add CORS headers for browser requests
-	http.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	// Add streamed file transfer endpoints
+	http.HandleFunc("/api/files/", withCORS(s.FileTransferHandler()))
 
+	// Add CORS headers for browser requests
+	http.HandleFunc("/api/", withCORS(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/api/command" {
 			s.CommandHandler()(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
-	})
+	}))
 
 	// Root redirects to health
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -3,10 +3,15 @@ package api
 import (
 	"context"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/config"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
 )
 
 // ServerLifecycleManager handles server-specific operations that the panel expects
@@ -21,6 +26,7 @@ type ServerInfo struct {
 	ContainerID string                 `json:"containerId"`
 	Name        string                 `json:"name"`
 	Status      string                 `json:"status"`
+	State       string                 `json:"state"`
 	Config      map[string]interface{} `json:"config"`
 	Metrics     map[string]interface{} `json:"metrics,omitempty"`
 }
@@ -33,170 +39,26 @@ func NewServerLifecycleManager(dockerManager *docker.Manager, config *config.Con
 	}
 }
 
-// Server lifecycle commands that the panel expects
-func (s *Server) handleStartServer(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	// For now, map serverId to containerID (this could be enhanced with a mapping table)
-	containerID := serverID
+// Server status commands that the panel expects. Power actions are served
+// by the WebSocket client through the shared command registry.
+func (s *Server) handleGetServerStatus(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	serverID := req.ServerID
+	containerID := s.servers.Resolve(ctx, serverID)
 
-	ctx := context.Background()
-	err := s.dockerManager.StartContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to start server %s: %v", serverID, err),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"serverId": serverID,
-			"status":   "starting",
-			"message":  "Server start command issued successfully",
-		},
-	}
-}
-
-func (s *Server) handleStopServer(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	containerID := serverID
-
-	ctx := context.Background()
-	err := s.dockerManager.StopContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to stop server %s: %v", serverID, err),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"serverId": serverID,
-			"status":   "stopping",
-			"message":  "Server stop command issued successfully",
-		},
-	}
-}
-
-func (s *Server) handleRestartServer(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	containerID := serverID
-
-	ctx := context.Background()
-
-	// Stop the container first
-	if err := s.dockerManager.StopContainer(ctx, containerID); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to stop server %s during restart: %v", serverID, err),
-		}
-	}
-
-	// Wait a moment for graceful shutdown
-	time.Sleep(2 * time.Second)
-
-	// Start the container again
-	if err := s.dockerManager.StartContainer(ctx, containerID); err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to start server %s during restart: %v", serverID, err),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"serverId": serverID,
-			"status":   "restarting",
-			"message":  "Server restart command issued successfully",
-		},
-	}
-}
-
-func (s *Server) handleKillServer(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	containerID := serverID
-
-	ctx := context.Background()
-
-	// Force remove the container (equivalent to kill)
-	err := s.dockerManager.RemoveContainer(ctx, containerID)
-	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to kill server %s: %v", serverID, err),
-		}
-	}
-
-	return CommandResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"serverId": serverID,
-			"status":   "killed",
-			"message":  "Server killed successfully",
-		},
-	}
-}
-
-func (s *Server) handleGetServerStatus(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
-	}
-
-	containerID := serverID
-
-	ctx := context.Background()
 	containers, err := s.dockerManager.ListContainers(ctx)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to get server status: %v", err),
-		}
+		return nil, fmt.Errorf("failed to get server status: %w", err)
 	}
 
 	// Find the specific container/server
 	for _, container := range containers {
-		if container.ID == containerID || container.Names[0] == "/"+serverID {
+		if matchesContainer(container, containerID) {
 			serverInfo := ServerInfo{
 				ServerID:    serverID,
 				ContainerID: container.ID,
 				Name:        container.Names[0],
 				Status:      container.State,
+				State:       string(s.states.Get(serverID)),
 				Config: map[string]interface{}{
 					"image":   container.Image,
 					"ports":   container.Ports,
@@ -204,64 +66,93 @@ func (s *Server) handleGetServerStatus(data map[string]interface{}) CommandRespo
 				},
 			}
 
-			return CommandResponse{
-				Success: true,
+			return &command.Result{
 				Data: map[string]interface{}{
 					"server": serverInfo,
 				},
-			}
+			}, nil
 		}
 	}
 
-	return CommandResponse{
-		Success: false,
-		Error:   fmt.Sprintf("Server %s not found", serverID),
-	}
+	return nil, fmt.Errorf("server %s not found", serverID)
 }
 
-func (s *Server) handleGetServerMetrics(data map[string]interface{}) CommandResponse {
-	serverID, ok := data["serverId"].(string)
-	if !ok {
-		return CommandResponse{
-			Success: false,
-			Error:   "Missing or invalid serverId",
-		}
+func (s *Server) handleGetServerMetrics(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	serverID := req.ServerID
+	containerID := s.servers.Resolve(ctx, serverID)
+
+	info, err := s.dockerManager.InspectContainer(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server metrics: %w", err)
+	}
+
+	status := ""
+	if info.State != nil {
+		status = info.State.Status
 	}
 
-	// For now, return basic metrics - this could be enhanced with real Docker stats
 	metrics := map[string]interface{}{
 		"serverId":     serverID,
 		"timestamp":    time.Now(),
-		"cpu_usage":    "0%", // Placeholder - would need Docker stats API
-		"memory_usage": "0MB",
-		"network_in":   "0B",
-		"network_out":  "0B",
-		"uptime":       "unknown",
+		"status":       status,
+		"cpu_usage":    0.0,
+		"memory_usage": int64(0),
+		"memory_limit": int64(0),
+		"network_in":   int64(0),
+		"network_out":  int64(0),
+		"block_read":   int64(0),
+		"block_write":  int64(0),
+		"pids":         uint64(0),
+		"uptime":       "0s",
 		"player_count": 0, // Game-specific metric
 	}
 
-	return CommandResponse{
-		Success: true,
+	if info.State != nil && info.State.Running {
+		if startedAt, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil {
+			metrics["uptime"] = time.Since(startedAt).Round(time.Second).String()
+		}
+
+		// A failed sample is reported with the metrics rather than failing them
+		stats, err := s.dockerManager.GetServerStats(ctx, info.ID)
+		if err != nil {
+			log.Printf("Failed to get stats of server %s: %v", serverID, err)
+			metrics["stats_error"] = err.Error()
+		} else {
+			metrics["timestamp"] = stats.Timestamp
+			metrics["cpu_usage"] = stats.CPUPercent
+			metrics["memory_usage"] = stats.MemoryUsage
+			metrics["memory_limit"] = stats.MemoryLimit
+			metrics["network_in"] = stats.NetworkRx
+			metrics["network_out"] = stats.NetworkTx
+			metrics["block_read"] = stats.BlockRead
+			metrics["block_write"] = stats.BlockWrite
+			metrics["pids"] = stats.PIDs
+		}
+	}
+
+	return &command.Result{
 		Data: map[string]interface{}{
 			"metrics": metrics,
 		},
-	}
+	}, nil
 }
 
-func (s *Server) handleListServers() CommandResponse {
-	ctx := context.Background()
+func (s *Server) handleListServers(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
 	containers, err := s.dockerManager.ListContainers(ctx)
 	if err != nil {
-		return CommandResponse{
-			Success: false,
-			Error:   fmt.Sprintf("Failed to list servers: %v", err),
-		}
+		return nil, fmt.Errorf("failed to list servers: %w", err)
 	}
 
 	var servers []ServerInfo
 	for _, container := range containers {
+		// Managed containers carry their server ID; fall back to the container name
+		serverID := container.Labels[docker.LabelServerID]
+		if serverID == "" {
+			serverID = container.Names[0]
+		}
+
 		serverInfo := ServerInfo{
-			ServerID:    container.Names[0], // Use container name as server ID
+			ServerID:    serverID,
 			ContainerID: container.ID,
 			Name:        container.Names[0],
 			Status:      container.State,
@@ -274,11 +165,23 @@ func (s *Server) handleListServers() CommandResponse {
 		servers = append(servers, serverInfo)
 	}
 
-	return CommandResponse{
-		Success: true,
+	return &command.Result{
 		Data: map[string]interface{}{
 			"servers": servers,
 			"count":   len(servers),
 		},
+	}, nil
+}
+
+// matchesContainer reports whether a container summary matches a container ID, ID prefix or name
+func matchesContainer(summary container.Summary, ref string) bool {
+	if summary.ID == ref || strings.HasPrefix(summary.ID, ref) {
+		return true
+	}
+	for _, name := range summary.Names {
+		if name == "/"+ref {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/signedurl"
+)
+
+// uploadData reports the progress of an upload
+func uploadData(info files.UploadInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"serverId":  info.ServerID,
+		"uploadId":  info.ID,
+		"path":      info.Path,
+		"size":      info.Size,
+		"offset":    info.Offset,
+		"expiresAt": info.ExpiresAt,
+	}
+}
+
+func (s *Server) handleUploadBegin(ctx context.Context, req *command.Request, p *messages.UploadBeginPayload) (*command.Result, error) {
+	info, err := s.uploads.Begin(req.ServerID, p.Path, p.Size, p.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	data := uploadData(info)
+	data["chunkSize"] = files.MaxChunkSize
+	return &command.Result{Message: "Upload started", Data: data}, nil
+}
+
+func (s *Server) handleUploadStatus(ctx context.Context, req *command.Request, p *messages.UploadPayload) (*command.Result, error) {
+	info, err := s.uploads.Status(req.ServerID, p.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	return &command.Result{Data: uploadData(info)}, nil
+}
+
+func (s *Server) handleUploadComplete(ctx context.Context, req *command.Request, p *messages.UploadPayload) (*command.Result, error) {
+	info, err := s.uploads.Complete(req.ServerID, p.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	return &command.Result{Message: "File uploaded successfully", Data: uploadData(info)}, nil
+}
+
+func (s *Server) handleUploadAbort(ctx context.Context, req *command.Request, p *messages.UploadPayload) (*command.Result, error) {
+	if err := s.uploads.Abort(req.ServerID, p.UploadID); err != nil {
+		return nil, err
+	}
+	return &command.Result{
+		Message: "Upload aborted",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"uploadId": p.UploadID,
+		},
+	}, nil
+}
+
+// HandleUploadFrame writes the chunk carried by a binary WebSocket frame.
+// It returns the ID of the panel command the frame answers.
+func (s *Server) HandleUploadFrame(r io.Reader) (string, *command.Result, error) {
+	header, err := messages.ReadUploadChunkHeader(r)
+	if err != nil {
+		return "", nil, &command.Error{Code: "INVALID_FRAME", Message: err.Error()}
+	}
+
+	info, err := s.uploads.WriteChunk(header.ServerID, header.UploadID, header.Offset, r, header.SHA256)
+	if err != nil {
+		return header.ID, nil, err
+	}
+	return header.ID, &command.Result{Message: "Chunk received", Data: uploadData(info)}, nil
+}
+
+func (s *Server) handleSignFileURL(ctx context.Context, req *command.Request, p *messages.SignFileURLPayload) (*command.Result, error) {
+	if s.signer == nil {
+		return nil, &command.Error{Code: "SIGNED_URLS_DISABLED", Message: "signed URLs are disabled on this node"}
+	}
+
+	ttl := s.signer.MaxTTL()
+	if p.ExpiresIn > 0 {
+		ttl = time.Duration(p.ExpiresIn) * time.Second
+	}
+	if ttl > s.signer.MaxTTL() {
+		return nil, &messages.ValidationError{Fields: []messages.FieldError{{
+			Field:   "expiresIn",
+			Message: fmt.Sprintf("must be at most %d seconds", int(s.signer.MaxTTL().Seconds())),
+		}}}
+	}
+
+	var method, endpoint, filePath string
+	switch p.Operation {
+	case signedurl.OpDownload:
+		dir, err := s.files.open(req.ServerID)
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
+		if _, err := dir.Stat(p.Path); err != nil {
+			return nil, err
+		}
+		method, endpoint, filePath = http.MethodGet, "/api/files/download", p.Path
+
+	case signedurl.OpUpload:
+		info, err := s.uploads.Status(req.ServerID, p.UploadID)
+		if err != nil {
+			return nil, err
+		}
+		method, endpoint, filePath = http.MethodPut, "/api/files/uploads/"+url.PathEscape(info.ID), info.Path
+	}
+
+	expires := time.Now().Add(ttl)
+	query := s.signer.Sign(p.Operation, req.ServerID, filePath, expires)
+	return &command.Result{
+		Message: "Signed URL created",
+		Data: map[string]interface{}{
+			"serverId":  req.ServerID,
+			"operation": p.Operation,
+			"path":      filePath,
+			"method":    method,
+			"url":       endpoint + "?" + query.Encode(),
+			"expiresAt": time.Unix(expires.Unix(), 0).UTC(),
+		},
+	}, nil
+}
+
+// FileTransferHandler serves chunk uploads and streamed downloads, which
+// carry raw file data instead of JSON commands
+func (s *Server) FileTransferHandler() http.HandlerFunc {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/files/uploads/{uploadId}", s.transferAuth(signedurl.OpUpload, s.handleUploadChunk))
+	mux.HandleFunc("GET /api/files/download", s.transferAuth(signedurl.OpDownload, s.handleStreamDownload))
+	return mux.ServeHTTP
+}
+
+// transferAuth admits requests carrying the agent secret, or a URL signed
+// for operation so a browser can transfer a file without the secret
+func (s *Server) transferAuth(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticateRequest(r) {
+			next(w, r)
+			return
+		}
+		if s.signer == nil || !r.URL.Query().Has("signature") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if _, _, err := s.signer.Verify(operation, r.URL.Query()); err != nil {
+			s.sendTransferError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleUploadChunk writes the request body as the chunk of an upload
+// starting at the offset query parameter
+func (s *Server) handleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	serverID := query.Get("serverId")
+	offset, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+
+	var fields []messages.FieldError
+	if serverID == "" {
+		fields = append(fields, messages.FieldError{Field: "serverId", Message: "is required"})
+	}
+	if err != nil || offset < 0 {
+		fields = append(fields, messages.FieldError{Field: "offset", Message: "must be a non-negative integer"})
+	}
+	if len(fields) > 0 {
+		s.sendTransferError(w, &messages.ValidationError{Fields: fields})
+		return
+	}
+
+	// A signed URL only grants the upload writing to its path
+	uploadID := r.PathValue("uploadId")
+	if query.Has("signature") {
+		if err := s.checkUploadPath(serverID, uploadID, query.Get("path")); err != nil {
+			s.sendTransferError(w, err)
+			return
+		}
+	}
+
+	info, err := s.uploads.WriteChunk(serverID, uploadID, offset, r.Body, r.Header.Get("X-Chunk-SHA256"))
+	if err != nil {
+		s.sendTransferError(w, err)
+		return
+	}
+
+	s.sendResponse(w, CommandResponse{Success: true, Message: "Chunk received", Data: uploadData(info)}, http.StatusOK)
+}
+
+// checkUploadPath verifies that an upload writes to filePath
+func (s *Server) checkUploadPath(serverID, uploadID, filePath string) error {
+	info, err := s.uploads.Status(serverID, uploadID)
+	if err != nil {
+		return err
+	}
+	want, _ := files.Clean(info.Path)
+	if got, err := files.Clean(filePath); err != nil || got != want {
+		return fmt.Errorf("upload %s does not write to %s: %w", uploadID, filePath, signedurl.ErrInvalidSignature)
+	}
+	return nil
+}
+
+// handleStreamDownload streams a file, honouring Range requests, without
+// reading it into memory
+func (s *Server) handleStreamDownload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	serverID, filePath := query.Get("serverId"), query.Get("path")
+
+	var fields []messages.FieldError
+	if serverID == "" {
+		fields = append(fields, messages.FieldError{Field: "serverId", Message: "is required"})
+	}
+	if filePath == "" {
+		fields = append(fields, messages.FieldError{Field: "path", Message: "is required"})
+	}
+	if len(fields) > 0 {
+		s.sendTransferError(w, &messages.ValidationError{Fields: fields})
+		return
+	}
+
+	dir, err := s.files.open(serverID)
+	if err != nil {
+		s.sendTransferError(w, err)
+		return
+	}
+	defer dir.Close()
+
+	f, err := dir.Open(filePath)
+	if err != nil {
+		s.sendTransferError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		s.sendTransferError(w, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		s.sendTransferError(w, &command.Error{Code: "INVALID_PATH", Message: fmt.Sprintf("%s is not a regular file", filePath)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(info.Name())}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// sendTransferError reports a failed transfer with an HTTP status matching
+// its panel error code
+func (s *Server) sendTransferError(w http.ResponseWriter, err error) {
+	response := errorResponse(err)
+
+	// Tell the client where to resume
+	var offsetErr *files.OffsetError
+	if errors.As(err, &offsetErr) {
+		response.Data = map[string]interface{}{"offset": offsetErr.Offset}
+	}
+
+	s.sendResponse(w, response, transferStatus(response.Code))
+}
+
+// transferStatus maps a panel error code to the HTTP status of a transfer endpoint
+func transferStatus(code string) int {
+	switch code {
+	case "VALIDATION_ERROR", "INVALID_PATH":
+		return http.StatusBadRequest
+	case "UPLOAD_NOT_FOUND", "FILE_NOT_FOUND":
+		return http.StatusNotFound
+	case "UPLOAD_OFFSET_MISMATCH":
+		return http.StatusConflict
+	case "CHUNK_TOO_LARGE":
+		return http.StatusRequestEntityTooLarge
+	case "CHECKSUM_MISMATCH":
+		return http.StatusUnprocessableEntity
+	case "INVALID_SIGNATURE", "URL_EXPIRED":
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -2,9 +2,14 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	mathrand "math/rand/v2"
 	"net/http"
 	"net/url"
 	"runtime"
@@ -13,39 +18,105 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/config"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/console"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
 )
 
+const (
+	// reconnectBaseDelay is the delay before the first redial attempt
+	reconnectBaseDelay = 1 * time.Second
+	// reconnectMaxDelay caps the exponential backoff between redial attempts
+	reconnectMaxDelay = 60 * time.Second
+	// heartbeatInterval is how often heartbeats and pings are sent
+	heartbeatInterval = 30 * time.Second
+	// pongWait is how long the connection may stay silent before it is considered dead
+	pongWait = 3 * heartbeatInterval
+	// writeWait bounds a single write to the panel, so a stalled connection
+	// fails instead of blocking every sender
+	writeWait = 10 * time.Second
+	// stableSessionDuration is how long a session must last to reset the backoff
+	stableSessionDuration = 30 * time.Second
+	// maxPendingEvents bounds the number of events buffered while offline
+	maxPendingEvents = 1000
+)
+
+// errExitedBeforeReady fails a start whose container exits before the
+// server prints its done pattern
+var errExitedBeforeReady = errors.New("server exited before it was ready")
+
+// volatileEvents are high-frequency events that are stale by the time the
+// panel reconnects, so they are dropped instead of buffered while offline
+var volatileEvents = map[string]bool{
+	"server_output":       true,
+	"server_stats":        true,
+	"image_pull_progress": true,
+}
+
 // Client represents the WebSocket client for panel communication
 type Client struct {
 	config        *config.Config
 	conn          *websocket.Conn
 	dockerManager *docker.Manager
+	servers       *registry.Registry
+	states        *state.Machine
+	consoles      *console.Hub
+	commands      *command.Registry
 	handlers      map[messages.MessageType]MessageHandler
 	mu            sync.RWMutex
 	ctx           context.Context
 	cancel        context.CancelFunc
+
+	// sessionID identifies this agent process to the panel across reconnects
+	sessionID string
+	// online is true once system info and pending events have been sent on the current connection
+	online bool
+	// pendingEvents holds events produced while disconnected, replayed on reconnect
+	pendingEvents []*messages.AgentEvent
+	// onConnectionChange is notified on every connect/disconnect transition
+	onConnectionChange func(connected bool)
+	// binaryHandler handles binary frames, which carry upload chunks
+	binaryHandler BinaryHandler
+	// onStop is notified when an operator stops or kills a server
+	onStop func(serverID string)
+	// onDelete is notified when a server has been deleted
+	onDelete func(serverID string)
 }
 
 // MessageHandler defines the interface for handling messages
 type MessageHandler func(ctx context.Context, msg *messages.Message) error
 
-// NewClient creates a new WebSocket client
-func NewClient(cfg *config.Config, dockerManager *docker.Manager) *Client {
+// BinaryHandler handles a binary frame read from r and returns the ID of
+// the panel command its response answers
+type BinaryHandler func(r io.Reader) (id string, result *command.Result, err error)
+
+// NewClient creates a new WebSocket client and registers its server
+// lifecycle and console actions in commands
+func NewClient(cfg *config.Config, dockerManager *docker.Manager, servers *registry.Registry, states *state.Machine, commands *command.Registry) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	client := &Client{
 		config:        cfg,
 		dockerManager: dockerManager,
+		servers:       servers,
+		states:        states,
+		commands:      commands,
 		handlers:      make(map[messages.MessageType]MessageHandler),
 		ctx:           ctx,
 		cancel:        cancel,
+		sessionID:     newSessionID(),
 	}
 
+	client.consoles = console.NewHub(dockerManager, client)
+	states.SetNotifier(client.statusChanged)
+
 	// Register message handlers
 	client.registerHandlers()
+	client.registerCommands()
 
 	return client
 }
@@ -60,37 +131,58 @@ func (c *Client) Connect() error {
 	header := make(http.Header)
 	header.Set("Authorization", "Bearer "+c.config.Secret)
 	header.Set("X-Node-Id", c.config.NodeID)
+	header.Set("X-Agent-Session", c.sessionID)
 
 	log.Printf("Connecting to panel at %s", u.String())
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, u.String(), header)
 	if err != nil {
 		return err
 	}
 
+	c.mu.Lock()
 	c.conn = conn
+	c.mu.Unlock()
 	log.Println("Successfully connected to panel")
 
 	return nil
 }
 
-// Start begins the client's main loop
-func (c *Client) Start() error {
-	if c.conn == nil {
-		return &ClientError{Code: "NOT_CONNECTED", Message: "Not connected to panel"}
-	}
+// SetConnectionHandler registers a callback invoked on every connection status change
+func (c *Client) SetConnectionHandler(fn func(connected bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnectionChange = fn
+}
 
-	// Start message reader
-	go c.readMessages()
+// SetBinaryHandler registers the handler of binary frames
+func (c *Client) SetBinaryHandler(fn BinaryHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.binaryHandler = fn
+}
 
-	// Start heartbeat sender
-	go c.sendHeartbeat()
+// SetStopHandler registers a callback invoked when an operator stops or
+// kills a server, before the server is reported offline
+func (c *Client) SetStopHandler(fn func(serverID string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onStop = fn
+}
 
-	// Send initial system info
-	if err := c.sendSystemInfo(); err != nil {
-		log.Printf("Failed to send system info: %v", err)
-	}
+// SetDeleteHandler registers a callback invoked once a server's container
+// has been removed, to drop whatever else is kept about the server
+func (c *Client) SetDeleteHandler(fn func(serverID string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDelete = fn
+}
 
+// Start begins the client's supervised connection loop. If the client is not
+// connected yet, the loop keeps dialing the panel in the background with
+// jittered exponential backoff, so Start never fails because the panel is down.
+func (c *Client) Start() error {
+	go c.superviseConnection()
 	return nil
 }
 
@@ -99,6 +191,10 @@ func (c *Client) Stop() {
 	log.Println("Shutting down client...")
 
 	c.cancel()
+	c.consoles.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if c.conn != nil {
 		// Send close message
@@ -114,8 +210,149 @@ func (c *Client) Stop() {
 	}
 }
 
-// sendMessage sends a message to the panel
-func (c *Client) sendMessage(msg *messages.Message) error {
+// superviseConnection keeps a panel session alive until the client is stopped
+func (c *Client) superviseConnection() {
+	attempt := 0
+
+	for c.ctx.Err() == nil {
+		c.mu.RLock()
+		connected := c.conn != nil
+		c.mu.RUnlock()
+
+		if !connected {
+			if err := c.Connect(); err != nil {
+				delay := backoffDelay(attempt)
+				attempt++
+				log.Printf("Could not connect to panel: %v (retrying in %s)", err, delay)
+
+				select {
+				case <-c.ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+		}
+
+		started := time.Now()
+		c.runSession()
+
+		// A session that dies right after connecting counts as a failed attempt
+		if time.Since(started) >= stableSessionDuration {
+			attempt = 0
+			continue
+		}
+
+		delay := backoffDelay(attempt)
+		attempt++
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runSession serves a single panel connection and returns once it is lost
+func (c *Client) runSession() {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	sessionCtx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	c.notifyConnectionChange(true)
+
+	// Send system info first so the panel knows who we are before any replayed events
+	if err := c.sendSystemInfo(); err != nil {
+		log.Printf("Failed to send system info: %v", err)
+	}
+	c.resumeSession()
+
+	go c.sendHeartbeat(sessionCtx, conn)
+
+	c.readMessages(conn)
+
+	c.mu.Lock()
+	c.online = false
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	// Console subscriptions belong to the panel session; it resubscribes on reconnect
+	c.consoles.UnsubscribeAll()
+
+	if c.ctx.Err() == nil {
+		if err := conn.Close(); err != nil {
+			log.Printf("Error closing WebSocket connection: %v", err)
+		}
+		log.Println("Lost connection to panel, reconnecting...")
+	}
+	c.notifyConnectionChange(false)
+}
+
+// resumeSession replays events produced while offline and marks the session online
+func (c *Client) resumeSession() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pendingEvents) > 0 {
+		log.Printf("Replaying %d events produced while offline", len(c.pendingEvents))
+	}
+
+	for i, evt := range c.pendingEvents {
+		data, err := evt.ToJSON()
+		if err != nil {
+			log.Printf("Error marshaling event: %v", err)
+			continue
+		}
+		if err := c.writeLocked(data); err != nil {
+			log.Printf("Error replaying event: %v", err)
+			c.pendingEvents = c.pendingEvents[i:]
+			return
+		}
+	}
+
+	c.pendingEvents = nil
+	c.online = true
+}
+
+// notifyConnectionChange invokes the connection handler, if any
+func (c *Client) notifyConnectionChange(connected bool) {
+	c.mu.RLock()
+	fn := c.onConnectionChange
+	c.mu.RUnlock()
+
+	if fn != nil {
+		fn(connected)
+	}
+}
+
+// backoffDelay returns the jittered exponential delay for the given redial attempt
+func backoffDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+	}
+
+	// Equal jitter: wait at least half the delay so redials never stampede
+	half := delay / 2
+	return half + mathrand.N(half+1)
+}
+
+// newSessionID returns a random identifier for this agent process
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// writeRaw writes a text frame to the current connection
+func (c *Client) writeRaw(data []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -123,30 +360,67 @@ func (c *Client) sendMessage(msg *messages.Message) error {
 		return &ClientError{Code: "NOT_CONNECTED", Message: "Not connected to panel"}
 	}
 
+	return c.writeLocked(data)
+}
+
+// writeLocked writes a text frame to the current connection within
+// writeWait. Callers must hold c.mu and have checked c.conn.
+func (c *Client) writeLocked(data []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// sendMessage sends a message to the panel
+func (c *Client) sendMessage(msg *messages.Message) error {
 	data, err := msg.ToJSON()
 	if err != nil {
 		return err
 	}
 
-	return c.conn.WriteMessage(websocket.TextMessage, data)
+	return c.writeRaw(data)
 }
 
-// readMessages reads incoming messages from the panel
-func (c *Client) readMessages() {
+// readMessages reads incoming messages from the panel until the connection fails
+func (c *Client) readMessages(conn *websocket.Conn) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Message reader panic: %v", r)
 		}
 	}()
 
+	// Any frame, including pongs, proves the connection is still alive
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			_, data, err := c.conn.ReadMessage()
+			msgType, r, err := conn.NextReader()
 			if err != nil {
-				log.Printf("Error reading message: %v", err)
+				if c.ctx.Err() == nil {
+					log.Printf("Error reading message: %v", err)
+				}
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+
+			// Binary frames are streamed to their handler, not buffered
+			if msgType == websocket.BinaryMessage {
+				c.handleBinary(r)
+				continue
+			}
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				if c.ctx.Err() == nil {
+					log.Printf("Error reading message: %v", err)
+				}
 				return
 			}
 
@@ -163,6 +437,27 @@ func (c *Client) readMessages() {
 	}
 }
 
+// handleBinary passes a binary frame to the binary handler and answers the
+// panel command it carries
+func (c *Client) handleBinary(r io.Reader) {
+	c.mu.RLock()
+	handler := c.binaryHandler
+	c.mu.RUnlock()
+
+	if handler == nil {
+		log.Printf("Ignoring binary frame: no handler")
+		return
+	}
+
+	id, result, err := handler(r)
+	if err != nil {
+		log.Printf("Error handling binary frame: %v", err)
+		c.sendResponse(id, false, "", nil, command.ErrorInfo(err))
+		return
+	}
+	c.sendResponse(id, true, result.Message, result.Data, nil)
+}
+
 // handleMessage handles incoming messages
 func (c *Client) handleMessage(msg *messages.Message) {
 	// Check for new Panel Issue #27 command format
@@ -197,16 +492,21 @@ func (c *Client) handleMessage(msg *messages.Message) {
 	}()
 }
 
-// sendHeartbeat sends periodic heartbeat messages
-func (c *Client) sendHeartbeat() {
-	ticker := time.NewTicker(30 * time.Second)
+// sendHeartbeat sends periodic heartbeat messages and pings for the given session
+func (c *Client) sendHeartbeat(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			deadline := time.Now().Add(10 * time.Second)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Printf("Error sending ping: %v", err)
+			}
+
 			heartbeatData := &messages.HeartbeatData{
 				NodeID:    c.config.NodeID,
 				Timestamp: time.Now(),
@@ -274,6 +574,197 @@ func (c *Client) registerHandlers() {
 	c.handlers[messages.TypeServerCommand] = c.handleServerCommand
 }
 
+// recordServer stores a newly created server in the registry. Registry
+// credentials are only needed for the pull and are not kept.
+func (c *Client) recordServer(containerID string, cfg *docker.ServerConfig) {
+	stored := *cfg
+	stored.RegistryAuth = nil
+	err := c.servers.Put(&registry.Record{
+		ServerID:     cfg.ServerID,
+		ContainerID:  containerID,
+		Config:       stored,
+		DesiredState: registry.DesiredStopped,
+	})
+	if err != nil {
+		log.Printf("Failed to record server %s in registry: %v", cfg.ServerID, err)
+	}
+}
+
+// setDesiredState updates the desired state of a registered server
+func (c *Client) setDesiredState(serverID string, state registry.DesiredState) {
+	err := c.servers.SetDesiredState(serverID, state)
+	if err != nil && err != registry.ErrServerNotFound {
+		log.Printf("Failed to update desired state of server %s: %v", serverID, err)
+	}
+}
+
+// forgetServer removes a deleted server from the registry
+func (c *Client) forgetServer(serverID string) {
+	if err := c.servers.Delete(serverID); err != nil {
+		log.Printf("Failed to remove server %s from registry: %v", serverID, err)
+	}
+}
+
+// WatchConsole follows the console of a server whose container started
+func (c *Client) WatchConsole(serverID, containerID string) {
+	c.consoles.Watch(serverID, containerID)
+}
+
+// ReadyCheck returns the wait that keeps a starting server in the starting
+// state until its console prints the done pattern, or nil if the server
+// has none. A server that does not print it within its ready timeout is
+// considered running anyway; one whose container exits first has crashed.
+func (c *Client) ReadyCheck(serverID, containerID string) func(details map[string]interface{}) error {
+	info, err := c.dockerManager.InspectContainer(c.ctx, containerID)
+	if err != nil || info.Config == nil {
+		return nil
+	}
+	pattern, timeout := docker.ParseReadyCheck(info.Config.Labels, c.config.ReadyTimeout)
+	if pattern == nil || timeout == 0 {
+		return nil
+	}
+	since := time.Now()
+
+	return func(details map[string]interface{}) error {
+		ctx, cancel := context.WithTimeout(c.ctx, timeout)
+		defer cancel()
+
+		for {
+			err := c.consoles.WaitReady(ctx, serverID, containerID, pattern, since)
+			switch {
+			case err == nil:
+				details["ready"] = true
+				return nil
+			case errors.Is(err, context.DeadlineExceeded):
+				log.Printf("Server %s did not print its done pattern within %s", serverID, timeout)
+				details["readyTimeout"] = true
+				return nil
+			case !errors.Is(err, console.ErrConsoleClosed):
+				return err
+			}
+
+			// The log stream ended: either the server exited, or the
+			// stream dropped and is resumed
+			info, err := c.dockerManager.InspectContainer(ctx, containerID)
+			if err == nil && info.ContainerJSONBase != nil && info.State != nil && !info.State.Running {
+				return errExitedBeforeReady
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// StartServer starts a server's container, records it as desired running
+// and follows its console. The server stays starting until it is ready.
+func (c *Client) StartServer(ctx context.Context, serverID string) error {
+	containerID := c.servers.Resolve(ctx, serverID)
+	return c.states.DoWait(serverID, state.Starting, state.Running, func(map[string]interface{}) error {
+		if err := c.dockerManager.StartContainer(ctx, containerID); err != nil {
+			return err
+		}
+		c.setDesiredState(serverID, registry.DesiredRunning)
+		c.consoles.Watch(serverID, containerID)
+		return nil
+	}, c.ReadyCheck(serverID, containerID))
+}
+
+// StopServer stops a server's container in stages, records it as desired
+// stopped and returns the stage it stopped at
+func (c *Client) StopServer(ctx context.Context, serverID string, opts docker.StopOptions) (string, error) {
+	var stage string
+	err := c.states.Do(serverID, state.Stopping, state.Offline, func(details map[string]interface{}) error {
+		var err error
+		stage, err = c.dockerManager.StopContainer(ctx, c.servers.Resolve(ctx, serverID), opts)
+		if err != nil {
+			return err
+		}
+		c.setDesiredState(serverID, registry.DesiredStopped)
+		c.serverStopped(serverID)
+		details["stopStage"] = stage
+		return nil
+	})
+	return stage, err
+}
+
+// serverStopped notifies the stop handler that an operator stopped a server
+func (c *Client) serverStopped(serverID string) {
+	c.mu.RLock()
+	fn := c.onStop
+	c.mu.RUnlock()
+	if fn != nil {
+		fn(serverID)
+	}
+}
+
+// deleteServer stops and removes a server's container, purging its data
+// directory if requested. A failed purge is reported after the container
+// is gone, since the server cannot be brought back at that point.
+func (c *Client) deleteServer(ctx context.Context, serverID string, purge bool) error {
+	var purgeErr error
+	err := c.states.Do(serverID, state.Deleting, state.Deleted, func(details map[string]interface{}) error {
+		containerID := c.servers.Resolve(ctx, serverID)
+		if _, err := c.dockerManager.StopContainer(ctx, containerID, docker.StopOptions{}); err != nil {
+			log.Printf("Error stopping container %s: %v", containerID, err)
+		}
+		if err := c.dockerManager.RemoveContainer(ctx, containerID); err != nil {
+			return err
+		}
+		c.forgetServer(serverID)
+		c.consoles.Forget(serverID)
+		c.serverDeleted(serverID)
+
+		if purge {
+			if purgeErr = c.dockerManager.PurgeServerData(serverID); purgeErr != nil {
+				details["purgeError"] = purgeErr.Error()
+			}
+		}
+		details["dataPurged"] = purge && purgeErr == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if purgeErr != nil {
+		return fmt.Errorf("server deleted but data purge failed: %w", purgeErr)
+	}
+	return nil
+}
+
+// serverDeleted notifies the delete handler that a server is gone
+func (c *Client) serverDeleted(serverID string) {
+	c.mu.RLock()
+	fn := c.onDelete
+	c.mu.RUnlock()
+	if fn != nil {
+		fn(serverID)
+	}
+}
+
+// statusChanged reports a server state change to the panel
+func (c *Client) statusChanged(change state.Change) {
+	data := map[string]interface{}{
+		"serverId":       change.ServerID,
+		"previousStatus": string(change.From),
+		"currentStatus":  string(change.To),
+	}
+	for key, value := range change.Details {
+		data[key] = value
+	}
+	if change.Err != nil {
+		data["error"] = change.Err.Error()
+		data["code"] = command.ErrorCode(change.Err)
+
+		var conflict *docker.PortConflictError
+		if errors.As(change.Err, &conflict) {
+			data["conflict"] = conflict
+		}
+	}
+	c.sendEvent("server_status_changed", data)
+}
+
 // handleSystemInfoRequest handles system info requests
 func (c *Client) handleSystemInfoRequest(ctx context.Context, msg *messages.Message) error {
 	return c.sendSystemInfo()
@@ -288,26 +779,10 @@ func (c *Client) handleServerCreate(ctx context.Context, msg *messages.Message)
 
 	log.Printf("Creating server: %s", data.ServerID)
 
-	// Convert to docker config
-	dockerConfig := &docker.ServerConfig{
-		ServerID:    data.ServerID,
-		Image:       data.Image,
-		Startup:     data.Startup,
-		Environment: data.Environment,
-		Limits: docker.ResourceLimits{
-			Memory: data.Limits.Memory,
-			CPUs:   data.Limits.CPU,
-			Disk:   data.Limits.Disk,
-		},
-	}
-
-	containerID, err := c.dockerManager.CreateGameServer(ctx, dockerConfig)
-	if err != nil {
+	if _, err := c.createServer(ctx, &data); err != nil {
 		return err
 	}
 
-	log.Printf("Created container %s for server %s", containerID, data.ServerID)
-
 	// Send status update
 	statusData := &messages.ServerStatusData{
 		ServerID: data.ServerID,
@@ -318,6 +793,107 @@ func (c *Client) handleServerCreate(ctx context.Context, msg *messages.Message)
 	return c.sendMessage(statusMsg)
 }
 
+// createServer creates a server's container through the state machine and
+// returns its ID
+func (c *Client) createServer(ctx context.Context, data *messages.ServerCreateData) (string, error) {
+	dockerConfig := serverConfig(data)
+	dockerConfig.PullProgress = c.pullProgress(data.ServerID)
+
+	var containerID string
+	err := c.states.Do(data.ServerID, state.Installing, state.Offline, func(details map[string]interface{}) error {
+		var err error
+		containerID, err = c.dockerManager.CreateGameServer(ctx, dockerConfig)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created container %s for server %s", containerID, data.ServerID)
+		c.recordServer(containerID, dockerConfig)
+		details["containerId"] = containerID
+		return nil
+	})
+	return containerID, err
+}
+
+// serverConfig converts protocol server creation data to a Docker server configuration
+func serverConfig(data *messages.ServerCreateData) *docker.ServerConfig {
+	cfg := &docker.ServerConfig{
+		ServerID:    data.ServerID,
+		Image:       data.Image,
+		Startup:     data.Startup,
+		Environment: data.Environment,
+		Limits:      convertResourceLimits(data.Limits),
+		Ports:       convertPortMappings(data.Ports),
+
+		StopCommand:  data.StopCommand,
+		DonePattern:  data.DonePattern,
+		ReadyTimeout: data.ReadyTimeout,
+		PullPolicy:   data.PullPolicy,
+	}
+	if data.RestartPolicy != nil {
+		cfg.RestartPolicy = &docker.RestartPolicy{
+			Policy:      data.RestartPolicy.Policy,
+			MaxRestarts: data.RestartPolicy.MaxRestarts,
+			Window:      data.RestartPolicy.Window,
+			Backoff:     data.RestartPolicy.Backoff,
+		}
+	}
+	if data.RegistryAuth != nil {
+		cfg.RegistryAuth = &docker.RegistryAuth{
+			Server:   data.RegistryAuth.Server,
+			Username: data.RegistryAuth.Username,
+			Password: data.RegistryAuth.Password,
+		}
+	}
+	return cfg
+}
+
+// pullProgress returns a callback forwarding image pull progress of a
+// server to the panel
+func (c *Client) pullProgress(serverID string) func(docker.ImagePullProgress) {
+	return func(p docker.ImagePullProgress) {
+		c.sendEvent("image_pull_progress", map[string]interface{}{
+			"serverId":  serverID,
+			"image":     p.Image,
+			"status":    p.Status,
+			"layers":    p.Layers,
+			"completed": p.Completed,
+			"current":   p.Current,
+			"total":     p.Total,
+			"percent":   p.Percent,
+			"done":      p.Done,
+		})
+	}
+}
+
+// convertResourceLimits converts protocol resource limits to Docker limits
+func convertResourceLimits(limits messages.ResourceLimits) docker.ResourceLimits {
+	return docker.ResourceLimits{
+		Memory:   limits.Memory,
+		Swap:     limits.Swap,
+		CPUs:     limits.CPU,
+		CPULimit: limits.CPULimit,
+		CPUSet:   limits.CPUSet,
+		IO:       limits.IO,
+		Disk:     limits.Disk,
+	}
+}
+
+// convertPortMappings converts protocol port mappings to Docker port mappings
+func convertPortMappings(ports []messages.PortMapping) []docker.PortMapping {
+	mappings := make([]docker.PortMapping, 0, len(ports))
+	for _, p := range ports {
+		mappings = append(mappings, docker.PortMapping{
+			Internal:    p.Internal,
+			External:    p.External,
+			Protocol:    p.Protocol,
+			HostIP:      p.HostIP,
+			InternalEnd: p.InternalEnd,
+			ExternalEnd: p.ExternalEnd,
+		})
+	}
+	return mappings
+}
+
 // handleServerStart handles server start requests
 func (c *Client) handleServerStart(ctx context.Context, msg *messages.Message) error {
 	var data struct {
@@ -329,11 +905,7 @@ func (c *Client) handleServerStart(ctx context.Context, msg *messages.Message) e
 
 	log.Printf("Starting server: %s", data.ServerID)
 
-	// Find container by server ID (simplified - would need proper mapping)
-	containerName := "ctrl-alt-play-" + data.ServerID
-
-	// This is simplified - in production you'd maintain a mapping of serverID to containerID
-	if err := c.dockerManager.StartContainer(ctx, containerName); err != nil {
+	if err := c.StartServer(ctx, data.ServerID); err != nil {
 		return err
 	}
 
@@ -358,9 +930,7 @@ func (c *Client) handleServerStop(ctx context.Context, msg *messages.Message) er
 
 	log.Printf("Stopping server: %s", data.ServerID)
 
-	containerName := "ctrl-alt-play-" + data.ServerID
-
-	if err := c.dockerManager.StopContainer(ctx, containerName); err != nil {
+	if _, err := c.StopServer(ctx, data.ServerID, docker.StopOptions{}); err != nil {
 		return err
 	}
 
@@ -376,17 +946,27 @@ func (c *Client) handleServerStop(ctx context.Context, msg *messages.Message) er
 
 // handleServerRestart handles server restart requests
 func (c *Client) handleServerRestart(ctx context.Context, msg *messages.Message) error {
-	// Stop then start
-	if err := c.handleServerStop(ctx, msg); err != nil {
+	var data struct {
+		ServerID string `json:"serverId"`
+	}
+	if err := msg.UnmarshalData(&data); err != nil {
 		return err
 	}
+
+	// Stop then start; a stopped server is simply started
+	if c.states.Get(data.ServerID) == state.Running {
+		if err := c.handleServerStop(ctx, msg); err != nil {
+			return err
+		}
+	}
 	return c.handleServerStart(ctx, msg)
 }
 
 // handleServerDelete handles server deletion requests
 func (c *Client) handleServerDelete(ctx context.Context, msg *messages.Message) error {
 	var data struct {
-		ServerID string `json:"serverId"`
+		ServerID  string `json:"serverId"`
+		PurgeData bool   `json:"purgeData"`
 	}
 	if err := msg.UnmarshalData(&data); err != nil {
 		return err
@@ -394,13 +974,7 @@ func (c *Client) handleServerDelete(ctx context.Context, msg *messages.Message)
 
 	log.Printf("Deleting server: %s", data.ServerID)
 
-	containerName := "ctrl-alt-play-" + data.ServerID
-
-	// Stop and remove container
-	if err := c.dockerManager.StopContainer(ctx, containerName); err != nil {
-		log.Printf("Error stopping container %s: %v", containerName, err)
-	}
-	if err := c.dockerManager.RemoveContainer(ctx, containerName); err != nil {
+	if err := c.deleteServer(ctx, data.ServerID, data.PurgeData); err != nil {
 		return err
 	}
 
@@ -423,17 +997,9 @@ func (c *Client) handleServerCommand(ctx context.Context, msg *messages.Message)
 
 	log.Printf("Executing command on server %s: %s", data.ServerID, data.Command)
 
-	// This would need implementation to execute commands in containers
-	// For now, just acknowledge the command
-	outputData := &messages.ServerOutputData{
-		ServerID:  data.ServerID,
-		Output:    "Command executed: " + data.Command,
-		Stream:    "stdout",
-		Timestamp: time.Now(),
-	}
-
-	outputMsg, _ := messages.NewMessage(messages.TypeServerOutput, outputData)
-	return c.sendMessage(outputMsg)
+	// Output produced by the command reaches the panel through the console log stream
+	containerID := c.servers.Resolve(ctx, data.ServerID)
+	return c.dockerManager.SendCommand(ctx, containerID, data.Command)
 }
 
 // handlePanelCommand handles new Panel Issue #27 command format
@@ -445,7 +1011,7 @@ func (c *Client) handlePanelCommand(msg *messages.Message) {
 		log.Printf("Error marshaling command data: %v", err)
 		return
 	}
-	
+
 	if err := json.Unmarshal(rawData, &cmd); err != nil {
 		log.Printf("Error parsing Panel command: %v", err)
 		c.sendErrorResponse("", "PARSE_ERROR", "Failed to parse command: "+err.Error())
@@ -462,35 +1028,21 @@ func (c *Client) handlePanelCommand(msg *messages.Message) {
 
 	// Handle the actual command asynchronously
 	go func() {
-		if err := c.executePanelCommand(&cmd); err != nil {
+		result, err := c.commands.Execute(context.Background(), &command.Request{
+			ID:       cmd.ID,
+			Action:   cmd.Action,
+			ServerID: cmd.ServerID,
+			Payload:  cmd.Payload,
+		})
+		if err != nil {
 			log.Printf("Error executing Panel command %s: %v", cmd.Action, err)
-			c.sendErrorResponse(cmd.ID, "EXECUTION_ERROR", err.Error())
+			c.sendResponse(cmd.ID, false, "", nil, command.ErrorInfo(err))
+			return
 		}
+		c.sendResponse(cmd.ID, true, result.Message, result.Data, nil)
 	}()
 }
 
-// executePanelCommand executes the actual Panel command
-func (c *Client) executePanelCommand(cmd *messages.PanelCommand) error {
-	ctx := context.Background()
-
-	switch cmd.Action {
-	case "start_server":
-		return c.handlePanelServerStart(ctx, cmd)
-	case "stop_server":
-		return c.handlePanelServerStop(ctx, cmd)
-	case "restart_server":
-		return c.handlePanelServerRestart(ctx, cmd)
-	case "get_status":
-		return c.handlePanelGetStatus(ctx, cmd)
-	case "create_server":
-		return c.handlePanelServerCreate(ctx, cmd)
-	case "delete_server":
-		return c.handlePanelServerDelete(ctx, cmd)
-	default:
-		return fmt.Errorf("unknown action: %s", cmd.Action)
-	}
-}
-
 // getActionStatus returns the expected status for a given action
 func (c *Client) getActionStatus(action string) string {
 	switch action {
@@ -498,6 +1050,8 @@ func (c *Client) getActionStatus(action string) string {
 		return "starting"
 	case "stop_server":
 		return "stopping"
+	case "kill_server":
+		return "killing"
 	case "restart_server":
 		return "restarting"
 	case "create_server":
@@ -527,7 +1081,7 @@ func (c *Client) sendResponse(id string, success bool, message string, data map[
 		return
 	}
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, responseData); err != nil {
+	if err := c.writeRaw(responseData); err != nil {
 		log.Printf("Error sending response: %v", err)
 	}
 }
@@ -540,7 +1094,17 @@ func (c *Client) sendErrorResponse(id, code, message string) {
 	})
 }
 
-// sendEvent sends an event to the Panel
+// Consoles returns the console hub that follows server logs
+func (c *Client) Consoles() *console.Hub {
+	return c.consoles
+}
+
+// SendEvent sends an event to the Panel; it implements messages.EventSink
+func (c *Client) SendEvent(event string, data map[string]interface{}) {
+	c.sendEvent(event, data)
+}
+
+// sendEvent sends an event to the Panel, buffering it for replay while offline
 func (c *Client) sendEvent(event string, data map[string]interface{}) {
 	evt := &messages.AgentEvent{
 		Type:      "event",
@@ -555,214 +1119,28 @@ func (c *Client) sendEvent(event string, data map[string]interface{}) {
 		return
 	}
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, eventData); err != nil {
-		log.Printf("Error sending event: %v", err)
-	}
-}
-
-// handlePanelServerStart handles Panel-format server start commands
-func (c *Client) handlePanelServerStart(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Starting server: %s", cmd.ServerID)
-
-	containerName := "ctrl-alt-play-" + cmd.ServerID
-
-	if err := c.dockerManager.StartContainer(ctx, containerName); err != nil {
-		// Send error event
-		c.sendEvent("server_status_changed", map[string]interface{}{
-			"serverId":      cmd.ServerID,
-			"status":        "start_failed",
-			"error":         err.Error(),
-			"previousStatus": "stopped",
-		})
-		return err
-	}
-
-	// Send success event
-	c.sendEvent("server_status_changed", map[string]interface{}{
-		"serverId":       cmd.ServerID,
-		"previousStatus": "stopped",
-		"currentStatus":  "running",
-	})
-
-	return nil
-}
-
-// handlePanelServerStop handles Panel-format server stop commands with signal support
-func (c *Client) handlePanelServerStop(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Stopping server: %s", cmd.ServerID)
-
-	containerName := "ctrl-alt-play-" + cmd.ServerID
-
-	// Check for signal and timeout in payload
-	signal := "SIGTERM" // default
-	timeout := 30       // default 30 seconds
-
-	if cmd.Payload != nil {
-		if s, ok := cmd.Payload["signal"].(string); ok {
-			signal = s
-		}
-		if t, ok := cmd.Payload["timeout"].(float64); ok {
-			timeout = int(t)
-		}
-	}
-
-	log.Printf("Stopping server %s with signal %s and timeout %d", cmd.ServerID, signal, timeout)
-
-	if err := c.dockerManager.StopContainer(ctx, containerName); err != nil {
-		// Send error event
-		c.sendEvent("server_status_changed", map[string]interface{}{
-			"serverId":       cmd.ServerID,
-			"status":         "stop_failed",
-			"error":          err.Error(),
-			"previousStatus": "running",
-		})
-		return err
-	}
-
-	// Send success event
-	c.sendEvent("server_status_changed", map[string]interface{}{
-		"serverId":       cmd.ServerID,
-		"previousStatus": "running",
-		"currentStatus":  "stopped",
-	})
-
-	return nil
-}
-
-// handlePanelServerRestart handles Panel-format server restart commands
-func (c *Client) handlePanelServerRestart(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Restarting server: %s", cmd.ServerID)
-
-	// Send restarting status
-	c.sendEvent("server_status_changed", map[string]interface{}{
-		"serverId":       cmd.ServerID,
-		"previousStatus": "running",
-		"currentStatus":  "restarting",
-	})
-
-	// Stop then start
-	if err := c.handlePanelServerStop(ctx, cmd); err != nil {
-		return err
-	}
-
-	return c.handlePanelServerStart(ctx, cmd)
-}
-
-// handlePanelGetStatus handles Panel-format status requests
-func (c *Client) handlePanelGetStatus(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Getting status for server: %s", cmd.ServerID)
-
-	containerName := "ctrl-alt-play-" + cmd.ServerID
-
-	// This is simplified - would need proper container inspection
-	containers, err := c.dockerManager.ListContainers(ctx)
-	if err != nil {
-		return err
-	}
-
-	var status string = "stopped"
-	var containerID string
-	
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+containerName {
-				status = container.State
-				containerID = container.ID
-				break
-			}
-		}
-	}
-
-	// Send detailed status response
-	statusData := map[string]interface{}{
-		"serverId":    cmd.ServerID,
-		"status":      status,
-		"containerId": containerID,
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// Add resource usage if container is running
-	if status == "running" {
-		statusData["resources"] = map[string]interface{}{
-			"cpu":    "25.5%",
-			"memory": map[string]interface{}{
-				"used":      "1024m",
-				"available": "2048m",
-			},
+	if c.online && c.conn != nil {
+		err := c.writeLocked(eventData)
+		if err == nil {
+			return
 		}
+		log.Printf("Error sending event: %v", err)
 	}
 
-	c.sendResponse(cmd.ID, true, "Status retrieved successfully", statusData, nil)
-	return nil
-}
-
-// handlePanelServerCreate handles Panel-format server creation
-func (c *Client) handlePanelServerCreate(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Creating server: %s", cmd.ServerID)
-
-	if cmd.Payload == nil {
-		return fmt.Errorf("missing server configuration in payload")
-	}
-
-	// Extract server configuration from payload
-	var config docker.ServerConfig
-	configData, err := json.Marshal(cmd.Payload)
-	if err != nil {
-		return err
-	}
-	
-	if err := json.Unmarshal(configData, &config); err != nil {
-		return err
-	}
-
-	config.ServerID = cmd.ServerID
-
-	containerID, err := c.dockerManager.CreateGameServer(ctx, &config)
-	if err != nil {
-		c.sendEvent("server_status_changed", map[string]interface{}{
-			"serverId": cmd.ServerID,
-			"status":   "create_failed",
-			"error":    err.Error(),
-		})
-		return err
+	if !volatileEvents[event] {
+		c.queueEvent(evt)
 	}
-
-	log.Printf("Created container %s for server %s", containerID, cmd.ServerID)
-
-	// Send success event
-	c.sendEvent("server_status_changed", map[string]interface{}{
-		"serverId":    cmd.ServerID,
-		"status":      "created",
-		"containerId": containerID,
-	})
-
-	return nil
 }
 
-// handlePanelServerDelete handles Panel-format server deletion
-func (c *Client) handlePanelServerDelete(ctx context.Context, cmd *messages.PanelCommand) error {
-	log.Printf("Deleting server: %s", cmd.ServerID)
-
-	containerName := "ctrl-alt-play-" + cmd.ServerID
-
-	// Stop and remove container
-	if err := c.dockerManager.StopContainer(ctx, containerName); err != nil {
-		log.Printf("Error stopping container %s: %v", containerName, err)
-	}
-	
-	if err := c.dockerManager.RemoveContainer(ctx, containerName); err != nil {
-		c.sendEvent("server_status_changed", map[string]interface{}{
-			"serverId": cmd.ServerID,
-			"status":   "delete_failed",
-			"error":    err.Error(),
-		})
-		return err
+// queueEvent buffers an event for replay, dropping the oldest when full.
+// Callers must hold c.mu.
+func (c *Client) queueEvent(evt *messages.AgentEvent) {
+	if len(c.pendingEvents) >= maxPendingEvents {
+		log.Printf("Offline event buffer full, dropping oldest event %s", c.pendingEvents[0].Event)
+		c.pendingEvents = c.pendingEvents[1:]
 	}
-
-	// Send success event
-	c.sendEvent("server_status_changed", map[string]interface{}{
-		"serverId": cmd.ServerID,
-		"status":   "deleted",
-	})
-
-	return nil
+	c.pendingEvents = append(c.pendingEvents, evt)
 }
@@ -3,7 +3,6 @@ package client
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -14,114 +13,65 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/config"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
 	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
 )
 
-// mockDockerManager implements a mock Docker manager for testing
-type mockDockerManager struct {
-	containers map[string]string // containerName -> status
-	errors     map[string]error  // operation -> error
-}
-
-func newMockDockerManager() *mockDockerManager {
-	return &mockDockerManager{
-		containers: make(map[string]string),
-		errors:     make(map[string]error),
-	}
-}
-
-func (m *mockDockerManager) StartContainer(ctx context.Context, containerID string) error {
-	if err, exists := m.errors["start"]; exists {
-		return err
-	}
-	m.containers[containerID] = "running"
-	return nil
-}
-
-func (m *mockDockerManager) StopContainer(ctx context.Context, containerID string) error {
-	if err, exists := m.errors["stop"]; exists {
-		return err
-	}
-	m.containers[containerID] = "stopped"
-	return nil
-}
-
-func (m *mockDockerManager) RestartContainer(ctx context.Context, containerID string) error {
-	if err, exists := m.errors["restart"]; exists {
-		return err
-	}
-	m.containers[containerID] = "running"
-	return nil
-}
-
-func (m *mockDockerManager) DeleteContainer(ctx context.Context, containerID string) error {
-	if err, exists := m.errors["delete"]; exists {
-		return err
+// fakeDaemon serves the Docker API at DOCKER_HOST for the duration of the
+// test. Requests are matched without their API version prefix; anything
+// not routed is answered like a daemon that knows no such container.
+func fakeDaemon(t *testing.T, routes map[string]http.HandlerFunc) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", "1.47")
+		w.Write([]byte("OK"))
+	})
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, handler)
 	}
-	delete(m.containers, containerID)
-	return nil
-}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"No such container"}`))
+	})
 
-func (m *mockDockerManager) CreateGameServer(ctx context.Context, config *docker.ServerConfig) (string, error) {
-	if err, exists := m.errors["create"]; exists {
-		return "", err
-	}
-	containerID := "container_" + time.Now().Format("20060102150405")
-	m.containers[config.ServerID] = "created"
-	return containerID, nil
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/v"); ok {
+			if _, path, found := strings.Cut(rest, "/"); found {
+				r.URL.Path = "/" + path
+			}
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+server.Listener.Addr().String())
 }
 
-func (m *mockDockerManager) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
-	if err, exists := m.errors["status"]; exists {
-		return "", err
-	}
-	status, exists := m.containers[containerID]
-	if !exists {
-		return "not_found", nil
-	}
-	return status, nil
-}
+// newTestClient creates a client for panelURL backed by a fake Docker
+// daemon serving routes
+func newTestClient(t *testing.T, panelURL string, routes map[string]http.HandlerFunc) *Client {
+	t.Helper()
+	fakeDaemon(t, routes)
 
-func (m *mockDockerManager) ExecuteCommand(ctx context.Context, containerID string, command []string) (string, error) {
-	if err, exists := m.errors["execute"]; exists {
-		return "", err
-	}
-	return "command executed", nil
-}
-
-func (m *mockDockerManager) RemoveContainer(ctx context.Context, containerID string) error {
-	if err, exists := m.errors["remove"]; exists {
-		return err
-	}
-	delete(m.containers, containerID)
-	return nil
-}
+	manager, err := docker.NewManager()
+	require.NoError(t, err)
+	t.Cleanup(func() { manager.Close() })
 
-func (m *mockDockerManager) ListContainers(ctx context.Context) ([]interface{}, error) {
-	return nil, nil // Simplified for testing
-}
+	servers, err := registry.Open(t.TempDir(), manager)
+	require.NoError(t, err)
+	states := state.NewMachine(state.ObserveContainers(servers, manager))
 
-func (m *mockDockerManager) CreateContainer(ctx context.Context, config interface{}, hostConfig interface{}, containerName string) (string, error) {
-	if err, exists := m.errors["create"]; exists {
-		return "", err
+	cfg := &config.Config{
+		PanelURL: panelURL,
+		NodeID:   "test-node",
+		Secret:   "test-secret",
 	}
-	containerID := "container_" + time.Now().Format("20060102150405")
-	m.containers[containerName] = "created"
-	return containerID, nil
-}
-
-func (m *mockDockerManager) GetContainerStats(ctx context.Context, containerID string) (interface{}, error) {
-	return nil, nil // Simplified for testing
-}
-
-func (m *mockDockerManager) GetContainerLogs(ctx context.Context, containerID string) (interface{}, error) {
-	return nil, nil // Simplified for testing
-}
-
-func (m *mockDockerManager) Close() error {
-	return nil
+	return NewClient(cfg, manager, servers, states, command.NewRegistry())
 }
 
 // createTestServer creates a WebSocket test server
@@ -139,27 +89,63 @@ func createTestServer(t *testing.T, handler func(*websocket.Conn)) *httptest.Ser
 	return server
 }
 
+// createRecordingServer creates a WebSocket test server passing every
+// message the client sends to the returned channel
+func createRecordingServer(t *testing.T) (*httptest.Server, <-chan map[string]interface{}) {
+	received := make(chan map[string]interface{}, 100)
+	server := createTestServer(t, func(conn *websocket.Conn) {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg map[string]interface{}
+			if json.Unmarshal(data, &msg) == nil {
+				received <- msg
+			}
+		}
+	})
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+// wsURL converts the URL of a test server to a WebSocket URL
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// nextMessage returns the next message the panel received
+func nextMessage(t *testing.T, received <-chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message from the agent")
+		return nil
+	}
+}
+
 func TestClient_PanelCommandHandling(t *testing.T) {
 	tests := []struct {
 		name        string
 		command     messages.PanelCommand
-		expectError bool
-		mockError   string
+		expectError string // error code, empty if the command succeeds
 	}{
 		{
-			name: "start server command",
+			name: "get status of a server without container",
 			command: messages.PanelCommand{
-				ID:        "cmd_123",
+				ID:        "cmd_789",
 				Type:      "command",
 				Timestamp: time.Now().Format(time.RFC3339),
 				AgentID:   "test-agent",
-				Action:    "start_server",
-				ServerID:  "server_123",
+				Action:    "get_status",
+				ServerID:  "server_789",
 			},
-			expectError: false,
+			expectError: "",
 		},
 		{
-			name: "stop server command with payload",
+			name: "stop server with invalid payload",
 			command: messages.PanelCommand{
 				ID:        "cmd_456",
 				Type:      "command",
@@ -167,24 +153,22 @@ func TestClient_PanelCommandHandling(t *testing.T) {
 				AgentID:   "test-agent",
 				Action:    "stop_server",
 				ServerID:  "server_456",
-				Payload: map[string]interface{}{
-					"signal":  "SIGTERM",
-					"timeout": 30,
-				},
+				Payload:   json.RawMessage(`{"signal":"SIGTERM","timeout":-1}`),
 			},
-			expectError: false,
+			expectError: "VALIDATION_ERROR",
 		},
 		{
-			name: "get status command",
+			name: "stop server that is not running",
 			command: messages.PanelCommand{
-				ID:        "cmd_789",
+				ID:        "cmd_123",
 				Type:      "command",
 				Timestamp: time.Now().Format(time.RFC3339),
 				AgentID:   "test-agent",
-				Action:    "get_status",
-				ServerID:  "server_789",
+				Action:    "stop_server",
+				ServerID:  "server_123",
+				Payload:   json.RawMessage(`{"signal":"SIGTERM","timeout":30}`),
 			},
-			expectError: false,
+			expectError: "INVALID_STATE_TRANSITION",
 		},
 		{
 			name: "unknown action",
@@ -196,85 +180,48 @@ func TestClient_PanelCommandHandling(t *testing.T) {
 				Action:    "unknown_action",
 				ServerID:  "server_unknown",
 			},
-			expectError: true,
+			expectError: "UNKNOWN_ACTION",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create mock docker manager
-			mockDocker := newMockDockerManager()
-			if tt.mockError != "" {
-				mockDocker.errors[strings.Split(tt.mockError, ":")[0]] =
-					fmt.Errorf(strings.Split(tt.mockError, ":")[1])
-			}
-
-			// Create test server
-			var receivedMessages []map[string]interface{}
-			server := createTestServer(t, func(conn *websocket.Conn) {
-				for {
-					_, data, err := conn.ReadMessage()
-					if err != nil {
-						break
-					}
-
-					var msg map[string]interface{}
-					json.Unmarshal(data, &msg)
-					receivedMessages = append(receivedMessages, msg)
-				}
-			})
-			defer server.Close()
-
-			// Convert HTTP URL to WebSocket URL
-			wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
-
-			// Create client
-			cfg := &config.Config{
-				PanelURL: wsURL,
-				NodeID:   "test-node",
-				Secret:   "test-secret",
-			}
-
-			// Create interface wrapper for mock
-			var dockerManager docker.ManagerInterface = mockDocker
-			client := NewClient(cfg, dockerManager)
+			server, received := createRecordingServer(t)
+			client := newTestClient(t, wsURL(server), nil)
 
 			// Connect to test server
-			err = client.Connect()
-			require.NoError(t, err)
+			require.NoError(t, client.Connect())
 			defer client.Stop()
 
 			// Create command message
 			cmdData, err := json.Marshal(tt.command)
 			require.NoError(t, err)
 
-			var rawData json.RawMessage = cmdData
 			msg := &messages.Message{
 				Type:      messages.TypeCommand,
-				Data:      rawData,
+				Data:      cmdData,
 				Timestamp: time.Now(),
 			}
 
 			// Handle the command
 			client.handleMessage(msg)
 
-			// Wait for processing
-			time.Sleep(100 * time.Millisecond)
-
-			// Verify response was sent
-			assert.GreaterOrEqual(t, len(receivedMessages), 1, "Should receive at least one response")
+			// The command is acknowledged first, then answered
+			ack := nextMessage(t, received)
+			assert.Equal(t, "response", ack["type"])
+			assert.Equal(t, tt.command.ID, ack["id"])
+			assert.Equal(t, true, ack["success"])
 
-			if len(receivedMessages) > 0 {
-				response := receivedMessages[0]
-				assert.Equal(t, "response", response["type"])
-				assert.Equal(t, tt.command.ID, response["id"])
+			response := nextMessage(t, received)
+			assert.Equal(t, "response", response["type"])
+			assert.Equal(t, tt.command.ID, response["id"])
 
-				if tt.expectError {
-					assert.False(t, response["success"].(bool))
-					assert.NotNil(t, response["error"])
-				} else {
-					assert.True(t, response["success"].(bool))
-				}
+			if tt.expectError != "" {
+				assert.Equal(t, false, response["success"])
+				require.NotNil(t, response["error"])
+				assert.Equal(t, tt.expectError, response["error"].(map[string]interface{})["code"])
+			} else {
+				assert.Equal(t, true, response["success"])
 			}
 		})
 	}
@@ -282,15 +229,7 @@ func TestClient_PanelCommandHandling(t *testing.T) {
 
 func TestClient_LegacyMessageCompatibility(t *testing.T) {
 	// Test that legacy messages still work
-	mockDocker := newMockDockerManager()
-
-	cfg := &config.Config{
-		PanelURL:    "ws://localhost:8080",
-		NodeID:      "test-node",
-		AgentSecret: "test-secret",
-	}
-
-	client := NewClient(cfg, mockDocker)
+	client := newTestClient(t, "ws://localhost:8080", nil)
 
 	// Create legacy server start message
 	legacyData := map[string]interface{}{
@@ -307,16 +246,7 @@ func TestClient_LegacyMessageCompatibility(t *testing.T) {
 }
 
 func TestClient_MessageRouting(t *testing.T) {
-	mockDocker := newMockDockerManager()
-
-	cfg := &config.Config{
-		PanelURL:    "ws://localhost:8080",
-		NodeID:      "test-node",
-		AgentSecret: "test-secret",
-	}
-
-	client := NewClient(cfg, mockDocker)
-	client.registerHandlers()
+	client := newTestClient(t, "ws://localhost:8080", nil)
 
 	tests := []struct {
 		name        string
@@ -393,9 +323,7 @@ func TestAgentResponse_Serialization(t *testing.T) {
 }
 
 func TestGetActionStatus(t *testing.T) {
-	mockDocker := newMockDockerManager()
-	cfg := &config.Config{}
-	client := NewClient(cfg, mockDocker)
+	client := newTestClient(t, "", nil)
 
 	tests := []struct {
 		action   string
@@ -403,6 +331,7 @@ func TestGetActionStatus(t *testing.T) {
 	}{
 		{"start_server", "starting"},
 		{"stop_server", "stopping"},
+		{"kill_server", "killing"},
 		{"restart_server", "restarting"},
 		{"create_server", "creating"},
 		{"delete_server", "deleting"},
@@ -416,3 +345,81 @@ func TestGetActionStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		want := reconnectMaxDelay
+		if attempt < 16 {
+			want = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+		}
+		for i := 0; i < 20; i++ {
+			delay := backoffDelay(attempt)
+			assert.GreaterOrEqual(t, delay, want/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, delay, want, "attempt %d", attempt)
+		}
+	}
+	assert.LessOrEqual(t, backoffDelay(0), reconnectBaseDelay)
+}
+
+func TestClient_OfflineQueueDropsOldest(t *testing.T) {
+	client := newTestClient(t, "", nil)
+
+	for i := 0; i < maxPendingEvents+5; i++ {
+		client.SendEvent("server_status_changed", map[string]interface{}{"seq": i})
+	}
+	// Stale high-frequency events are not buffered at all
+	client.SendEvent("server_stats", map[string]interface{}{"seq": -1})
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	require.Len(t, client.pendingEvents, maxPendingEvents)
+	assert.Equal(t, 5, client.pendingEvents[0].Data["seq"])
+	assert.Equal(t, maxPendingEvents+4, client.pendingEvents[maxPendingEvents-1].Data["seq"])
+}
+
+func TestClient_ResumeSessionReplaysInOrder(t *testing.T) {
+	server, received := createRecordingServer(t)
+	client := newTestClient(t, wsURL(server), nil)
+
+	for i := 0; i < 3; i++ {
+		client.SendEvent("server_status_changed", map[string]interface{}{"seq": i})
+	}
+
+	require.NoError(t, client.Connect())
+	defer client.Stop()
+	client.resumeSession()
+	client.SendEvent("server_status_changed", map[string]interface{}{"seq": 3})
+
+	// Buffered events come first, in the order they happened
+	for i := 0; i < 4; i++ {
+		msg := nextMessage(t, received)
+		assert.Equal(t, "server_status_changed", msg["event"])
+		assert.Equal(t, float64(i), msg["data"].(map[string]interface{})["seq"])
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	assert.True(t, client.online)
+	assert.Empty(t, client.pendingEvents)
+}
+
+func TestClient_GetStatusReportsStatsError(t *testing.T) {
+	client := newTestClient(t, "", map[string]http.HandlerFunc{
+		"GET /containers/{id}/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"Id":"c1","State":{"Status":"running","Running":true}}`))
+		},
+		"GET /containers/{id}/stats": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message":"stats temporarily unavailable"}`))
+		},
+	})
+
+	result, err := client.commands.Execute(context.Background(), &command.Request{Action: "get_status", ServerID: "s1"})
+	require.NoError(t, err)
+	assert.Equal(t, "running", result.Data["status"])
+	assert.Equal(t, "c1", result.Data["containerId"])
+	assert.Contains(t, result.Data["statsError"], "stats temporarily unavailable")
+	assert.NotContains(t, result.Data, "resources")
+}
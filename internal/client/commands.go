@@ -0,0 +1,279 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/command"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+)
+
+// registerCommands adds the server lifecycle and console actions to the
+// command registry shared with the HTTP API
+func (c *Client) registerCommands() {
+	c.commands.Register(
+		command.ForServer("start_server", c.handleStartServer),
+		command.ForServer("stop_server", c.handleStopServer),
+		command.ForServer("restart_server", c.handleRestartServer),
+		command.ForServer("kill_server", c.handleKillServer),
+		command.ForServer("get_status", c.handleGetStatus),
+		command.ForServer("create_server", c.handleCreateServer),
+		command.ForServer("delete_server", c.handleDeleteServer),
+		command.ForServer("send_command", c.handleSendCommand),
+		command.ForServer("subscribe_console", c.handleSubscribeConsole),
+		command.ForServer("unsubscribe_console", c.handleUnsubscribeConsole),
+	)
+}
+
+// stopOptions converts a stop payload to Docker stop options
+func stopOptions(p *messages.StopServerPayload) docker.StopOptions {
+	opts := docker.StopOptions{Signal: p.Signal, Command: p.Command}
+	if p.Timeout > 0 {
+		opts.Timeout = time.Duration(p.Timeout * float64(time.Second))
+	}
+	return opts
+}
+
+// handleStartServer starts a server; it stays starting until it is ready
+func (c *Client) handleStartServer(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	log.Printf("Starting server: %s", req.ServerID)
+	if err := c.StartServer(ctx, req.ServerID); err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Server started",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"state":    string(c.states.Get(req.ServerID)),
+		},
+	}, nil
+}
+
+// handleStopServer stops a server in stages
+func (c *Client) handleStopServer(ctx context.Context, req *command.Request, p *messages.StopServerPayload) (*command.Result, error) {
+	opts := stopOptions(p)
+	log.Printf("Stopping server %s (signal %q, timeout %s)", req.ServerID, opts.Signal, opts.Timeout)
+
+	stage, err := c.StopServer(ctx, req.ServerID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Server stopped",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"stage":    stage,
+		},
+	}, nil
+}
+
+// handleRestartServer stops a running server and starts it again; a
+// stopped server is simply started
+func (c *Client) handleRestartServer(ctx context.Context, req *command.Request, p *messages.StopServerPayload) (*command.Result, error) {
+	log.Printf("Restarting server: %s", req.ServerID)
+
+	data := map[string]interface{}{"serverId": req.ServerID}
+	if c.states.Get(req.ServerID) == state.Running {
+		stage, err := c.StopServer(ctx, req.ServerID, stopOptions(p))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stop server %s during restart: %w", req.ServerID, err)
+		}
+		data["stage"] = stage
+	}
+
+	if err := c.StartServer(ctx, req.ServerID); err != nil {
+		return nil, fmt.Errorf("failed to start server %s during restart: %w", req.ServerID, err)
+	}
+	data["state"] = string(c.states.Get(req.ServerID))
+
+	return &command.Result{Message: "Server restarted", Data: data}, nil
+}
+
+// handleKillServer sends a signal (SIGKILL by default) to a server's
+// process without removing its container
+func (c *Client) handleKillServer(ctx context.Context, req *command.Request, p *messages.KillServerPayload) (*command.Result, error) {
+	signal := p.Signal
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	log.Printf("Killing server %s with %s", req.ServerID, signal)
+
+	containerID := c.servers.Resolve(ctx, req.ServerID)
+	if docker.IsKillSignal(signal) {
+		err := c.states.Do(req.ServerID, state.Stopping, state.Offline, func(details map[string]interface{}) error {
+			stage := docker.StopStageKill
+			err := c.dockerManager.KillContainer(ctx, containerID, signal)
+			switch {
+			case errors.Is(err, docker.ErrServerNotRunning):
+				// A crashed server waiting for its restart has already exited
+				stage = docker.StopStageNotRunning
+			case err != nil:
+				return err
+			}
+			c.setDesiredState(req.ServerID, registry.DesiredStopped)
+			c.serverStopped(req.ServerID)
+			details["stopStage"] = stage
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if err := c.dockerManager.KillContainer(ctx, containerID, signal); err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Signal sent to server",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"signal":   signal,
+		},
+	}, nil
+}
+
+// handleGetStatus reports a server's container status, power state and
+// resource usage
+func (c *Client) handleGetStatus(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	log.Printf("Getting status for server: %s", req.ServerID)
+
+	status := "stopped"
+	var containerID string
+
+	info, err := c.dockerManager.InspectContainer(ctx, c.servers.Resolve(ctx, req.ServerID))
+	if err != nil && !docker.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		if info.State != nil {
+			status = info.State.Status
+		}
+		containerID = info.ID
+	}
+
+	statusData := map[string]interface{}{
+		"serverId":    req.ServerID,
+		"status":      status,
+		"state":       string(c.states.Get(req.ServerID)),
+		"containerId": containerID,
+	}
+
+	// Add resource usage if container is running; a failed sample is
+	// reported with the status rather than failing it
+	if status == "running" {
+		stats, err := c.dockerManager.GetServerStats(ctx, containerID)
+		if err != nil {
+			log.Printf("Failed to get stats of server %s: %v", req.ServerID, err)
+			statusData["statsError"] = err.Error()
+		} else {
+			statusData["resources"] = stats.ToServerStatsData()
+		}
+	}
+
+	return &command.Result{Message: "Status retrieved successfully", Data: statusData}, nil
+}
+
+// handleCreateServer creates a server's container from the payload
+func (c *Client) handleCreateServer(ctx context.Context, req *command.Request, p *messages.ServerCreateData) (*command.Result, error) {
+	log.Printf("Creating server: %s", req.ServerID)
+
+	p.ServerID = req.ServerID
+	containerID, err := c.createServer(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Server created",
+		Data: map[string]interface{}{
+			"serverId":    req.ServerID,
+			"containerId": containerID,
+		},
+	}, nil
+}
+
+// handleDeleteServer removes a server's container; its data is kept unless
+// the payload asks to purge it
+func (c *Client) handleDeleteServer(ctx context.Context, req *command.Request, p *messages.DeleteServerPayload) (*command.Result, error) {
+	log.Printf("Deleting server: %s", req.ServerID)
+	if err := c.deleteServer(ctx, req.ServerID, p.PurgeData); err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Server deleted",
+		Data: map[string]interface{}{
+			"serverId":   req.ServerID,
+			"dataPurged": p.PurgeData,
+		},
+	}, nil
+}
+
+// handleSendCommand writes a console command to a running server
+func (c *Client) handleSendCommand(ctx context.Context, req *command.Request, p *messages.SendCommandPayload) (*command.Result, error) {
+	log.Printf("Executing command on server %s: %s", req.ServerID, p.Command)
+
+	containerID := c.servers.Resolve(ctx, req.ServerID)
+	if err := c.dockerManager.SendCommand(ctx, containerID, p.Command); err != nil {
+		return nil, err
+	}
+
+	return &command.Result{
+		Message: "Command sent to server console",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"command":  p.Command,
+		},
+	}, nil
+}
+
+// handleSubscribeConsole starts pushing a server's console output and sends its recent history
+func (c *Client) handleSubscribeConsole(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	log.Printf("Subscribing to console of server: %s", req.ServerID)
+
+	containerID := c.servers.Resolve(ctx, req.ServerID)
+	info, err := c.dockerManager.InspectContainer(ctx, containerID)
+	if err != nil && !docker.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && info.State != nil && info.State.Running {
+		c.consoles.Watch(req.ServerID, info.ID)
+	}
+
+	history := c.consoles.Subscribe(req.ServerID)
+
+	c.sendEvent("server_log", map[string]interface{}{
+		"serverId": req.ServerID,
+		"lines":    history,
+		"history":  true,
+	})
+
+	return &command.Result{
+		Message: "Subscribed to server console",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+			"lines":    len(history),
+		},
+	}, nil
+}
+
+// handleUnsubscribeConsole stops pushing a server's console output
+func (c *Client) handleUnsubscribeConsole(ctx context.Context, req *command.Request, _ *messages.EmptyPayload) (*command.Result, error) {
+	log.Printf("Unsubscribing from console of server: %s", req.ServerID)
+
+	c.consoles.Unsubscribe(req.ServerID)
+
+	return &command.Result{
+		Message: "Unsubscribed from server console",
+		Data: map[string]interface{}{
+			"serverId": req.ServerID,
+		},
+	}, nil
+}
@@ -0,0 +1,137 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+// Request is an action invoked by the panel over the WebSocket or HTTP
+type Request struct {
+	ID       string          // panel command ID, empty over HTTP
+	Action   string          // e.g. start_server
+	ServerID string          // server the action applies to, if any
+	Payload  json.RawMessage // action parameters, decoded into the command's payload type
+}
+
+// Result is the outcome of a successful action
+type Result struct {
+	Message string
+	Data    map[string]interface{}
+}
+
+// Error is a failed request carrying the code reported to the panel
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Command binds an action name to its payload type and handler
+type Command struct {
+	Action  string
+	Payload reflect.Type // type the payload is decoded into
+	// ServerScoped commands are rejected unless the request names a server
+	ServerScoped bool
+
+	run func(ctx context.Context, req *Request) (*Result, error)
+}
+
+// New creates a node-level command whose payload is strictly decoded into a
+// P and validated before fn runs
+func New[P any](action string, fn func(ctx context.Context, req *Request, payload *P) (*Result, error)) *Command {
+	return &Command{
+		Action:  action,
+		Payload: reflect.TypeFor[P](),
+		run: func(ctx context.Context, req *Request) (*Result, error) {
+			payload := new(P)
+			if err := messages.DecodePayload(req.Payload, payload); err != nil {
+				return nil, err
+			}
+			return fn(ctx, req, payload)
+		},
+	}
+}
+
+// ForServer creates a command acting on the server named by the request
+func ForServer[P any](action string, fn func(ctx context.Context, req *Request, payload *P) (*Result, error)) *Command {
+	cmd := New(action, fn)
+	cmd.ServerScoped = true
+	return cmd
+}
+
+// Registry holds every action the agent accepts. Both transports dispatch
+// through it, so an action behaves the same over the WebSocket and HTTP.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// NewRegistry creates an empty command registry
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]*Command)}
+}
+
+// Register adds commands to the registry. Registering an action twice is a
+// programming error and panics.
+func (r *Registry) Register(commands ...*Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, cmd := range commands {
+		if _, exists := r.commands[cmd.Action]; exists {
+			panic("command: action registered twice: " + cmd.Action)
+		}
+		r.commands[cmd.Action] = cmd
+	}
+}
+
+// Lookup returns the command registered for an action
+func (r *Registry) Lookup(action string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, ok := r.commands[action]
+	return cmd, ok
+}
+
+// Actions returns the names of all registered actions, sorted
+func (r *Registry) Actions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	actions := make([]string, 0, len(r.commands))
+	for action := range r.commands {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// Execute runs the command registered for a request's action
+func (r *Registry) Execute(ctx context.Context, req *Request) (*Result, error) {
+	cmd, ok := r.Lookup(req.Action)
+	if !ok {
+		return nil, &Error{Code: "UNKNOWN_ACTION", Message: fmt.Sprintf("unknown action: %s", req.Action)}
+	}
+	if cmd.ServerScoped && req.ServerID == "" {
+		return nil, &messages.ValidationError{Fields: []messages.FieldError{{Field: "serverId", Message: "is required"}}}
+	}
+
+	result, err := cmd.run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &Result{}
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/signedurl"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+)
+
+type echoPayload struct {
+	Text  string `json:"text"`
+	Times int    `json:"times"`
+}
+
+func newTestRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(
+		ForServer("echo", func(ctx context.Context, req *Request, p *echoPayload) (*Result, error) {
+			return &Result{Message: "echoed", Data: map[string]interface{}{
+				"serverId": req.ServerID,
+				"text":     p.Text,
+				"times":    p.Times,
+			}}, nil
+		}),
+		New("ping", func(ctx context.Context, req *Request, p *struct{}) (*Result, error) {
+			return nil, nil
+		}),
+	)
+	return r
+}
+
+func TestRegistry_Execute(t *testing.T) {
+	r := newTestRegistry()
+
+	result, err := r.Execute(context.Background(), &Request{
+		Action:   "echo",
+		ServerID: "s1",
+		Payload:  json.RawMessage(`{"text":"hi","times":2}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "echoed", result.Message)
+	assert.Equal(t, map[string]interface{}{"serverId": "s1", "text": "hi", "times": 2}, result.Data)
+
+	// Node-level commands need no server, and an empty payload is allowed
+	result, err = r.Execute(context.Background(), &Request{Action: "ping"})
+	require.NoError(t, err)
+	assert.NotNil(t, result)
+}
+
+func TestRegistry_RejectsBadRequests(t *testing.T) {
+	r := newTestRegistry()
+
+	tests := []struct {
+		name string
+		req  *Request
+		code string
+	}{
+		{"unknown action", &Request{Action: "nope"}, "UNKNOWN_ACTION"},
+		{"missing server", &Request{Action: "echo"}, "VALIDATION_ERROR"},
+		{"malformed payload", &Request{Action: "echo", ServerID: "s1", Payload: json.RawMessage(`{"times":"two"}`)}, "VALIDATION_ERROR"},
+		{"unknown field", &Request{Action: "echo", ServerID: "s1", Payload: json.RawMessage(`{"txt":"hi"}`)}, "VALIDATION_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := r.Execute(context.Background(), tt.req)
+			require.Error(t, err)
+			assert.Equal(t, tt.code, ErrorCode(err))
+		})
+	}
+}
+
+func TestRegistry_Actions(t *testing.T) {
+	r := newTestRegistry()
+
+	assert.Equal(t, []string{"echo", "ping"}, r.Actions())
+
+	cmd, ok := r.Lookup("echo")
+	require.True(t, ok)
+	assert.True(t, cmd.ServerScoped)
+	assert.Equal(t, "echoPayload", cmd.Payload.Name())
+
+	assert.Panics(t, func() {
+		r.Register(New("ping", func(ctx context.Context, req *Request, p *struct{}) (*Result, error) { return nil, nil }))
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&Error{Code: "INVALID_PATH", Message: "outside"}, "INVALID_PATH"},
+		{&messages.ValidationError{Fields: []messages.FieldError{{Field: "path", Message: "is required"}}}, "VALIDATION_ERROR"},
+		{fmt.Errorf("failed to read file: %w", files.ErrOutsideRoot), "INVALID_PATH"},
+		{fmt.Errorf("logs: %w", files.ErrDirNotEmpty), "DIRECTORY_NOT_EMPTY"},
+		{&files.OffsetError{Offset: 8}, "UPLOAD_OFFSET_MISMATCH"},
+		{fmt.Errorf("chunk: %w", files.ErrChecksumMismatch), "CHECKSUM_MISMATCH"},
+		{files.ErrUnknownFormat, "UNSUPPORTED_ARCHIVE"},
+		{signedurl.ErrInvalidSignature, "INVALID_SIGNATURE"},
+		{signedurl.ErrExpired, "URL_EXPIRED"},
+		{fmt.Errorf("%w: ../evil.txt", files.ErrUnsafeArchive), "UNSAFE_ARCHIVE"},
+		{fmt.Errorf("%w: more than 10 entries", files.ErrArchiveTooLarge), "ARCHIVE_TOO_LARGE"},
+		{&fs.PathError{Op: "open", Path: "missing.txt", Err: fs.ErrNotExist}, "FILE_NOT_FOUND"},
+		{fmt.Errorf("stop: %w", docker.ErrServerNotRunning), "SERVER_NOT_RUNNING"},
+		{docker.ErrMultilineCommand, "INVALID_COMMAND"},
+		{&state.TransitionError{ServerID: "s1", From: state.Offline, To: state.Stopping}, "INVALID_STATE_TRANSITION"},
+		{errors.New("boom"), "EXECUTION_ERROR"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ErrorCode(tt.err))
+	}
+}
+
+func TestErrorInfo(t *testing.T) {
+	_, err := newTestRegistry().Execute(context.Background(), &Request{
+		Action:   "echo",
+		ServerID: "s1",
+		Payload:  json.RawMessage(`{"text":1,"extra":true}`),
+	})
+	require.Error(t, err)
+
+	info := ErrorInfo(err)
+	assert.Equal(t, "VALIDATION_ERROR", info.Code)
+	assert.Equal(t, []messages.FieldError{
+		{Field: "extra", Message: "unknown field"},
+		{Field: "text", Message: "must be a string, not number"},
+	}, info.Fields)
+
+	info = ErrorInfo(errors.New("boom"))
+	assert.Equal(t, "EXECUTION_ERROR", info.Code)
+	assert.Empty(t, info.Fields)
+}
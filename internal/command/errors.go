@@ -0,0 +1,84 @@
+package command
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/signedurl"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+)
+
+// ErrorCode maps an execution error to a panel error code
+func ErrorCode(err error) string {
+	var cmdErr *Error
+	switch {
+	case errors.As(err, &cmdErr):
+		return cmdErr.Code
+	case errors.As(err, new(*messages.ValidationError)):
+		return "VALIDATION_ERROR"
+	case errors.Is(err, files.ErrOutsideRoot), errors.Is(err, files.ErrServerRoot), errors.Is(err, files.ErrIntoItself):
+		return "INVALID_PATH"
+	case errors.Is(err, files.ErrDirNotEmpty):
+		return "DIRECTORY_NOT_EMPTY"
+	case errors.Is(err, files.ErrUploadNotFound):
+		return "UPLOAD_NOT_FOUND"
+	case errors.As(err, new(*files.OffsetError)):
+		return "UPLOAD_OFFSET_MISMATCH"
+	case errors.Is(err, files.ErrUploadIncomplete):
+		return "UPLOAD_INCOMPLETE"
+	case errors.Is(err, files.ErrChunkTooLarge):
+		return "CHUNK_TOO_LARGE"
+	case errors.Is(err, files.ErrChecksumMismatch):
+		return "CHECKSUM_MISMATCH"
+	case errors.Is(err, files.ErrUnknownFormat):
+		return "UNSUPPORTED_ARCHIVE"
+	case errors.Is(err, files.ErrUnsafeArchive):
+		return "UNSAFE_ARCHIVE"
+	case errors.Is(err, files.ErrArchiveTooLarge):
+		return "ARCHIVE_TOO_LARGE"
+	case errors.Is(err, signedurl.ErrInvalidSignature):
+		return "INVALID_SIGNATURE"
+	case errors.Is(err, signedurl.ErrExpired):
+		return "URL_EXPIRED"
+	case errors.Is(err, docker.ErrServerNotRunning):
+		return "SERVER_NOT_RUNNING"
+	case errors.Is(err, docker.ErrStdinUnavailable):
+		return "CONSOLE_UNAVAILABLE"
+	case errors.Is(err, docker.ErrMultilineCommand):
+		return "INVALID_COMMAND"
+	case errors.As(err, new(*docker.PortConflictError)):
+		return "PORT_CONFLICT"
+	case errors.As(err, new(*docker.PortMappingError)):
+		return "INVALID_PORT_MAPPING"
+	case errors.Is(err, docker.ErrInvalidLimits):
+		return "INVALID_RESOURCE_LIMITS"
+	case errors.Is(err, docker.ErrImagePull):
+		return "IMAGE_PULL_FAILED"
+	case errors.Is(err, docker.ErrInvalidRestartPolicy):
+		return "INVALID_RESTART_POLICY"
+	case errors.Is(err, docker.ErrInvalidDonePattern):
+		return "INVALID_DONE_PATTERN"
+	case errors.As(err, new(*state.TransitionError)):
+		return "INVALID_STATE_TRANSITION"
+	case errors.Is(err, fs.ErrNotExist):
+		return "FILE_NOT_FOUND"
+	case errors.Is(err, fs.ErrExist):
+		return "FILE_EXISTS"
+	default:
+		return "EXECUTION_ERROR"
+	}
+}
+
+// ErrorInfo converts an execution error to the error reported to the
+// panel, listing the invalid fields of a validation error
+func ErrorInfo(err error) *messages.ErrorInfo {
+	info := &messages.ErrorInfo{Code: ErrorCode(err), Message: err.Error()}
+	var validationErr *messages.ValidationError
+	if errors.As(err, &validationErr) {
+		info.Fields = validationErr.Fields
+	}
+	return info
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds the application configuration
@@ -10,6 +13,65 @@ type Config struct {
 	NodeID     string
 	Secret     string
 	HealthPort string
+	DataDir    string
+
+	// ServerDataDir holds one persistent data directory per game server,
+	// bind-mounted into its container at ContainerDataPath
+	ServerDataDir     string
+	ContainerDataPath string
+	// ServerUID and ServerGID own newly created server data directories
+	ServerUID int
+	ServerGID int
+
+	// Registry credentials used to pull private images when a create request
+	// carries none; RegistryServer limits them to one registry
+	RegistryServer   string
+	RegistryUsername string
+	RegistryPassword string
+
+	// ReconcileRestore makes startup reconciliation start or stop containers
+	// to match the desired state recorded in the registry. Docker does not
+	// restart game containers, so this is what brings servers back after a
+	// host reboot.
+	ReconcileRestore bool
+
+	// StatsInterval is how often resource usage of running servers is pushed
+	// to the panel; zero disables the push
+	StatsInterval time.Duration
+
+	// DiskCheckInterval is how often servers whose disk limit the storage
+	// driver cannot enforce are measured; zero disables the check
+	DiskCheckInterval time.Duration
+
+	// Crash restart defaults for servers created without a restart policy:
+	// CrashRestartPolicy is never, on-crash or always, and a server is given
+	// up on after CrashMaxRestarts restarts within CrashRestartWindow. A
+	// CrashMaxRestarts of 0 disables crash restarts.
+	CrashRestartPolicy  string
+	CrashMaxRestarts    int
+	CrashRestartWindow  time.Duration
+	CrashRestartBackoff time.Duration
+
+	// CrashReportLines is how many console lines a crash report keeps
+	CrashReportLines int
+
+	// ReadyTimeout is how long a starting server may take to print its
+	// done pattern before it is considered running anyway
+	ReadyTimeout time.Duration
+
+	// UploadTTL is how long a chunked upload may stay idle before it is
+	// discarded
+	UploadTTL time.Duration
+
+	// Caps on what extracting one archive may produce, guarding against
+	// decompression bombs: ArchiveMaxSize bytes in ArchiveMaxEntries files
+	// and directories
+	ArchiveMaxSize    int64
+	ArchiveMaxEntries int
+
+	// SignedURLTTL is the default and longest lifetime of a signed file
+	// URL; zero disables signed URLs
+	SignedURLTTL time.Duration
 }
 
 // LoadConfig loads configuration from environment variables
@@ -34,10 +96,161 @@ func LoadConfig() (*Config, error) {
 		healthPort = "8081" // Default for development
 	}
 
+	dataDir := os.Getenv("AGENT_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "/var/lib/ctrl-alt-play-agent" // Agent state such as the server registry
+	}
+
+	serverDataDir := os.Getenv("SERVER_DATA_DIR")
+	if serverDataDir == "" {
+		serverDataDir = "/opt/gameservers"
+	}
+
+	containerDataPath := os.Getenv("CONTAINER_DATA_PATH")
+	if containerDataPath == "" {
+		containerDataPath = "/home/container"
+	}
+
+	serverUID, err := intEnv("SERVER_UID", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	serverGID, err := intEnv("SERVER_GID", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	reconcileRestore := true
+	if value := os.Getenv("RECONCILE_RESTORE_STATE"); value != "" {
+		reconcileRestore, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECONCILE_RESTORE_STATE %q: must be true or false", value)
+		}
+	}
+
+	statsInterval, err := durationEnv("STATS_INTERVAL", 5*time.Second) // Default push interval
+	if err != nil {
+		return nil, err
+	}
+
+	diskCheckInterval, err := durationEnv("DISK_CHECK_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	crashRestartPolicy := os.Getenv("CRASH_RESTART_POLICY")
+	switch crashRestartPolicy {
+	case "":
+		crashRestartPolicy = "on-crash"
+	case "never", "on-crash", "always":
+	default:
+		return nil, fmt.Errorf("invalid CRASH_RESTART_POLICY %q: must be never, on-crash or always", crashRestartPolicy)
+	}
+
+	crashMaxRestarts, err := intEnv("CRASH_MAX_RESTARTS", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	crashRestartWindow, err := durationEnv("CRASH_RESTART_WINDOW", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	crashRestartBackoff, err := durationEnv("CRASH_RESTART_BACKOFF", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	crashReportLines, err := intEnv("CRASH_REPORT_LINES", 200)
+	if err != nil {
+		return nil, err
+	}
+
+	readyTimeout, err := durationEnv("READY_TIMEOUT", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadTTL, err := durationEnv("UPLOAD_SESSION_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveMaxSize, err := intEnv("ARCHIVE_MAX_SIZE", 10<<30)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveMaxEntries, err := intEnv("ARCHIVE_MAX_ENTRIES", 100000)
+	if err != nil {
+		return nil, err
+	}
+
+	signedURLTTL, err := durationEnv("SIGNED_URL_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		PanelURL:   panelURL,
 		NodeID:     nodeID,
 		Secret:     secret,
 		HealthPort: healthPort,
+		DataDir:    dataDir,
+
+		ServerDataDir:     serverDataDir,
+		ContainerDataPath: containerDataPath,
+		ServerUID:         serverUID,
+		ServerGID:         serverGID,
+
+		RegistryServer:   os.Getenv("REGISTRY_SERVER"),
+		RegistryUsername: os.Getenv("REGISTRY_USERNAME"),
+		RegistryPassword: os.Getenv("REGISTRY_PASSWORD"),
+
+		ReconcileRestore: reconcileRestore,
+		StatsInterval:    statsInterval,
+
+		DiskCheckInterval: diskCheckInterval,
+
+		CrashRestartPolicy:  crashRestartPolicy,
+		CrashMaxRestarts:    crashMaxRestarts,
+		CrashRestartWindow:  crashRestartWindow,
+		CrashRestartBackoff: crashRestartBackoff,
+		CrashReportLines:    crashReportLines,
+
+		ReadyTimeout: readyTimeout,
+		UploadTTL:    uploadTTL,
+
+		ArchiveMaxSize:    int64(archiveMaxSize),
+		ArchiveMaxEntries: archiveMaxEntries,
+		SignedURLTTL:      signedURLTTL,
 	}, nil
 }
+
+// durationEnv reads a non-negative duration from the environment
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a duration such as 5s", name, value)
+	}
+	return d, nil
+}
+
+// intEnv reads a non-negative integer from the environment
+func intEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, value)
+	}
+	return n, nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,10 +12,32 @@ import (
 func TestLoadConfig(t *testing.T) {
 	// Save original env vars
 	originalVars := map[string]string{
-		"PANEL_URL":    os.Getenv("PANEL_URL"),
-		"NODE_ID":      os.Getenv("NODE_ID"),
-		"AGENT_SECRET": os.Getenv("AGENT_SECRET"),
-		"HEALTH_PORT":  os.Getenv("HEALTH_PORT"),
+		"PANEL_URL":      os.Getenv("PANEL_URL"),
+		"NODE_ID":        os.Getenv("NODE_ID"),
+		"AGENT_SECRET":   os.Getenv("AGENT_SECRET"),
+		"HEALTH_PORT":    os.Getenv("HEALTH_PORT"),
+		"AGENT_DATA_DIR": os.Getenv("AGENT_DATA_DIR"),
+		"STATS_INTERVAL": os.Getenv("STATS_INTERVAL"),
+
+		"DISK_CHECK_INTERVAL": os.Getenv("DISK_CHECK_INTERVAL"),
+		"SERVER_DATA_DIR":     os.Getenv("SERVER_DATA_DIR"),
+		"CONTAINER_DATA_PATH": os.Getenv("CONTAINER_DATA_PATH"),
+		"SERVER_UID":          os.Getenv("SERVER_UID"),
+		"SERVER_GID":          os.Getenv("SERVER_GID"),
+
+		"RECONCILE_RESTORE_STATE": os.Getenv("RECONCILE_RESTORE_STATE"),
+
+		"CRASH_RESTART_POLICY":  os.Getenv("CRASH_RESTART_POLICY"),
+		"CRASH_MAX_RESTARTS":    os.Getenv("CRASH_MAX_RESTARTS"),
+		"CRASH_RESTART_WINDOW":  os.Getenv("CRASH_RESTART_WINDOW"),
+		"CRASH_RESTART_BACKOFF": os.Getenv("CRASH_RESTART_BACKOFF"),
+		"CRASH_REPORT_LINES":    os.Getenv("CRASH_REPORT_LINES"),
+		"READY_TIMEOUT":         os.Getenv("READY_TIMEOUT"),
+
+		"UPLOAD_SESSION_TTL":  os.Getenv("UPLOAD_SESSION_TTL"),
+		"ARCHIVE_MAX_SIZE":    os.Getenv("ARCHIVE_MAX_SIZE"),
+		"ARCHIVE_MAX_ENTRIES": os.Getenv("ARCHIVE_MAX_ENTRIES"),
+		"SIGNED_URL_TTL":      os.Getenv("SIGNED_URL_TTL"),
 	}
 
 	// Clean up after test
@@ -47,36 +70,162 @@ func TestLoadConfig(t *testing.T) {
 				NodeID:     "test-node",
 				Secret:     "test-secret",
 				HealthPort: "8081",
+				DataDir:    "/var/lib/ctrl-alt-play-agent",
+
+				ServerDataDir:     "/opt/gameservers",
+				ContainerDataPath: "/home/container",
+				ServerUID:         1000,
+				ServerGID:         1000,
+
+				StatsInterval:     5 * time.Second,
+				DiskCheckInterval: time.Minute,
+				ReconcileRestore:  true,
+
+				CrashRestartPolicy:  "on-crash",
+				CrashMaxRestarts:    3,
+				CrashRestartWindow:  10 * time.Minute,
+				CrashRestartBackoff: 5 * time.Second,
+				CrashReportLines:    200,
+				ReadyTimeout:        5 * time.Minute,
+				UploadTTL:           24 * time.Hour,
+				ArchiveMaxSize:      10 << 30,
+				ArchiveMaxEntries:   100000,
+				SignedURLTTL:        15 * time.Minute,
 			},
 			wantErr: false,
 		},
 		{
-			name: "defaults when env vars not set",
+			name:    "defaults when env vars not set",
 			envVars: map[string]string{},
 			want: &Config{
 				PanelURL:   "ws://localhost:8080",
 				NodeID:     "node-1",
 				Secret:     "agent-secret",
 				HealthPort: "8081",
+				DataDir:    "/var/lib/ctrl-alt-play-agent",
+
+				ServerDataDir:     "/opt/gameservers",
+				ContainerDataPath: "/home/container",
+				ServerUID:         1000,
+				ServerGID:         1000,
+
+				StatsInterval:     5 * time.Second,
+				DiskCheckInterval: time.Minute,
+				ReconcileRestore:  true,
+
+				CrashRestartPolicy:  "on-crash",
+				CrashMaxRestarts:    3,
+				CrashRestartWindow:  10 * time.Minute,
+				CrashRestartBackoff: 5 * time.Second,
+				CrashReportLines:    200,
+				ReadyTimeout:        5 * time.Minute,
+				UploadTTL:           24 * time.Hour,
+				ArchiveMaxSize:      10 << 30,
+				ArchiveMaxEntries:   100000,
+				SignedURLTTL:        15 * time.Minute,
 			},
 			wantErr: false,
 		},
 		{
 			name: "custom values",
 			envVars: map[string]string{
-				"PANEL_URL":    "wss://production.example.com:8080",
-				"NODE_ID":      "prod-node-1",
-				"AGENT_SECRET": "super-secret-token",
-				"HEALTH_PORT":  "9090",
+				"PANEL_URL":      "wss://production.example.com:8080",
+				"NODE_ID":        "prod-node-1",
+				"AGENT_SECRET":   "super-secret-token",
+				"HEALTH_PORT":    "9090",
+				"AGENT_DATA_DIR": "/srv/agent",
+				"STATS_INTERVAL": "30s",
+
+				"DISK_CHECK_INTERVAL": "0",
+				"SERVER_DATA_DIR":     "/srv/games",
+				"CONTAINER_DATA_PATH": "/data",
+				"SERVER_UID":          "988",
+				"SERVER_GID":          "988",
+
+				"RECONCILE_RESTORE_STATE": "false",
+
+				"CRASH_RESTART_POLICY":  "always",
+				"CRASH_MAX_RESTARTS":    "5",
+				"CRASH_RESTART_WINDOW":  "1h",
+				"CRASH_RESTART_BACKOFF": "30s",
+				"CRASH_REPORT_LINES":    "50",
+				"READY_TIMEOUT":         "2m",
+				"UPLOAD_SESSION_TTL":    "1h",
+				"ARCHIVE_MAX_SIZE":      "1073741824",
+				"ARCHIVE_MAX_ENTRIES":   "5000",
+				"SIGNED_URL_TTL":        "5m",
 			},
 			want: &Config{
 				PanelURL:   "wss://production.example.com:8080",
 				NodeID:     "prod-node-1",
 				Secret:     "super-secret-token",
 				HealthPort: "9090",
+				DataDir:    "/srv/agent",
+
+				ServerDataDir:     "/srv/games",
+				ContainerDataPath: "/data",
+				ServerUID:         988,
+				ServerGID:         988,
+
+				StatsInterval:     30 * time.Second,
+				DiskCheckInterval: 0,
+				ReconcileRestore:  false,
+
+				CrashRestartPolicy:  "always",
+				CrashMaxRestarts:    5,
+				CrashRestartWindow:  time.Hour,
+				CrashRestartBackoff: 30 * time.Second,
+				CrashReportLines:    50,
+				ReadyTimeout:        2 * time.Minute,
+				UploadTTL:           time.Hour,
+				ArchiveMaxSize:      1 << 30,
+				ArchiveMaxEntries:   5000,
+				SignedURLTTL:        5 * time.Minute,
 			},
 			wantErr: false,
 		},
+		{
+			name: "invalid stats interval",
+			envVars: map[string]string{
+				"STATS_INTERVAL": "often",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative disk check interval",
+			envVars: map[string]string{
+				"DISK_CHECK_INTERVAL": "-1m",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid crash restart policy",
+			envVars: map[string]string{
+				"CRASH_RESTART_POLICY": "unless-stopped",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid archive max size",
+			envVars: map[string]string{
+				"ARCHIVE_MAX_SIZE": "10G",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid reconcile restore",
+			envVars: map[string]string{
+				"RECONCILE_RESTORE_STATE": "sometimes",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid server uid",
+			envVars: map[string]string{
+				"SERVER_UID": "container",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -86,6 +235,24 @@ func TestLoadConfig(t *testing.T) {
 			os.Unsetenv("NODE_ID")
 			os.Unsetenv("AGENT_SECRET")
 			os.Unsetenv("HEALTH_PORT")
+			os.Unsetenv("AGENT_DATA_DIR")
+			os.Unsetenv("STATS_INTERVAL")
+			os.Unsetenv("DISK_CHECK_INTERVAL")
+			os.Unsetenv("SERVER_DATA_DIR")
+			os.Unsetenv("CONTAINER_DATA_PATH")
+			os.Unsetenv("SERVER_UID")
+			os.Unsetenv("SERVER_GID")
+			os.Unsetenv("RECONCILE_RESTORE_STATE")
+			os.Unsetenv("CRASH_RESTART_POLICY")
+			os.Unsetenv("CRASH_MAX_RESTARTS")
+			os.Unsetenv("CRASH_RESTART_WINDOW")
+			os.Unsetenv("CRASH_RESTART_BACKOFF")
+			os.Unsetenv("CRASH_REPORT_LINES")
+			os.Unsetenv("READY_TIMEOUT")
+			os.Unsetenv("UPLOAD_SESSION_TTL")
+			os.Unsetenv("ARCHIVE_MAX_SIZE")
+			os.Unsetenv("ARCHIVE_MAX_ENTRIES")
+			os.Unsetenv("SIGNED_URL_TTL")
 
 			// Set test env vars
 			for key, value := range tt.envVars {
@@ -104,6 +271,24 @@ func TestLoadConfig(t *testing.T) {
 			assert.Equal(t, tt.want.NodeID, got.NodeID)
 			assert.Equal(t, tt.want.Secret, got.Secret)
 			assert.Equal(t, tt.want.HealthPort, got.HealthPort)
+			assert.Equal(t, tt.want.DataDir, got.DataDir)
+			assert.Equal(t, tt.want.ServerDataDir, got.ServerDataDir)
+			assert.Equal(t, tt.want.ContainerDataPath, got.ContainerDataPath)
+			assert.Equal(t, tt.want.ServerUID, got.ServerUID)
+			assert.Equal(t, tt.want.ServerGID, got.ServerGID)
+			assert.Equal(t, tt.want.StatsInterval, got.StatsInterval)
+			assert.Equal(t, tt.want.DiskCheckInterval, got.DiskCheckInterval)
+			assert.Equal(t, tt.want.ReconcileRestore, got.ReconcileRestore)
+			assert.Equal(t, tt.want.CrashRestartPolicy, got.CrashRestartPolicy)
+			assert.Equal(t, tt.want.CrashMaxRestarts, got.CrashMaxRestarts)
+			assert.Equal(t, tt.want.CrashRestartWindow, got.CrashRestartWindow)
+			assert.Equal(t, tt.want.CrashRestartBackoff, got.CrashRestartBackoff)
+			assert.Equal(t, tt.want.CrashReportLines, got.CrashReportLines)
+			assert.Equal(t, tt.want.ReadyTimeout, got.ReadyTimeout)
+			assert.Equal(t, tt.want.UploadTTL, got.UploadTTL)
+			assert.Equal(t, tt.want.ArchiveMaxSize, got.ArchiveMaxSize)
+			assert.Equal(t, tt.want.ArchiveMaxEntries, got.ArchiveMaxEntries)
+			assert.Equal(t, tt.want.SignedURLTTL, got.SignedURLTTL)
 		})
 	}
 }
@@ -194,11 +379,11 @@ func validateConfig(config *Config) error {
 	if config.PanelURL == "" || config.NodeID == "" || config.Secret == "" {
 		return assert.AnError
 	}
-	
+
 	// Check URL scheme
 	if !strings.HasPrefix(config.PanelURL, "ws://") && !strings.HasPrefix(config.PanelURL, "wss://") {
 		return assert.AnError
 	}
-	
+
 	return nil
 }
@@ -0,0 +1,100 @@
+package console
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLineLength caps a single console line; longer output is split
+const maxLineLength = 4096
+
+// Line is a single line of console output
+type Line struct {
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"` // stdout/stderr
+	Text      string    `json:"line"`
+}
+
+// RingBuffer keeps the most recent console lines up to a fixed capacity
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []Line
+	start int
+	size  int
+}
+
+// NewRingBuffer creates a ring buffer holding up to capacity lines
+func NewRingBuffer(capacity int) *RingBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingBuffer{lines: make([]Line, capacity)}
+}
+
+// Add appends a line, evicting the oldest one when full
+func (r *RingBuffer) Add(line Line) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size < len(r.lines) {
+		r.lines[(r.start+r.size)%len(r.lines)] = line
+		r.size++
+		return
+	}
+
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+// Lines returns the buffered lines, oldest first
+func (r *RingBuffer) Lines() []Line {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]Line, r.size)
+	for i := 0; i < r.size; i++ {
+		out[i] = r.lines[(r.start+i)%len(r.lines)]
+	}
+	return out
+}
+
+// lineWriter splits a byte stream into lines and hands each to emit
+type lineWriter struct {
+	buf  bytes.Buffer
+	emit func(text string)
+}
+
+// Write implements io.Writer
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+
+	for {
+		data := w.buf.Bytes()
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			if len(data) >= maxLineLength {
+				w.emit(string(data[:maxLineLength]))
+				w.buf.Next(maxLineLength)
+				continue
+			}
+			return len(p), nil
+		}
+
+		end := min(idx, maxLineLength)
+		w.emit(strings.TrimSuffix(string(data[:end]), "\r"))
+		w.buf.Next(end)
+		if end == idx {
+			w.buf.Next(1)
+		}
+	}
+}
+
+// Flush emits any trailing partial line
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.emit(strings.TrimSuffix(w.buf.String(), "\r"))
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,150 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSource serves a fixed multiplexed log stream
+type mockSource struct {
+	data []byte
+}
+
+func (m *mockSource) FollowLogs(ctx context.Context, containerID string, since time.Time, tail string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(m.data)), nil
+}
+
+// recordingSink captures emitted events
+type recordingSink struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data["event"] = event
+	s.events = append(s.events, data)
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+func multiplexed(t *testing.T, stdout, stderr string) []byte {
+	var buf bytes.Buffer
+	_, err := stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(stdout))
+	require.NoError(t, err)
+	_, err = stdcopy.NewStdWriter(&buf, stdcopy.Stderr).Write([]byte(stderr))
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestRingBuffer_KeepsMostRecentLines(t *testing.T) {
+	ring := NewRingBuffer(3)
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		ring.Add(Line{Text: text})
+	}
+
+	lines := ring.Lines()
+	require.Len(t, lines, 3)
+	assert.Equal(t, "c", lines[0].Text)
+	assert.Equal(t, "e", lines[2].Text)
+}
+
+func TestLineWriter_SplitsLines(t *testing.T) {
+	var got []string
+	w := &lineWriter{emit: func(text string) { got = append(got, text) }}
+
+	_, err := w.Write([]byte("first\r\nsec"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("ond\nthird"))
+	require.NoError(t, err)
+	w.Flush()
+
+	assert.Equal(t, []string{"first", "second", "third"}, got)
+}
+
+func TestHub_HistoryAndSubscription(t *testing.T) {
+	source := &mockSource{data: multiplexed(t,
+		"2025-01-23T10:00:00.000000001Z Server starting\n2025-01-23T10:00:01Z Done (3.2s)!\n",
+		"2025-01-23T10:00:02Z WARN low memory\n",
+	)}
+	sink := &recordingSink{}
+	hub := NewHub(source, sink)
+	defer hub.Close()
+
+	hub.Subscribe("server_1")
+	hub.Watch("server_1", "container_1")
+
+	require.Eventually(t, func() bool { return sink.count() > 0 }, time.Second, 10*time.Millisecond)
+
+	// A console opened later gets the same lines as its history
+	history := hub.Subscribe("server_1")
+	require.Len(t, history, 3)
+	assert.Equal(t, "Server starting", history[0].Text)
+	assert.Equal(t, "stdout", history[0].Stream)
+	assert.Equal(t, "stderr", history[2].Stream)
+	assert.Equal(t, time.Date(2025, 1, 23, 10, 0, 1, 0, time.UTC), history[1].Timestamp)
+
+	sink.mu.Lock()
+	evt := sink.events[0]
+	sink.mu.Unlock()
+	assert.Equal(t, "server_output", evt["event"])
+	assert.Equal(t, "server_1", evt["serverId"])
+}
+
+func TestHub_UnsubscribedServersAreNotPushed(t *testing.T) {
+	source := &mockSource{data: multiplexed(t, "2025-01-23T10:00:00Z hello\n", "")}
+	sink := &recordingSink{}
+	hub := NewHub(source, sink)
+	defer hub.Close()
+
+	hub.Watch("server_1", "container_1")
+
+	require.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		st, ok := hub.streams["server_1"]
+		return ok && len(st.history.Lines()) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(2 * flushInterval)
+	assert.Equal(t, 0, sink.count())
+}
+
+func TestHub_WaitReady(t *testing.T) {
+	source := &mockSource{data: multiplexed(t,
+		"2025-01-23T10:00:00Z Server starting\n2025-01-23T10:00:03Z Done (3.2s)! For help, type \"help\"\n", "",
+	)}
+	hub := NewHub(source, &recordingSink{})
+	defer hub.Close()
+	ctx := context.Background()
+	done := regexp.MustCompile(`^Done \(`)
+
+	err := hub.WaitReady(ctx, "server_1", "container_1", done, time.Date(2025, 1, 23, 10, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	// Output from before the start does not count
+	err = hub.WaitReady(ctx, "server_1", "container_1", done, time.Date(2025, 1, 23, 11, 0, 0, 0, time.UTC))
+	assert.ErrorIs(t, err, ErrConsoleClosed)
+}
+
+func TestHub_WaitReadyStreamEnds(t *testing.T) {
+	source := &mockSource{data: multiplexed(t, "2025-01-23T10:00:00Z Exception in thread main\n", "")}
+	hub := NewHub(source, &recordingSink{})
+	defer hub.Close()
+
+	err := hub.WaitReady(context.Background(), "server_1", "container_1", regexp.MustCompile("Done"), time.Time{})
+	assert.ErrorIs(t, err, ErrConsoleClosed)
+}
@@ -0,0 +1,360 @@
+package console
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+const (
+	// HistorySize is the number of recent lines kept per server
+	HistorySize = 500
+	// flushInterval is how often buffered output is pushed to subscribers
+	flushInterval = 250 * time.Millisecond
+	// maxPendingLines bounds unsent output per server between flushes
+	maxPendingLines = 2000
+)
+
+// ErrConsoleClosed is returned by WaitReady when the log stream of the
+// container ends before the pattern is seen
+var ErrConsoleClosed = errors.New("console stream closed")
+
+// LogSource follows the multiplexed log stream of a container
+type LogSource interface {
+	FollowLogs(ctx context.Context, containerID string, since time.Time, tail string) (io.ReadCloser, error)
+}
+
+// stream is the console state of one server
+type stream struct {
+	serverID    string
+	containerID string
+	history     *RingBuffer
+	pending     []Line
+	dropped     int
+	subscribed  bool
+	following   bool
+	cancel      context.CancelFunc
+	lastSeen    time.Time
+	waiters     []*readyWaiter
+}
+
+// readyWaiter is a WaitReady call waiting for a matching console line
+type readyWaiter struct {
+	containerID string
+	pattern     *regexp.Regexp
+	since       time.Time
+	done        chan error // buffered, receives at most one result
+}
+
+// matches reports whether a line of the waited-for container satisfies the waiter
+func (w *readyWaiter) matches(containerID string, line Line) bool {
+	return containerID == w.containerID && !line.Timestamp.Before(w.since) && w.pattern.MatchString(line.Text)
+}
+
+// Hub follows container logs, keeps recent history and pushes output
+// to the panel for servers someone is watching
+type Hub struct {
+	source  LogSource
+	events  messages.EventSink
+	mu      sync.Mutex
+	streams map[string]*stream
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewHub creates a console hub and starts its flush loop
+func NewHub(source LogSource, events messages.EventSink) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h := &Hub{
+		source:  source,
+		events:  events,
+		streams: make(map[string]*stream),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	go h.flushLoop()
+
+	return h
+}
+
+// Watch starts following a server's container logs if not already doing so
+func (h *Hub) Watch(serverID, containerID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := h.streamLocked(serverID)
+	if st.following && st.containerID == containerID {
+		return
+	}
+	if st.cancel != nil {
+		st.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	st.containerID = containerID
+	st.following = true
+	st.cancel = cancel
+
+	go h.follow(ctx, st, containerID)
+}
+
+// WaitReady follows a server's console until a line printed at or after
+// since matches pattern. It returns ErrConsoleClosed if the log stream
+// ends first, e.g. because the container exited.
+func (h *Hub) WaitReady(ctx context.Context, serverID, containerID string, pattern *regexp.Regexp, since time.Time) error {
+	w := &readyWaiter{containerID: containerID, pattern: pattern, since: since, done: make(chan error, 1)}
+
+	h.mu.Lock()
+	st := h.streamLocked(serverID)
+	if st.containerID == containerID {
+		for _, line := range st.history.Lines() {
+			if w.matches(containerID, line) {
+				h.mu.Unlock()
+				return nil
+			}
+		}
+	}
+	st.waiters = append(st.waiters, w)
+	h.mu.Unlock()
+
+	defer h.removeWaiter(serverID, w)
+	h.Watch(serverID, containerID)
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// removeWaiter drops a finished WaitReady call
+func (h *Hub) removeWaiter(serverID string, w *readyWaiter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, ok := h.streams[serverID]
+	if !ok {
+		return
+	}
+	for i, waiter := range st.waiters {
+		if waiter == w {
+			st.waiters = append(st.waiters[:i], st.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseWaitersLocked completes the waiters selected by match with err.
+// Callers must hold h.mu.
+func releaseWaitersLocked(st *stream, match func(w *readyWaiter) bool, err error) {
+	kept := st.waiters[:0]
+	for _, w := range st.waiters {
+		if match(w) {
+			w.done <- err
+			continue
+		}
+		kept = append(kept, w)
+	}
+	st.waiters = kept
+}
+
+// Subscribe enables live output for a server and returns its recent history
+func (h *Hub) Subscribe(serverID string) []Line {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := h.streamLocked(serverID)
+	st.subscribed = true
+	st.pending = nil
+	st.dropped = 0
+	return st.history.Lines()
+}
+
+// Unsubscribe stops pushing live output for a server
+func (h *Hub) Unsubscribe(serverID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if st, ok := h.streams[serverID]; ok {
+		st.subscribed = false
+		st.pending = nil
+	}
+}
+
+// UnsubscribeAll stops pushing output for every server, e.g. when the panel disconnects
+func (h *Hub) UnsubscribeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, st := range h.streams {
+		st.subscribed = false
+		st.pending = nil
+	}
+}
+
+// Forget stops following a server and discards its history
+func (h *Hub) Forget(serverID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if st, ok := h.streams[serverID]; ok {
+		if st.cancel != nil {
+			st.cancel()
+		}
+		delete(h.streams, serverID)
+	}
+}
+
+// Close stops all log followers
+func (h *Hub) Close() {
+	h.cancel()
+}
+
+// streamLocked returns the stream for a server, creating it if needed. Callers must hold h.mu.
+func (h *Hub) streamLocked(serverID string) *stream {
+	st, ok := h.streams[serverID]
+	if !ok {
+		st = &stream{serverID: serverID, history: NewRingBuffer(HistorySize)}
+		h.streams[serverID] = st
+	}
+	return st
+}
+
+// follow copies a container's log stream into the server's console until it ends
+func (h *Hub) follow(ctx context.Context, st *stream, containerID string) {
+	defer func() {
+		h.mu.Lock()
+		if st.containerID == containerID {
+			st.following = false
+			releaseWaitersLocked(st, func(w *readyWaiter) bool {
+				return w.containerID == containerID
+			}, ErrConsoleClosed)
+		}
+		h.mu.Unlock()
+	}()
+
+	h.mu.Lock()
+	since := st.lastSeen
+	h.mu.Unlock()
+
+	// Resume after the last line we saw, or backfill history on first follow
+	tail := "all"
+	if since.IsZero() {
+		tail = strconv.Itoa(HistorySize)
+	} else {
+		since = since.Add(time.Nanosecond)
+	}
+
+	rc, err := h.source.FollowLogs(ctx, containerID, since, tail)
+	if err != nil {
+		log.Printf("Error following logs for server %s: %v", st.serverID, err)
+		return
+	}
+	defer rc.Close()
+
+	stdout := &lineWriter{emit: func(text string) { h.appendLine(st, containerID, "stdout", text) }}
+	stderr := &lineWriter{emit: func(text string) { h.appendLine(st, containerID, "stderr", text) }}
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, rc); err != nil && ctx.Err() == nil {
+		log.Printf("Log stream for server %s ended: %v", st.serverID, err)
+	}
+	stdout.Flush()
+	stderr.Flush()
+}
+
+// appendLine records a timestamped log line from the Docker log stream
+func (h *Hub) appendLine(st *stream, containerID, streamName, raw string) {
+	line := Line{Timestamp: time.Now().UTC(), Stream: streamName, Text: raw}
+
+	// Docker prefixes each line with an RFC3339Nano timestamp
+	if idx := strings.IndexByte(raw, ' '); idx > 0 {
+		if ts, err := time.Parse(time.RFC3339Nano, raw[:idx]); err == nil {
+			line.Timestamp = ts
+			line.Text = raw[idx+1:]
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if st.containerID != containerID {
+		return
+	}
+
+	st.history.Add(line)
+	if line.Timestamp.After(st.lastSeen) {
+		st.lastSeen = line.Timestamp
+	}
+	if len(st.waiters) > 0 {
+		releaseWaitersLocked(st, func(w *readyWaiter) bool {
+			return w.matches(containerID, line)
+		}, nil)
+	}
+
+	if st.subscribed {
+		if len(st.pending) >= maxPendingLines {
+			st.pending = st.pending[1:]
+			st.dropped++
+		}
+		st.pending = append(st.pending, line)
+	}
+}
+
+// flushLoop periodically pushes batched output to the panel
+func (h *Hub) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			h.flush()
+		}
+	}
+}
+
+// flush sends one server_output event per server with pending output
+func (h *Hub) flush() {
+	type batch struct {
+		serverID string
+		lines    []Line
+		dropped  int
+	}
+
+	h.mu.Lock()
+	var batches []batch
+	for _, st := range h.streams {
+		if !st.subscribed || len(st.pending) == 0 {
+			continue
+		}
+		batches = append(batches, batch{serverID: st.serverID, lines: st.pending, dropped: st.dropped})
+		st.pending = nil
+		st.dropped = 0
+	}
+	h.mu.Unlock()
+
+	for _, b := range batches {
+		data := map[string]interface{}{
+			"serverId": b.serverID,
+			"lines":    b.lines,
+		}
+		if b.dropped > 0 {
+			data["dropped"] = b.dropped
+		}
+		h.events.SendEvent("server_output", data)
+	}
+}
@@ -0,0 +1,224 @@
+package crash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+const (
+	// ReportDir is the directory inside a server's data directory that
+	// holds its crash reports
+	ReportDir = "crash-reports"
+	// maxReports is how many crash reports are kept per server
+	maxReports = 10
+	// summaryLines is how many console lines the crash event carries
+	summaryLines = 10
+)
+
+// LogSource is the subset of docker.Manager used to capture crash reports
+type LogSource interface {
+	InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error)
+	TailLogs(ctx context.Context, containerID string, lines int) ([]string, error)
+}
+
+// StatsHistory provides the last resource sample of a server
+type StatsHistory interface {
+	Latest(serverID string) *docker.ContainerStats
+}
+
+// Report is the evidence left by a crashed server
+type Report struct {
+	ServerID    string                 `json:"serverId"`
+	ContainerID string                 `json:"containerId"`
+	ExitCode    int                    `json:"exitCode"`
+	OOMKilled   bool                   `json:"oomKilled"`
+	Error       string                 `json:"error,omitempty"` // error reported by Docker, if any
+	StartedAt   string                 `json:"startedAt,omitempty"`
+	FinishedAt  time.Time              `json:"finishedAt"`
+	Stats       *docker.ContainerStats `json:"stats,omitempty"` // last sample before the crash
+	Config      ReportConfig           `json:"config"`
+	Logs        []string               `json:"logs"`
+}
+
+// ReportConfig is the container configuration relevant to a crash.
+// Environment values are left out as they often hold secrets.
+type ReportConfig struct {
+	Image       string            `json:"image"`
+	Cmd         []string          `json:"cmd"`
+	Environment []string          `json:"environment"` // variable names only
+	Labels      map[string]string `json:"labels"`
+	Memory      int64             `json:"memory"`
+	MemorySwap  int64             `json:"memorySwap"`
+	NanoCPUs    int64             `json:"nanoCpus"`
+	CPUShares   int64             `json:"cpuShares"`
+	CPUSet      string            `json:"cpuset,omitempty"`
+	PidsLimit   *int64            `json:"pidsLimit,omitempty"`
+}
+
+// Reporter writes a crash report into the data directory of every server
+// that crashes
+type Reporter struct {
+	docker  LogSource
+	stats   StatsHistory
+	dataDir string
+	lines   int
+}
+
+// NewReporter creates a crash reporter keeping the last lines of console
+// output. stats may be nil when resource usage is not streamed.
+func NewReporter(source LogSource, stats StatsHistory, dataDir string, lines int) *Reporter {
+	return &Reporter{
+		docker:  source,
+		stats:   stats,
+		dataDir: dataDir,
+		lines:   lines,
+	}
+}
+
+// HandleCrash captures and stores a crash report, returning the summary
+// attached to the crash event
+func (r *Reporter) HandleCrash(ctx context.Context, exit watch.Exit) map[string]interface{} {
+	report := r.Capture(ctx, exit)
+
+	summary := map[string]interface{}{
+		"lastLines": lastLines(report.Logs, summaryLines),
+	}
+	if report.Error != "" {
+		summary["error"] = report.Error
+	}
+	if report.Stats != nil {
+		summary["memoryUsage"] = report.Stats.MemoryUsage
+		summary["memoryLimit"] = report.Stats.MemoryLimit
+	}
+
+	path, err := r.Write(report)
+	if err != nil {
+		log.Printf("Error writing crash report for server %s: %v", exit.ServerID, err)
+		summary["reportError"] = err.Error()
+		return summary
+	}
+	summary["file"] = path
+	return summary
+}
+
+// Capture gathers what is known about a crashed container. Parts that
+// cannot be read are left empty.
+func (r *Reporter) Capture(ctx context.Context, exit watch.Exit) *Report {
+	report := &Report{
+		ServerID:    exit.ServerID,
+		ContainerID: exit.ContainerID,
+		ExitCode:    exit.ExitCode,
+		OOMKilled:   exit.OOMKilled,
+		FinishedAt:  exit.FinishedAt,
+		Logs:        []string{},
+	}
+
+	if info, err := r.docker.InspectContainer(ctx, exit.ContainerID); err == nil {
+		if info.ContainerJSONBase != nil && info.State != nil {
+			report.Error = info.State.Error
+			report.StartedAt = info.State.StartedAt
+		}
+		if info.Config != nil {
+			report.Config.Image = info.Config.Image
+			report.Config.Cmd = info.Config.Cmd
+			report.Config.Labels = info.Config.Labels
+			for _, env := range info.Config.Env {
+				name, _, _ := strings.Cut(env, "=")
+				report.Config.Environment = append(report.Config.Environment, name)
+			}
+		}
+		if info.ContainerJSONBase != nil && info.HostConfig != nil {
+			resources := info.HostConfig.Resources
+			report.Config.Memory = resources.Memory
+			report.Config.MemorySwap = resources.MemorySwap
+			report.Config.NanoCPUs = resources.NanoCPUs
+			report.Config.CPUShares = resources.CPUShares
+			report.Config.CPUSet = resources.CpusetCpus
+			report.Config.PidsLimit = resources.PidsLimit
+		}
+	} else {
+		log.Printf("Error inspecting crashed container %s: %v", exit.ContainerID, err)
+	}
+
+	if logs, err := r.docker.TailLogs(ctx, exit.ContainerID, r.lines); err == nil {
+		report.Logs = append(report.Logs, logs...)
+	} else {
+		log.Printf("Error reading logs of crashed container %s: %v", exit.ContainerID, err)
+	}
+
+	if r.stats != nil {
+		report.Stats = r.stats.Latest(exit.ServerID)
+	}
+	return report
+}
+
+// Write stores a report under the server's data directory and returns its
+// path relative to that directory. Only the newest reports are kept. The
+// game controls its data directory, so the report is written through
+// files.Dir and a planted symlink cannot redirect it.
+func (r *Reporter) Write(report *Report) (string, error) {
+	dir, err := files.OpenServerDir(r.dataDir, report.ServerID, nil)
+	if err != nil {
+		return "", err
+	}
+	defer dir.Close()
+
+	if err := dir.MkdirAll(ReportDir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create crash report directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := path.Join(ReportDir, "crash-"+report.FinishedAt.UTC().Format("20060102T150405.000Z")+".json")
+	if err := dir.WriteFile(name, data, 0o640); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+
+	prune(dir)
+	return name, nil
+}
+
+// prune removes all but the newest crash reports of a server
+func prune(dir *files.Dir) {
+	entries, err := dir.ReadDir(ReportDir)
+	if err != nil {
+		return
+	}
+	var reports []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), "crash-") && strings.HasSuffix(entry.Name(), ".json") {
+			reports = append(reports, entry.Name())
+		}
+	}
+	if len(reports) <= maxReports {
+		return
+	}
+	// Names embed the crash time, so they sort chronologically
+	sort.Strings(reports)
+	for _, name := range reports[:len(reports)-maxReports] {
+		if err := dir.Remove(path.Join(ReportDir, name)); err != nil {
+			log.Printf("Error removing old crash report %s: %v", name, err)
+		}
+	}
+}
+
+// lastLines returns up to n lines from the end of lines
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
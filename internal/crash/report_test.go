@@ -0,0 +1,147 @@
+package crash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/files"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+type mockLogSource struct {
+	info container.InspectResponse
+	logs []string
+	err  error
+}
+
+func (m *mockLogSource) InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	return m.info, m.err
+}
+
+func (m *mockLogSource) TailLogs(ctx context.Context, containerID string, lines int) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if len(m.logs) > lines {
+		return m.logs[len(m.logs)-lines:], nil
+	}
+	return m.logs, nil
+}
+
+type staticStats map[string]*docker.ContainerStats
+
+func (s staticStats) Latest(serverID string) *docker.ContainerStats { return s[serverID] }
+
+func crashedContainer() container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			State: &container.State{ExitCode: 137, OOMKilled: true, StartedAt: "2025-01-23T09:00:00Z"},
+			HostConfig: &container.HostConfig{
+				Resources: container.Resources{Memory: 1 << 30, NanoCPUs: 2e9},
+			},
+		},
+		Config: &container.Config{
+			Image:  "minecraft:latest",
+			Cmd:    []string{"/bin/sh", "-c", "java -jar server.jar"},
+			Env:    []string{"EULA=true", "RCON_PASSWORD=hunter2"},
+			Labels: map[string]string{docker.LabelServerID: "s1"},
+		},
+	}
+}
+
+func TestReporter_HandleCrash(t *testing.T) {
+	var logs []string
+	for i := 1; i <= 30; i++ {
+		logs = append(logs, fmt.Sprintf("line %d", i))
+	}
+	source := &mockLogSource{info: crashedContainer(), logs: logs}
+	stats := staticStats{"s1": {MemoryUsage: 1 << 30, MemoryLimit: 1 << 30}}
+	dataDir := t.TempDir()
+	r := NewReporter(source, stats, dataDir, 20)
+
+	finished := time.Date(2025, 1, 23, 10, 0, 0, 0, time.UTC)
+	summary := r.HandleCrash(context.Background(), watch.Exit{
+		ServerID: "s1", ContainerID: "c1", ExitCode: 137, OOMKilled: true, FinishedAt: finished,
+	})
+
+	assert.Equal(t, "crash-reports/crash-20250123T100000.000Z.json", summary["file"])
+	assert.Equal(t, logs[20:], summary["lastLines"])
+	assert.Equal(t, int64(1<<30), summary["memoryUsage"])
+
+	data, err := os.ReadFile(filepath.Join(dataDir, "s1", summary["file"].(string)))
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	assert.Equal(t, 137, report.ExitCode)
+	assert.True(t, report.OOMKilled)
+	assert.Equal(t, logs[10:], report.Logs)
+	assert.Equal(t, "minecraft:latest", report.Config.Image)
+	assert.Equal(t, int64(1<<30), report.Config.Memory)
+	assert.Equal(t, []string{"EULA", "RCON_PASSWORD"}, report.Config.Environment)
+	assert.NotContains(t, string(data), "hunter2")
+	require.NotNil(t, report.Stats)
+}
+
+func TestReporter_ContainerGone(t *testing.T) {
+	source := &mockLogSource{err: errors.New("no such container")}
+	r := NewReporter(source, nil, t.TempDir(), 20)
+
+	summary := r.HandleCrash(context.Background(), watch.Exit{ServerID: "s1", ContainerID: "c1", ExitCode: 1})
+
+	assert.Empty(t, summary["lastLines"])
+	assert.NotEmpty(t, summary["file"], "the exit details are still recorded")
+}
+
+func TestReporter_KeepsNewestReports(t *testing.T) {
+	dataDir := t.TempDir()
+	r := NewReporter(&mockLogSource{}, nil, dataDir, 20)
+	start := time.Date(2025, 1, 23, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxReports+3; i++ {
+		_, err := r.Write(&Report{ServerID: "s1", FinishedAt: start.Add(time.Duration(i) * time.Minute)})
+		require.NoError(t, err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dataDir, "s1", ReportDir, "crash-*.json"))
+	require.NoError(t, err)
+	require.Len(t, matches, maxReports)
+	assert.Equal(t, "crash-20250123T100300.000Z.json", filepath.Base(matches[0]))
+}
+
+func TestReporter_RejectsBadServerID(t *testing.T) {
+	r := NewReporter(&mockLogSource{}, nil, t.TempDir(), 20)
+
+	_, err := r.Write(&Report{ServerID: "../etc"})
+	assert.Error(t, err)
+}
+
+func TestReporter_IgnoresPlantedSymlink(t *testing.T) {
+	dataDir := t.TempDir()
+	outside := t.TempDir()
+	for i := 0; i < maxReports+1; i++ {
+		name := fmt.Sprintf("crash-2025012%dT100000.000Z.json", i)
+		require.NoError(t, os.WriteFile(filepath.Join(outside, name), []byte("{}"), 0o644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "s1"), 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dataDir, "s1", ReportDir)))
+
+	r := NewReporter(&mockLogSource{}, nil, dataDir, 20)
+	_, err := r.Write(&Report{ServerID: "s1", FinishedAt: time.Now()})
+	assert.ErrorIs(t, err, files.ErrOutsideRoot)
+
+	matches, err := filepath.Glob(filepath.Join(outside, "crash-*.json"))
+	require.NoError(t, err)
+	assert.Len(t, matches, maxReports+1, "files behind the link are neither written nor pruned")
+}
@@ -0,0 +1,190 @@
+package crash
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+// maxBackoff caps the delay between restart attempts
+const maxBackoff = 5 * time.Minute
+
+// ContainerAPI is the subset of docker.Manager used by the supervisor
+type ContainerAPI interface {
+	InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error)
+	StartContainer(ctx context.Context, containerID string) error
+}
+
+// StateMachine is the subset of state.Machine used by the supervisor
+type StateMachine interface {
+	Set(serverID string, to state.State)
+	DoWait(serverID string, during, done state.State, fn, wait func(details map[string]interface{}) error) error
+}
+
+// Supervisor restarts servers that exit on their own according to their
+// restart policy. A server restarted more than MaxRestarts times within
+// Window is left crashed until an operator starts it again; a MaxRestarts
+// of 0 means it is never restarted.
+type Supervisor struct {
+	docker   ContainerAPI
+	states   StateMachine
+	events   messages.EventSink
+	defaults docker.RestartPolicy
+	ready    state.ReadyCheck
+
+	mu       sync.Mutex
+	restarts map[string][]time.Time // restart times per server within the window
+	pending  map[string]*time.Timer
+}
+
+// NewSupervisor creates a crash supervisor. defaults applies to servers
+// created without a restart policy and fills fields a policy leaves zero.
+func NewSupervisor(dockerAPI ContainerAPI, states StateMachine, events messages.EventSink, defaults docker.RestartPolicy) *Supervisor {
+	return &Supervisor{
+		docker:   dockerAPI,
+		states:   states,
+		events:   events,
+		defaults: defaults,
+		restarts: make(map[string][]time.Time),
+		pending:  make(map[string]*time.Timer),
+	}
+}
+
+// SetReadyCheck makes restarted servers stay starting until check reports
+// them ready
+func (s *Supervisor) SetReadyCheck(check state.ReadyCheck) {
+	s.ready = check
+}
+
+// HandleExit decides whether an exited server is restarted
+func (s *Supervisor) HandleExit(ctx context.Context, exit watch.Exit) {
+	if exit.UserInitiated {
+		s.Cancel(exit.ServerID)
+		return
+	}
+
+	info, err := s.docker.InspectContainer(ctx, exit.ContainerID)
+	if err != nil {
+		// The container is gone, so there is nothing to restart
+		return
+	}
+	var labels map[string]string
+	if info.Config != nil {
+		labels = info.Config.Labels
+	}
+	policy := docker.ParseRestartPolicy(labels).Merge(s.defaults)
+
+	switch {
+	case policy.RestartLimit() == 0:
+		return
+	case policy.Policy == docker.RestartAlways:
+	case policy.Policy == docker.RestartOnCrash && exit.Crashed():
+	default:
+		return
+	}
+
+	delay, attempt, ok := s.schedule(exit, policy)
+	if !ok {
+		log.Printf("Server %s crashed %d times within %s, not restarting", exit.ServerID, policy.RestartLimit(), policy.WindowDuration())
+		s.states.Set(exit.ServerID, state.Crashed)
+		s.events.SendEvent("crash_loop_detected", map[string]interface{}{
+			"serverId":    exit.ServerID,
+			"containerId": exit.ContainerID,
+			"restarts":    policy.RestartLimit(),
+			"window":      policy.Window,
+			"exitCode":    exit.ExitCode,
+			"oomKilled":   exit.OOMKilled,
+		})
+		return
+	}
+
+	log.Printf("Restarting server %s in %s (attempt %d of %d)", exit.ServerID, delay, attempt, policy.RestartLimit())
+	s.events.SendEvent("server_restart_scheduled", map[string]interface{}{
+		"serverId":    exit.ServerID,
+		"attempt":     attempt,
+		"maxRestarts": policy.RestartLimit(),
+		"delay":       delay.Seconds(),
+		"policy":      policy.Policy,
+	})
+}
+
+// schedule records a restart attempt and arms its timer. It returns false
+// when the server has used up its restarts within the window.
+func (s *Supervisor) schedule(exit watch.Exit, policy docker.RestartPolicy) (time.Duration, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	recent := s.restarts[exit.ServerID][:0]
+	for _, at := range s.restarts[exit.ServerID] {
+		if now.Sub(at) < policy.WindowDuration() {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= policy.RestartLimit() {
+		// Start counting afresh once an operator brings the server back
+		delete(s.restarts, exit.ServerID)
+		return 0, 0, false
+	}
+	s.restarts[exit.ServerID] = append(recent, now)
+
+	attempt := len(recent) + 1
+	delay := policy.BackoffDuration()
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+
+	if timer, ok := s.pending[exit.ServerID]; ok {
+		timer.Stop()
+	}
+	s.pending[exit.ServerID] = time.AfterFunc(delay, func() {
+		s.restart(exit.ServerID, exit.ContainerID)
+	})
+	return delay, attempt, true
+}
+
+// restart starts a server's container again after its backoff
+func (s *Supervisor) restart(serverID, containerID string) {
+	s.mu.Lock()
+	delete(s.pending, serverID)
+	s.mu.Unlock()
+
+	var wait func(map[string]interface{}) error
+	if s.ready != nil {
+		wait = s.ready(serverID, containerID)
+	}
+	ctx := context.Background()
+	err := s.states.DoWait(serverID, state.Starting, state.Running, func(details map[string]interface{}) error {
+		details["autoRestart"] = true
+		return s.docker.StartContainer(ctx, containerID)
+	}, wait)
+
+	var transitionErr *state.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		// An operator acted on the server in the meantime
+		log.Printf("Skipping restart of server %s: %v", serverID, err)
+	case err != nil:
+		log.Printf("Error restarting server %s: %v", serverID, err)
+	}
+}
+
+// Cancel drops a pending restart of a server, e.g. because an operator
+// stopped it while it waited out its backoff
+func (s *Supervisor) Cancel(serverID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timer, ok := s.pending[serverID]; ok {
+		timer.Stop()
+		delete(s.pending, serverID)
+	}
+}
@@ -0,0 +1,208 @@
+package crash
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+type mockDocker struct {
+	mu      sync.Mutex
+	labels  map[string]string
+	started []string
+}
+
+func (m *mockDocker) InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	if m.labels == nil {
+		return container.InspectResponse{}, errors.New("no such container")
+	}
+	return container.InspectResponse{Config: &container.Config{Labels: m.labels}}, nil
+}
+
+func (m *mockDocker) StartContainer(ctx context.Context, containerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, containerID)
+	return nil
+}
+
+func (m *mockDocker) startCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.started)
+}
+
+type mockStates struct {
+	mu  sync.Mutex
+	set map[string]state.State
+}
+
+func (m *mockStates) Set(serverID string, to state.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set[serverID] = to
+}
+
+func (m *mockStates) DoWait(serverID string, during, done state.State, fn, wait func(map[string]interface{}) error) error {
+	return fn(map[string]interface{}{})
+}
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data["event"] = event
+	s.events = append(s.events, data)
+}
+
+func newTestSupervisor(labels map[string]string) (*Supervisor, *mockDocker, *mockStates, *recordingSink) {
+	dockerAPI := &mockDocker{labels: labels}
+	states := &mockStates{set: make(map[string]state.State)}
+	sink := &recordingSink{}
+	maxRestarts := 2
+	defaults := docker.RestartPolicy{Policy: docker.RestartOnCrash, MaxRestarts: &maxRestarts, Window: 600}
+	return NewSupervisor(dockerAPI, states, sink, defaults), dockerAPI, states, sink
+}
+
+func crashed() watch.Exit {
+	return watch.Exit{ServerID: "s1", ContainerID: "c1", ExitCode: 1}
+}
+
+func TestSupervisor_RestartsCrashes(t *testing.T) {
+	s, dockerAPI, _, sink := newTestSupervisor(map[string]string{})
+
+	s.HandleExit(context.Background(), crashed())
+
+	assert.Eventually(t, func() bool { return dockerAPI.startCount() == 1 }, time.Second, 10*time.Millisecond)
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "server_restart_scheduled", sink.events[0]["event"])
+	assert.Equal(t, 1, sink.events[0]["attempt"])
+}
+
+func TestSupervisor_CrashLoop(t *testing.T) {
+	s, dockerAPI, states, sink := newTestSupervisor(map[string]string{})
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		s.HandleExit(ctx, crashed())
+		assert.Eventually(t, func() bool { return dockerAPI.startCount() == i }, time.Second, 10*time.Millisecond)
+	}
+	s.HandleExit(ctx, crashed())
+
+	require.Len(t, sink.events, 3)
+	loop := sink.events[2]
+	assert.Equal(t, "crash_loop_detected", loop["event"])
+	assert.Equal(t, 2, loop["restarts"])
+	assert.Equal(t, state.Crashed, states.set["s1"])
+
+	// Once an operator starts the server again it gets a fresh budget
+	s.HandleExit(ctx, crashed())
+	assert.Equal(t, "server_restart_scheduled", sink.events[3]["event"])
+	assert.Equal(t, 1, sink.events[3]["attempt"])
+}
+
+func TestSupervisor_Policies(t *testing.T) {
+	clean := watch.Exit{ServerID: "s1", ContainerID: "c1"}
+	stopped := watch.Exit{ServerID: "s1", ContainerID: "c1", ExitCode: 143, UserInitiated: true}
+
+	tests := []struct {
+		name    string
+		policy  string
+		exit    watch.Exit
+		restart bool
+	}{
+		{"on-crash restarts a crash", docker.RestartOnCrash, crashed(), true},
+		{"on-crash ignores a clean exit", docker.RestartOnCrash, clean, false},
+		{"always restarts a clean exit", docker.RestartAlways, clean, true},
+		{"always ignores a user stop", docker.RestartAlways, stopped, false},
+		{"never ignores a crash", docker.RestartNever, crashed(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels := map[string]string{docker.LabelRestartPolicy: `{"policy":"` + tt.policy + `"}`}
+			s, _, _, sink := newTestSupervisor(labels)
+
+			s.HandleExit(context.Background(), tt.exit)
+
+			if tt.restart {
+				require.Len(t, sink.events, 1)
+				assert.Equal(t, tt.policy, sink.events[0]["policy"])
+			} else {
+				assert.Empty(t, sink.events)
+			}
+		})
+	}
+}
+
+func TestSupervisor_ZeroMaxRestartsNeverRestarts(t *testing.T) {
+	labels := map[string]string{docker.LabelRestartPolicy: `{"policy":"always","maxRestarts":0}`}
+	s, dockerAPI, states, sink := newTestSupervisor(labels)
+
+	s.HandleExit(context.Background(), crashed())
+
+	// No restart and no crash loop: the crash itself is all there is to report
+	assert.Empty(t, sink.events)
+	assert.Zero(t, dockerAPI.startCount())
+	assert.NotContains(t, states.set, "s1")
+}
+
+func TestSupervisor_Backoff(t *testing.T) {
+	labels := map[string]string{docker.LabelRestartPolicy: `{"maxRestarts":10,"backoff":10}`}
+	s, _, _, sink := newTestSupervisor(labels)
+
+	for i := 0; i < 7; i++ {
+		s.HandleExit(context.Background(), crashed())
+	}
+
+	var delays []float64
+	for _, event := range sink.events {
+		delays = append(delays, event["delay"].(float64))
+	}
+	assert.Equal(t, []float64{10, 20, 40, 80, 160, 300, 300}, delays)
+
+	// A user stop drops the pending restart
+	s.HandleExit(context.Background(), watch.Exit{ServerID: "s1", ContainerID: "c1", UserInitiated: true})
+	s.mu.Lock()
+	assert.Empty(t, s.pending)
+	s.mu.Unlock()
+}
+
+func TestSupervisor_CancelDropsPendingRestart(t *testing.T) {
+	labels := map[string]string{docker.LabelRestartPolicy: `{"backoff":1}`}
+	s, dockerAPI, _, sink := newTestSupervisor(labels)
+
+	s.HandleExit(context.Background(), crashed())
+	require.Len(t, sink.events, 1)
+
+	// An operator stops the server while it waits out its backoff
+	s.Cancel("s1")
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.Zero(t, dockerAPI.startCount())
+	s.mu.Lock()
+	assert.Empty(t, s.pending)
+	s.mu.Unlock()
+}
+
+func TestSupervisor_IgnoresRemovedContainers(t *testing.T) {
+	s, _, _, sink := newTestSupervisor(nil)
+
+	s.HandleExit(context.Background(), crashed())
+
+	assert.Empty(t, sink.events)
+}
@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+var (
+	// ErrServerNotRunning is returned when a console command targets a stopped container
+	ErrServerNotRunning = errors.New("server is not running")
+	// ErrStdinUnavailable is returned for containers created without an open stdin
+	ErrStdinUnavailable = errors.New("server container was created without stdin; recreate it to enable the console")
+	// ErrMultilineCommand is returned for console commands containing a line break
+	ErrMultilineCommand = errors.New("console command must be a single line")
+)
+
+// consoleSession is a hijacked stdin attach stream for one container run
+type consoleSession struct {
+	resp      types.HijackedResponse
+	startedAt string
+	closeOnce sync.Once
+}
+
+// close releases the attach stream
+func (s *consoleSession) close() {
+	s.closeOnce.Do(s.resp.Close)
+}
+
+// console serialises attaching to and writing to one container's stdin
+type console struct {
+	mu      sync.Mutex
+	session *consoleSession
+}
+
+// dropLocked closes and forgets the attach stream. Callers must hold c.mu.
+func (c *console) dropLocked() {
+	if c.session != nil {
+		c.session.close()
+		c.session = nil
+	}
+}
+
+// consoles tracks the console of each container by container ID. The map
+// lock is only held for lookups, so a slow attach never blocks other servers.
+type consoles struct {
+	mu   sync.Mutex
+	byID map[string]*console
+}
+
+// get returns the console of a container, creating it if needed
+func (c *consoles) get(containerID string) *console {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	con, ok := c.byID[containerID]
+	if !ok {
+		if c.byID == nil {
+			c.byID = make(map[string]*console)
+		}
+		con = &console{}
+		c.byID[containerID] = con
+	}
+	return con
+}
+
+// remove forgets the console of a container and returns it, if any
+func (c *consoles) remove(containerID string) *console {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	con := c.byID[containerID]
+	delete(c.byID, containerID)
+	return con
+}
+
+// SendCommand writes a command line to the stdin of a running container.
+// The attach stream is kept open between commands and re-established
+// automatically when the container has been restarted.
+func (m *Manager) SendCommand(ctx context.Context, containerID, command string) error {
+	// A line break would let one request smuggle several commands
+	if strings.ContainsAny(command, "\r\n") {
+		return ErrMultilineCommand
+	}
+
+	info, err := m.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if info.State == nil || !info.State.Running {
+		return ErrServerNotRunning
+	}
+	if info.Config == nil || !info.Config.OpenStdin {
+		return ErrStdinUnavailable
+	}
+
+	con := m.consoles.get(info.ID)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+
+	session, err := m.consoleSessionLocked(con, info.ID, info.State.StartedAt)
+	if err != nil {
+		return err
+	}
+
+	line := command + "\n"
+	if _, err := io.WriteString(session.resp.Conn, line); err == nil {
+		return nil
+	}
+
+	// The stream may have died with the previous process; re-attach once
+	con.dropLocked()
+	session, err = m.consoleSessionLocked(con, info.ID, info.State.StartedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(session.resp.Conn, line); err != nil {
+		con.dropLocked()
+		return fmt.Errorf("failed to write to server console: %w", err)
+	}
+	return nil
+}
+
+// CloseConsole drops the attach stream of a container, if any
+func (m *Manager) CloseConsole(containerID string) {
+	if con := m.consoles.remove(containerID); con != nil {
+		con.mu.Lock()
+		con.dropLocked()
+		con.mu.Unlock()
+	}
+}
+
+// consoleSessionLocked returns the attach stream for the current container run,
+// attaching if needed. Callers must hold con.mu.
+func (m *Manager) consoleSessionLocked(con *console, containerID, startedAt string) (*consoleSession, error) {
+	if con.session != nil {
+		if con.session.startedAt == startedAt {
+			return con.session, nil
+		}
+		// The container restarted since we attached
+		con.dropLocked()
+	}
+
+	// The stream outlives the request, so it must not inherit its context
+	resp, err := m.client.ContainerAttach(context.Background(), containerID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to server console: %w", err)
+	}
+
+	session := &consoleSession{resp: resp, startedAt: startedAt}
+	con.session = session
+
+	// Nothing is read from a stdin-only attach; the read returns once the
+	// container exits, which tells us to forget the session
+	go func() {
+		if _, err := io.Copy(io.Discard, resp.Reader); err != nil {
+			log.Printf("Console stream for container %s closed: %v", containerID, err)
+		}
+		con.mu.Lock()
+		if con.session == session {
+			con.session = nil
+		}
+		con.mu.Unlock()
+		session.close()
+	}()
+
+	return session, nil
+}
+
+// closeConsoles drops all attach streams
+func (m *Manager) closeConsoles() {
+	m.consoles.mu.Lock()
+	all := m.consoles.byID
+	m.consoles.byID = nil
+	m.consoles.mu.Unlock()
+
+	for _, con := range all {
+		con.mu.Lock()
+		con.dropLocked()
+		con.mu.Unlock()
+	}
+}
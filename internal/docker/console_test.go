@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendCommand_ReusesAttachStream(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	ctx := context.Background()
+
+	require.NoError(t, m.SendCommand(ctx, "mc", "say hello"))
+	require.NoError(t, m.SendCommand(ctx, "mc", "list"))
+
+	assert.Equal(t, "say hello", d.nextLine(t))
+	assert.Equal(t, "list", d.nextLine(t))
+	assert.Equal(t, 1, d.attachCount())
+}
+
+func TestSendCommand_ReattachesAfterRestart(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	ctx := context.Background()
+
+	require.NoError(t, m.SendCommand(ctx, "mc", "before"))
+	assert.Equal(t, "before", d.nextLine(t))
+
+	d.mu.Lock()
+	d.startedAt = "2024-01-01T01:00:00Z"
+	d.mu.Unlock()
+
+	require.NoError(t, m.SendCommand(ctx, "mc", "after"))
+	assert.Equal(t, "after", d.nextLine(t))
+	assert.Equal(t, 2, d.attachCount())
+}
+
+func TestSendCommand_ReattachesAfterStreamCloses(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	ctx := context.Background()
+
+	require.NoError(t, m.SendCommand(ctx, "mc", "first"))
+	assert.Equal(t, "first", d.nextLine(t))
+
+	// The daemon ends the stream, as it does when the process exits
+	d.mu.Lock()
+	d.conns[0].Close()
+	d.mu.Unlock()
+	con := m.consoles.get("mc")
+	require.Eventually(t, func() bool {
+		con.mu.Lock()
+		defer con.mu.Unlock()
+		return con.session == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, m.SendCommand(ctx, "mc", "second"))
+	assert.Equal(t, "second", d.nextLine(t))
+	assert.Equal(t, 2, d.attachCount())
+}
+
+func TestSendCommand_RejectsLineBreaks(t *testing.T) {
+	d, m := newFakeDaemon(t)
+
+	for _, command := range []string{"say hi\nop attacker", "say hi\r", "\n"} {
+		assert.ErrorIs(t, m.SendCommand(context.Background(), "mc", command), ErrMultilineCommand, "%q", command)
+	}
+	assert.Equal(t, 0, d.attachCount())
+}
+
+func TestSendCommand_AttachDoesNotBlockOtherConsoles(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	gate := make(chan struct{})
+	d.mu.Lock()
+	d.attachGate = gate
+	d.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- m.SendCommand(context.Background(), "mc", "slow") }()
+
+	// Wait for the attach to be in flight, then touch another console
+	require.Eventually(t, func() bool {
+		con := m.consoles.get("mc")
+		if !con.mu.TryLock() {
+			return true
+		}
+		con.mu.Unlock()
+		return false
+	}, 2*time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		m.CloseConsole("other")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing another console waited for a pending attach")
+	}
+
+	close(gate)
+	require.NoError(t, <-done)
+	assert.Equal(t, "slow", d.nextLine(t))
+}
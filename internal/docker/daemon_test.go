@@ -0,0 +1,205 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDaemon serves the Docker API endpoints used to talk to and stop the
+// game container "mc"
+type fakeDaemon struct {
+	mu          sync.Mutex
+	startedAt   string
+	stopCommand string
+	stopSignal  string
+	attaches    int
+	conns       []net.Conn
+	signals     []string
+	lines       chan string
+	sizeRw      int64
+	// created records the create requests, rejecting storage-opt if
+	// rejectStorageOpt is set
+	created          []createRequest
+	rejectStorageOpt bool
+	// attachGate, when set, holds attach requests until it is closed
+	attachGate chan struct{}
+	// obeys lists the console lines and signals that make the game exit
+	obeys  map[string]bool
+	exited chan struct{}
+}
+
+// createRequest is the part of a container create request the tests inspect
+type createRequest struct {
+	Labels     map[string]string
+	HostConfig struct {
+		StorageOpt map[string]string
+	}
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func newFakeDaemon(t *testing.T) (*fakeDaemon, *Manager) {
+	t.Helper()
+	d := &fakeDaemon{
+		startedAt: "2024-01-01T00:00:00Z",
+		lines:     make(chan string, 16),
+		obeys:     map[string]bool{"SIGKILL": true},
+		exited:    make(chan struct{}),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		switch {
+		case r.Method == http.MethodGet && path == "/containers/mc/json":
+			d.inspect(w)
+		case r.Method == http.MethodPost && path == "/containers/create":
+			d.create(w, r)
+		case r.Method == http.MethodPost && path == "/containers/mc/attach":
+			d.attach(w)
+		case r.Method == http.MethodPost && path == "/containers/mc/kill":
+			d.kill(w, r.URL.Query().Get("signal"))
+		case r.Method == http.MethodPost && path == "/containers/mc/wait":
+			// Like dockerd, answer at once and send the result on exit
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-d.exited:
+				json.NewEncoder(w).Encode(map[string]interface{}{"StatusCode": 0})
+			case <-r.Context().Done():
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.47"))
+	require.NoError(t, err)
+	m := &Manager{client: cli}
+	t.Cleanup(func() { m.Close() })
+	return d, m
+}
+
+// running reports whether the game is still up
+func (d *fakeDaemon) running() bool {
+	select {
+	case <-d.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// handle makes the game exit if it obeys a console line or signal
+func (d *fakeDaemon) handle(input string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.obeys[input] && d.running() {
+		close(d.exited)
+	}
+}
+
+func (d *fakeDaemon) inspect(w http.ResponseWriter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Id":     "mc",
+		"SizeRw": d.sizeRw,
+		"State":  map[string]interface{}{"Running": d.running(), "StartedAt": d.startedAt},
+		"Config": map[string]interface{}{
+			"OpenStdin":  true,
+			"StopSignal": d.stopSignal,
+			"Labels":     map[string]string{LabelStopCommand: d.stopCommand},
+		},
+	})
+}
+
+func (d *fakeDaemon) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.created = append(d.created, req)
+	w.Header().Set("Content-Type", "application/json")
+	if d.rejectStorageOpt && req.HostConfig.StorageOpt != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"message": "--storage-opt is supported only for overlay over xfs with 'pquota' mount option"})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Id": "mc"})
+}
+
+func (d *fakeDaemon) kill(w http.ResponseWriter, signal string) {
+	d.mu.Lock()
+	d.signals = append(d.signals, signal)
+	d.mu.Unlock()
+	d.handle(signal)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// attach upgrades the request to a raw stream and forwards every stdin line
+func (d *fakeDaemon) attach(w http.ResponseWriter) {
+	d.mu.Lock()
+	gate := d.attachGate
+	d.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	d.attaches++
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+
+	buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	buf.Flush()
+
+	go func() {
+		scanner := bufio.NewScanner(buf)
+		for scanner.Scan() {
+			d.lines <- scanner.Text()
+			d.handle(scanner.Text())
+		}
+	}()
+}
+
+func (d *fakeDaemon) attachCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attaches
+}
+
+func (d *fakeDaemon) sentSignals() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.signals...)
+}
+
+func (d *fakeDaemon) nextLine(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-d.lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a console line")
+		return ""
+	}
+}
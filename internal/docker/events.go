@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// watchedActions are the container lifecycle events the agent follows
+var watchedActions = []events.Action{
+	events.ActionStart,
+	events.ActionKill,
+	events.ActionDie,
+	events.ActionOOM,
+	events.ActionStop,
+	events.ActionHealthStatus,
+}
+
+// ContainerEvents subscribes to lifecycle events of managed containers. When
+// since is set, events that happened after it are replayed first so a
+// resubscription does not miss any. The error channel receives a single
+// error when the stream ends.
+func (m *Manager) ContainerEvents(ctx context.Context, since time.Time) (<-chan events.Message, <-chan error) {
+	args := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("label", LabelManaged+"=true"),
+	)
+	for _, action := range watchedActions {
+		args.Add("event", string(action))
+	}
+
+	options := events.ListOptions{Filters: args}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	return m.client.Events(ctx, options)
+}
@@ -0,0 +1,237 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// Image pull policies
+const (
+	PullIfMissing = "missing" // pull only when the image is not on the node (default)
+	PullAlways    = "always"  // pull before every create to pick up updated tags
+	PullNever     = "never"   // use local images only
+)
+
+// pullProgressInterval throttles progress callbacks during a pull
+const pullProgressInterval = 500 * time.Millisecond
+
+// ErrImagePull is returned when an image cannot be made available
+var ErrImagePull = errors.New("image pull failed")
+
+// RegistryAuth holds credentials for a private image registry
+type RegistryAuth struct {
+	Server   string `json:"server"` // registry host, e.g. ghcr.io; empty matches any registry
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ImagePullProgress summarises an image pull across all layers
+type ImagePullProgress struct {
+	Image     string  `json:"image"`
+	Status    string  `json:"status"`
+	Layers    int     `json:"layers"`
+	Completed int     `json:"completed"`
+	Current   int64   `json:"current"` // bytes downloaded
+	Total     int64   `json:"total"`   // bytes to download, as far as known
+	Percent   float64 `json:"percent"`
+	Done      bool    `json:"done"`
+}
+
+// pullTracker aggregates per-layer progress of a pull
+type pullTracker struct {
+	progress   ImagePullProgress
+	layerBytes map[string][2]int64 // layer ID -> current, total
+	layersDone map[string]bool
+}
+
+// pullMessage is one line of the Docker image pull JSON stream
+type pullMessage struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Progress struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// SetRegistryAuth configures default credentials for private registries,
+// used when a create request does not carry its own
+func (m *Manager) SetRegistryAuth(auth RegistryAuth) {
+	m.registryAuth = auth
+}
+
+// EnsureImage makes the image available according to policy, pulling it
+// with the given (or default) credentials and reporting progress to fn
+func (m *Manager) EnsureImage(ctx context.Context, ref, policy string, auth *RegistryAuth, fn func(ImagePullProgress)) error {
+	switch policy {
+	case "", PullIfMissing:
+		if _, err := m.client.ImageInspect(ctx, ref); err == nil {
+			return nil
+		} else if !IsNotFound(err) {
+			return fmt.Errorf("failed to inspect image %s: %w", ref, err)
+		}
+	case PullAlways:
+	case PullNever:
+		if _, err := m.client.ImageInspect(ctx, ref); err != nil {
+			return fmt.Errorf("%w: image %s is not present and pull policy is never", ErrImagePull, ref)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown pull policy %q", policy)
+	}
+
+	return m.pullImage(ctx, ref, auth, fn)
+}
+
+// pullImage pulls an image and decodes its progress stream
+func (m *Manager) pullImage(ctx context.Context, ref string, auth *RegistryAuth, fn func(ImagePullProgress)) error {
+	options := image.PullOptions{}
+	if creds := m.credentialsFor(ref, auth); creds != nil {
+		encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      creds.Username,
+			Password:      creds.Password,
+			ServerAddress: creds.Server,
+		})
+		if err != nil {
+			return err
+		}
+		options.RegistryAuth = encoded
+	}
+
+	log.Printf("Pulling image %s", ref)
+	stream, err := m.client.ImagePull(ctx, ref, options)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrImagePull, ref, err)
+	}
+	defer stream.Close()
+
+	if err := decodePullStream(stream, ref, fn); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrImagePull, ref, err)
+	}
+	log.Printf("Pulled image %s", ref)
+	return nil
+}
+
+// decodePullStream reads a pull stream to the end, calling fn with
+// throttled aggregate progress and once more when the pull finishes
+func decodePullStream(stream io.Reader, ref string, fn func(ImagePullProgress)) error {
+	tracker := &pullTracker{
+		progress:   ImagePullProgress{Image: ref},
+		layerBytes: make(map[string][2]int64),
+		layersDone: make(map[string]bool),
+	}
+	var lastReport time.Time
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var msg pullMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if msg.ErrorDetail.Message != "" {
+			return errors.New(msg.ErrorDetail.Message)
+		}
+
+		tracker.update(msg)
+		if fn != nil && time.Since(lastReport) >= pullProgressInterval {
+			lastReport = time.Now()
+			fn(tracker.progress)
+		}
+	}
+
+	tracker.progress.Done = true
+	tracker.progress.Status = "Pull complete"
+	tracker.progress.Percent = 100
+	if fn != nil {
+		fn(tracker.progress)
+	}
+	return nil
+}
+
+// update folds one stream message into the aggregate progress
+func (t *pullTracker) update(msg pullMessage) {
+	p := &t.progress
+	p.Status = msg.Status
+	if msg.ID == "" {
+		return
+	}
+
+	switch msg.Status {
+	case "Pulling fs layer", "Waiting":
+		t.layerBytes[msg.ID] = [2]int64{}
+	case "Downloading":
+		t.layerBytes[msg.ID] = [2]int64{msg.Progress.Current, msg.Progress.Total}
+	case "Download complete", "Pull complete", "Already exists":
+		bytes := t.layerBytes[msg.ID]
+		bytes[0] = bytes[1]
+		t.layerBytes[msg.ID] = bytes
+		t.layersDone[msg.ID] = true
+	default:
+		return
+	}
+
+	p.Layers = len(t.layerBytes)
+	p.Completed = len(t.layersDone)
+	p.Current, p.Total = 0, 0
+	for _, bytes := range t.layerBytes {
+		p.Current += bytes[0]
+		p.Total += bytes[1]
+	}
+	if p.Total > 0 {
+		p.Percent = float64(p.Current) / float64(p.Total) * 100
+	}
+}
+
+// credentialsFor picks the credentials for an image: the request's own,
+// otherwise the agent defaults if they cover the image's registry
+func (m *Manager) credentialsFor(ref string, auth *RegistryAuth) *RegistryAuth {
+	if auth != nil && auth.Username != "" {
+		return auth
+	}
+	if m.registryAuth.Username == "" {
+		return nil
+	}
+	if m.registryAuth.Server == "" || registryHost(m.registryAuth.Server) == imageRegistry(ref) {
+		return &m.registryAuth
+	}
+	return nil
+}
+
+// imageRegistry returns the registry host an image reference points at
+func imageRegistry(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	return registryHost(reference.Domain(named))
+}
+
+// registryHost normalises a registry address to a bare host
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
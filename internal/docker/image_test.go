@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePullStream(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"status":"Pulling from library/minecraft","id":"latest"}`,
+		`{"status":"Pulling fs layer","id":"a1"}`,
+		`{"status":"Already exists","id":"b2"}`,
+		`{"status":"Downloading","progressDetail":{"current":512,"total":1024},"id":"a1"}`,
+		`{"status":"Download complete","id":"a1"}`,
+		`{"status":"Pull complete","id":"a1"}`,
+		`{"status":"Digest: sha256:abc"}`,
+		`{"status":"Status: Downloaded newer image for minecraft:latest"}`,
+	}, "\n")
+
+	var updates []ImagePullProgress
+	err := decodePullStream(strings.NewReader(stream), "minecraft:latest", func(p ImagePullProgress) {
+		updates = append(updates, p)
+	})
+	require.NoError(t, err)
+
+	require.NotEmpty(t, updates)
+	final := updates[len(updates)-1]
+	assert.True(t, final.Done)
+	assert.Equal(t, "minecraft:latest", final.Image)
+	assert.Equal(t, 2, final.Layers)
+	assert.Equal(t, 2, final.Completed)
+	assert.Equal(t, int64(1024), final.Current)
+	assert.Equal(t, int64(1024), final.Total)
+	assert.Equal(t, float64(100), final.Percent)
+}
+
+func TestDecodePullStream_Error(t *testing.T) {
+	stream := `{"status":"Pulling fs layer","id":"a1"}
+{"errorDetail":{"message":"unauthorized: authentication required"},"error":"unauthorized: authentication required"}`
+
+	err := decodePullStream(strings.NewReader(stream), "ghcr.io/acme/private:1", nil)
+	assert.EqualError(t, err, "unauthorized: authentication required")
+}
+
+func TestCredentialsFor(t *testing.T) {
+	m := &Manager{}
+	assert.Nil(t, m.credentialsFor("minecraft:latest", nil), "no credentials configured")
+
+	m.SetRegistryAuth(RegistryAuth{Server: "https://ghcr.io", Username: "agent", Password: "secret"})
+	assert.Equal(t, "agent", m.credentialsFor("ghcr.io/acme/game:1", nil).Username)
+	assert.Nil(t, m.credentialsFor("minecraft:latest", nil), "defaults are scoped to their registry")
+
+	override := &RegistryAuth{Username: "panel", Password: "token"}
+	assert.Equal(t, override, m.credentialsFor("minecraft:latest", override))
+
+	m.SetRegistryAuth(RegistryAuth{Server: "index.docker.io", Username: "hub"})
+	assert.Equal(t, "hub", m.credentialsFor("itzg/minecraft-server", nil).Username)
+}
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// LabelDiskLimit records the disk limit in bytes of a container, so the
+// agent-side quota monitor can police it across the writable layer and the
+// data directory, which storage-opt does not cover
+const LabelDiskLimit = "ctrl-alt-play.disk-limit"
+
+// ErrInvalidLimits is returned when resource limits cannot be applied
+var ErrInvalidLimits = errors.New("invalid resource limits")
+
+// cpusetPattern matches cpuset lists such as "0-3" or "0,2,4-5"
+var cpusetPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// resources translates resource limits into Docker container resources.
+// Swap is the amount allowed on top of Memory: zero disables swap and -1
+// leaves it unlimited.
+func (l ResourceLimits) resources() (container.Resources, error) {
+	var res container.Resources
+
+	switch {
+	case l.Memory < 0:
+		return res, fmt.Errorf("%w: memory must not be negative", ErrInvalidLimits)
+	case l.Swap < -1:
+		return res, fmt.Errorf("%w: swap must be -1 (unlimited) or more", ErrInvalidLimits)
+	case l.Swap != 0 && l.Memory == 0:
+		return res, fmt.Errorf("%w: swap requires a memory limit", ErrInvalidLimits)
+	case l.CPUs < 0:
+		return res, fmt.Errorf("%w: cpu shares must not be negative", ErrInvalidLimits)
+	case l.CPULimit < 0:
+		return res, fmt.Errorf("%w: cpuLimit must not be negative", ErrInvalidLimits)
+	case l.IO != 0 && (l.IO < 10 || l.IO > 1000):
+		return res, fmt.Errorf("%w: io weight must be between 10 and 1000", ErrInvalidLimits)
+	case l.CPUSet != "" && !cpusetPattern.MatchString(l.CPUSet):
+		return res, fmt.Errorf("%w: cpuset %q is not a CPU list", ErrInvalidLimits, l.CPUSet)
+	case l.Disk < 0:
+		return res, fmt.Errorf("%w: disk must not be negative", ErrInvalidLimits)
+	}
+
+	res.Memory = l.Memory
+	if l.Memory > 0 {
+		switch {
+		case l.Swap == -1:
+			res.MemorySwap = -1
+		default:
+			res.MemorySwap = l.Memory + l.Swap
+		}
+	}
+	res.CPUShares = l.CPUs
+	res.NanoCPUs = int64(l.CPULimit * 1e7) // percent of one core to billionths of a CPU
+	res.CpusetCpus = l.CPUSet
+	res.BlkioWeight = uint16(l.IO)
+
+	return res, nil
+}
+
+// isStorageOptError reports whether the daemon rejected a storage-opt size
+// because the storage driver or backing filesystem cannot enforce it
+func isStorageOptError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "storage-opt") || strings.Contains(msg, "storage opt") || strings.Contains(msg, "storageopt")
+}
+
+// createWithDiskLimit creates a container limited to limit bytes of disk.
+// The writable layer is capped through storage-opt where the storage driver
+// supports it; the label lets the quota monitor police the data directory
+// in any case, and the writable layer too where storage-opt is missing.
+func (m *Manager) createWithDiskLimit(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, name string, limit int64) (string, error) {
+	if limit > 0 {
+		config.Labels[LabelDiskLimit] = strconv.FormatInt(limit, 10)
+	}
+
+	if limit > 0 && !m.storageOptUnsupported.Load() {
+		hostConfig.StorageOpt = map[string]string{"size": strconv.FormatInt(limit, 10)}
+		id, err := m.CreateContainer(ctx, config, hostConfig, name)
+		if err == nil || !isStorageOptError(err) {
+			return id, err
+		}
+		m.storageOptUnsupported.Store(true)
+		hostConfig.StorageOpt = nil
+	}
+	return m.CreateContainer(ctx, config, hostConfig, name)
+}
+
+// DiskUsage returns the bytes a server uses on disk: what its container has
+// written to its writable layer plus the contents of its data directory
+func (m *Manager) DiskUsage(ctx context.Context, serverID, containerID string) (int64, error) {
+	info, _, err := m.client.ContainerInspectWithRaw(ctx, containerID, true)
+	if err != nil {
+		return 0, err
+	}
+	var usage int64
+	if info.SizeRw != nil {
+		usage = *info.SizeRw
+	}
+
+	data, err := m.dataDirUsage(serverID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure data directory: %w", err)
+	}
+	return usage + data, nil
+}
@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimits_Resources(t *testing.T) {
+	res, err := ResourceLimits{
+		Memory:   2 << 30,
+		Swap:     512 << 20,
+		CPUs:     1024,
+		CPULimit: 150,
+		CPUSet:   "0-1,4",
+		IO:       500,
+	}.resources()
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(2<<30), res.Memory)
+	assert.Equal(t, int64(2<<30+512<<20), res.MemorySwap)
+	assert.Equal(t, int64(1024), res.CPUShares)
+	assert.Equal(t, int64(1_500_000_000), res.NanoCPUs)
+	assert.Equal(t, "0-1,4", res.CpusetCpus)
+	assert.Equal(t, uint16(500), res.BlkioWeight)
+}
+
+func TestResourceLimits_Swap(t *testing.T) {
+	res, err := ResourceLimits{Memory: 1 << 30}.resources()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), res.MemorySwap, "swap is disabled by default")
+
+	res, err = ResourceLimits{Memory: 1 << 30, Swap: -1}.resources()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), res.MemorySwap)
+
+	res, err = ResourceLimits{}.resources()
+	require.NoError(t, err)
+	assert.Zero(t, res.MemorySwap, "no memory limit leaves swap alone")
+}
+
+func TestResourceLimits_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ResourceLimits
+	}{
+		{"negative memory", ResourceLimits{Memory: -1}},
+		{"swap without memory", ResourceLimits{Swap: 1 << 20}},
+		{"swap below -1", ResourceLimits{Memory: 1 << 30, Swap: -2}},
+		{"io weight too low", ResourceLimits{IO: 5}},
+		{"io weight too high", ResourceLimits{IO: 2000}},
+		{"bad cpuset", ResourceLimits{CPUSet: "0-1;3"}},
+		{"negative cpu limit", ResourceLimits{CPULimit: -50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.limits.resources()
+			assert.True(t, errors.Is(err, ErrInvalidLimits), "got %v", err)
+		})
+	}
+}
+
+func TestIsStorageOptError(t *testing.T) {
+	assert.True(t, isStorageOptError(errors.New("Error response from daemon: --storage-opt is supported only for overlay over xfs with 'pquota' mount option")))
+	assert.False(t, isStorageOptError(errors.New("No such image: minecraft:latest")))
+}
+
+func TestCreateWithDiskLimit_LabelsWithStorageOpt(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	base := t.TempDir()
+	m.SetDataVolume(DataVolume{HostDir: base})
+	d.sizeRw = 100
+
+	config := &container.Config{Labels: map[string]string{LabelServerID: "mc"}}
+	_, err := m.createWithDiskLimit(context.Background(), config, &container.HostConfig{}, "mc", 1000)
+	require.NoError(t, err)
+
+	// storage-opt took, and the label is still there for the data directory
+	require.Len(t, d.created, 1)
+	assert.Equal(t, map[string]string{"size": "1000"}, d.created[0].HostConfig.StorageOpt)
+	assert.Equal(t, "1000", d.created[0].Labels[LabelDiskLimit])
+
+	// The bind-mounted data directory pushes the server over its limit
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "mc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "mc", "world.dat"), make([]byte, 1500), 0o644))
+	usage, err := m.DiskUsage(context.Background(), "mc", "mc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600), usage)
+}
+
+func TestCreateWithDiskLimit_FallsBackWithoutStorageOpt(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	d.rejectStorageOpt = true
+
+	config := &container.Config{Labels: map[string]string{}}
+	_, err := m.createWithDiskLimit(context.Background(), config, &container.HostConfig{}, "mc", 1000)
+	require.NoError(t, err)
+
+	require.Len(t, d.created, 2)
+	assert.Nil(t, d.created[1].HostConfig.StorageOpt)
+	assert.Equal(t, "1000", d.created[1].Labels[LabelDiskLimit])
+	assert.True(t, m.storageOptUnsupported.Load())
+}
@@ -1,17 +1,44 @@
 package docker
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
+const (
+	// LabelManaged marks containers created by the agent
+	LabelManaged = "ctrl-alt-play.managed"
+	// LabelServerID records the panel server ID a container belongs to
+	LabelServerID = "ctrl-alt-play.server-id"
+)
+
+// ContainerName returns the container name used for a server
+func ContainerName(serverID string) string {
+	return "ctrl-alt-play-" + serverID
+}
+
 // Manager handles Docker operations for game servers
 type Manager struct {
-	client *client.Client
+	client   *client.Client
+	consoles consoles
+
+	// storageOptUnsupported is set once the daemon rejects disk size limits
+	storageOptUnsupported atomic.Bool
+
+	volume       DataVolume
+	registryAuth RegistryAuth
 }
 
 // NewManager creates a new Docker manager
@@ -40,12 +67,6 @@ func (m *Manager) StartContainer(ctx context.Context, containerID string) error
 	return m.client.ContainerStart(ctx, containerID, container.StartOptions{})
 }
 
-// StopContainer stops a container
-func (m *Manager) StopContainer(ctx context.Context, containerID string) error {
-	timeout := 30 // 30 seconds timeout
-	return m.client.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout})
-}
-
 // RemoveContainer removes a container
 func (m *Manager) RemoveContainer(ctx context.Context, containerID string) error {
 	return m.client.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true})
@@ -70,13 +91,91 @@ func (m *Manager) GetContainerLogs(ctx context.Context, containerID string) (io.
 	return m.client.ContainerLogs(ctx, containerID, options)
 }
 
+// TailLogs returns the last lines of a container's stdout and stderr, each
+// prefixed with its timestamp. It works on stopped containers too.
+func (m *Manager) TailLogs(ctx context.Context, containerID string, lines int) ([]string, error) {
+	rc, err := m.client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Tail:       strconv.Itoa(lines),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&buf, &buf, rc); err != nil {
+		return nil, err
+	}
+	text := strings.TrimRight(buf.String(), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// FollowLogs streams timestamped stdout/stderr of a container as a multiplexed
+// stream (see stdcopy). A zero since starts from the last tail lines.
+func (m *Manager) FollowLogs(ctx context.Context, containerID string, since time.Time, tail string) (io.ReadCloser, error) {
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+		Tail:       tail,
+	}
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	return m.client.ContainerLogs(ctx, containerID, options)
+}
+
 // ListContainers lists all containers
 func (m *Manager) ListContainers(ctx context.Context) ([]container.Summary, error) {
 	return m.client.ContainerList(ctx, container.ListOptions{All: true})
 }
 
+// ListManagedContainers lists all containers created by the agent
+func (m *Manager) ListManagedContainers(ctx context.Context) ([]container.Summary, error) {
+	return m.client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
+}
+
+// InspectContainer returns low-level information about a container
+func (m *Manager) InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	return m.client.ContainerInspect(ctx, containerID)
+}
+
+// FindServerContainer returns the ID of the container labelled with serverID
+func (m *Manager) FindServerContainer(ctx context.Context, serverID string) (string, error) {
+	containers, err := m.client.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", LabelManaged+"=true"),
+			filters.Arg("label", LabelServerID+"="+serverID),
+		),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", fmt.Errorf("no container found for server %s", serverID)
+	}
+	return containers[0].ID, nil
+}
+
+// IsNotFound reports whether err means the container or image does not exist
+func IsNotFound(err error) bool {
+	return client.IsErrNotFound(err)
+}
+
 // Close closes the Docker client connection
 func (m *Manager) Close() error {
+	m.closeConsoles()
 	if m.client != nil {
 		return m.client.Close()
 	}
@@ -91,20 +190,42 @@ type ServerConfig struct {
 	Environment map[string]string `json:"environment"`
 	Limits      ResourceLimits    `json:"limits"`
 	Ports       []PortMapping     `json:"ports"`
+
+	StopCommand   string         `json:"stopCommand"`             // console command that shuts the game down gracefully
+	RestartPolicy *RestartPolicy `json:"restartPolicy,omitempty"` // crash restarts, agent defaults if nil
+	DonePattern   string         `json:"donePattern,omitempty"`   // console regex marking the server as ready
+	ReadyTimeout  int            `json:"readyTimeout,omitempty"`  // seconds to wait for DonePattern
+
+	PullPolicy string `json:"pullPolicy"` // missing (default), always or never
+
+	// RegistryAuth overrides the agent's registry credentials for the pull.
+	// It is never serialized, so credentials do not reach the registry file.
+	RegistryAuth *RegistryAuth `json:"-"`
+
+	// PullProgress, if set, receives progress while the image is pulled
+	PullProgress func(ImagePullProgress) `json:"-"`
 }
 
 // ResourceLimits defines resource constraints for containers
 type ResourceLimits struct {
-	Memory int64 `json:"memory"` // in bytes
-	CPUs   int64 `json:"cpu"`    // CPU shares
-	Disk   int64 `json:"disk"`   // disk space in bytes
+	Memory   int64   `json:"memory"`   // in bytes
+	Swap     int64   `json:"swap"`     // swap on top of memory in bytes, -1 unlimited
+	CPUs     int64   `json:"cpu"`      // CPU shares
+	CPULimit float64 `json:"cpuLimit"` // hard cap in percent of one core
+	CPUSet   string  `json:"cpuset"`   // CPUs the server is pinned to, e.g. "0-3"
+	IO       int64   `json:"io"`       // block IO weight (10-1000)
+	Disk     int64   `json:"disk"`     // disk space in bytes
 }
 
-// PortMapping defines port forwarding for containers
+// PortMapping defines port forwarding for containers. Setting InternalEnd
+// (and optionally ExternalEnd) maps a contiguous range of ports.
 type PortMapping struct {
-	Internal int    `json:"internal"`
-	External int    `json:"external"`
-	Protocol string `json:"protocol"` // tcp/udp
+	Internal    int    `json:"internal"`
+	External    int    `json:"external"`
+	Protocol    string `json:"protocol"`              // tcp/udp
+	HostIP      string `json:"hostIp,omitempty"`      // bind address, all interfaces if empty
+	InternalEnd int    `json:"internalEnd,omitempty"` // last container port of a range
+	ExternalEnd int    `json:"externalEnd,omitempty"` // last host port of a range
 }
 
 // CreateGameServer creates a game server container based on configuration
@@ -114,29 +235,84 @@ func (m *Manager) CreateGameServer(ctx context.Context, config *ServerConfig) (s
 		Image: config.Image,
 		Env:   make([]string, 0, len(config.Environment)),
 		Cmd:   []string{"/bin/sh", "-c", config.Startup},
+		// Keep stdin open so panel commands can be written to the game console
+		OpenStdin:   true,
+		AttachStdin: true,
+		StdinOnce:   false,
 		Labels: map[string]string{
-			"ctrl-alt-play.server-id": config.ServerID,
-			"ctrl-alt-play.managed":   "true",
+			LabelServerID: config.ServerID,
+			LabelManaged:  "true",
 		},
 	}
 
+	if config.StopCommand != "" {
+		containerConfig.Labels[LabelStopCommand] = config.StopCommand
+	}
+	if config.RestartPolicy != nil {
+		if err := config.RestartPolicy.Validate(); err != nil {
+			return "", err
+		}
+		containerConfig.Labels[LabelRestartPolicy] = config.RestartPolicy.label()
+	}
+	readiness, err := readyLabels(config.DonePattern, config.ReadyTimeout)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range readiness {
+		containerConfig.Labels[key] = value
+	}
+
 	// Add environment variables
 	for key, value := range config.Environment {
 		containerConfig.Env = append(containerConfig.Env, key+"="+value)
 	}
 
-	// Prepare host configuration with resource limits
+	// Translate port mappings and make sure no other server holds the host ports
+	exposedPorts, portBindings, requested, err := buildPortBindings(config.Ports)
+	if err != nil {
+		return "", err
+	}
+	existing, err := m.boundPorts(ctx, config.ServerID)
+	if err != nil {
+		return "", err
+	}
+	if err := findPortConflict(requested, existing); err != nil {
+		return "", err
+	}
+	containerConfig.ExposedPorts = exposedPorts
+
+	resources, err := config.Limits.resources()
+	if err != nil {
+		return "", err
+	}
+
+	// Persist server files in the per-server data directory
+	mounts, err := m.dataMount(config.ServerID)
+	if err != nil {
+		return "", err
+	}
+	if len(mounts) > 0 {
+		containerConfig.WorkingDir = m.volume.ContainerPath
+	}
+
+	// Prepare host configuration with resource limits. Docker does not
+	// restart the container; the agent does, according to RestartPolicy.
 	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
-		Resources: container.Resources{
-			Memory: config.Limits.Memory,
-		},
+		Mounts:        mounts,
+		PortBindings:  portBindings,
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled},
+		Resources:     resources,
+	}
+
+	// Pull the image only once the request is known to be valid
+	if err := m.EnsureImage(ctx, config.Image, config.PullPolicy, config.RegistryAuth, config.PullProgress); err != nil {
+		return "", err
 	}
 
 	// Create container name
-	containerName := "ctrl-alt-play-" + config.ServerID
+	containerName := ContainerName(config.ServerID)
 
 	log.Printf("Creating container %s with image %s", containerName, config.Image)
 
-	return m.CreateContainer(ctx, containerConfig, hostConfig, containerName)
+	return m.createWithDiskLimit(ctx, containerConfig, hostConfig, containerName, config.Limits.Disk)
 }
@@ -0,0 +1,187 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// PortMappingError is returned when a port mapping is malformed
+type PortMappingError struct {
+	Mapping PortMapping
+	Reason  string
+}
+
+func (e *PortMappingError) Error() string {
+	return fmt.Sprintf("invalid port mapping %d->%d/%s: %s", e.Mapping.External, e.Mapping.Internal, e.Mapping.Protocol, e.Reason)
+}
+
+// PortConflictError is returned when a requested host port is already bound
+type PortConflictError struct {
+	HostIP   string `json:"hostIp,omitempty"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	ServerID string `json:"serverId,omitempty"` // server already holding the port, empty for the request itself
+}
+
+func (e *PortConflictError) Error() string {
+	host := e.HostIP
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	if e.ServerID == "" {
+		return fmt.Sprintf("host port %s:%d/%s is mapped more than once", host, e.Port, e.Protocol)
+	}
+	return fmt.Sprintf("host port %s:%d/%s is already bound by server %s", host, e.Port, e.Protocol, e.ServerID)
+}
+
+// hostBinding is a single host port a container binds
+type hostBinding struct {
+	hostIP   string
+	port     int
+	protocol string
+}
+
+// overlaps reports whether two bindings would compete for the same socket
+func (b hostBinding) overlaps(other hostBinding) bool {
+	if b.port != other.port || b.protocol != other.protocol {
+		return false
+	}
+	return isWildcardIP(b.hostIP) || isWildcardIP(other.hostIP) || b.hostIP == other.hostIP
+}
+
+// isWildcardIP reports whether a host IP binds every interface
+func isWildcardIP(ip string) bool {
+	return ip == "" || ip == "0.0.0.0" || ip == "::"
+}
+
+// buildPortBindings translates port mappings into Docker exposed ports and host bindings
+func buildPortBindings(mappings []PortMapping) (nat.PortSet, nat.PortMap, []hostBinding, error) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+	var hostBindings []hostBinding
+
+	for _, mapping := range mappings {
+		protocol := mapping.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "protocol must be tcp or udp"}
+		}
+		if mapping.HostIP != "" && net.ParseIP(mapping.HostIP) == nil {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "hostIp is not a valid IP address"}
+		}
+		if !validPort(mapping.Internal) || !validPort(mapping.External) {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "ports must be between 1 and 65535"}
+		}
+
+		internalEnd := mapping.Internal
+		if mapping.InternalEnd != 0 {
+			internalEnd = mapping.InternalEnd
+		}
+		if internalEnd < mapping.Internal || !validPort(internalEnd) {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "internalEnd must be a port not below internal"}
+		}
+
+		count := internalEnd - mapping.Internal
+		externalEnd := mapping.External + count
+		if mapping.ExternalEnd != 0 && mapping.ExternalEnd != externalEnd {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "internal and external ranges must be the same length"}
+		}
+		if !validPort(externalEnd) {
+			return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: "external range exceeds 65535"}
+		}
+
+		for offset := 0; offset <= count; offset++ {
+			containerPort, err := nat.NewPort(protocol, strconv.Itoa(mapping.Internal+offset))
+			if err != nil {
+				return nil, nil, nil, &PortMappingError{Mapping: mapping, Reason: err.Error()}
+			}
+
+			hostPort := mapping.External + offset
+			exposed[containerPort] = struct{}{}
+			bindings[containerPort] = append(bindings[containerPort], nat.PortBinding{
+				HostIP:   mapping.HostIP,
+				HostPort: strconv.Itoa(hostPort),
+			})
+			hostBindings = append(hostBindings, hostBinding{hostIP: mapping.HostIP, port: hostPort, protocol: protocol})
+		}
+	}
+
+	return exposed, bindings, hostBindings, nil
+}
+
+// validPort reports whether p is a usable TCP/UDP port number
+func validPort(p int) bool {
+	return p > 0 && p <= 65535
+}
+
+// bindingsFromPortMap flattens Docker host bindings
+func bindingsFromPortMap(portMap nat.PortMap) []hostBinding {
+	var out []hostBinding
+	for containerPort, bindings := range portMap {
+		for _, binding := range bindings {
+			start, end, err := nat.ParsePortRange(binding.HostPort)
+			if err != nil {
+				continue
+			}
+			for port := start; port <= end; port++ {
+				out = append(out, hostBinding{hostIP: binding.HostIP, port: int(port), protocol: containerPort.Proto()})
+			}
+		}
+	}
+	return out
+}
+
+// findPortConflict checks requested bindings against each other and against
+// bindings already held by other servers
+func findPortConflict(requested []hostBinding, existing map[string][]hostBinding) error {
+	for i, binding := range requested {
+		for _, other := range requested[:i] {
+			if binding.overlaps(other) {
+				return &PortConflictError{HostIP: binding.hostIP, Port: binding.port, Protocol: binding.protocol}
+			}
+		}
+		for serverID, held := range existing {
+			for _, other := range held {
+				if binding.overlaps(other) {
+					return &PortConflictError{HostIP: binding.hostIP, Port: binding.port, Protocol: binding.protocol, ServerID: serverID}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// boundPorts returns the host bindings of every managed container except serverID's
+func (m *Manager) boundPorts(ctx context.Context, serverID string) (map[string][]hostBinding, error) {
+	containers, err := m.ListManagedContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers for port check: %w", err)
+	}
+
+	existing := make(map[string][]hostBinding)
+	for _, c := range containers {
+		owner := c.Labels[LabelServerID]
+		if owner == serverID {
+			continue
+		}
+
+		info, err := m.client.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to inspect container %s for port check: %w", c.ID, err)
+		}
+		if info.HostConfig == nil {
+			continue
+		}
+		existing[owner] = append(existing[owner], bindingsFromPortMap(info.HostConfig.PortBindings)...)
+	}
+	return existing, nil
+}
@@ -0,0 +1,111 @@
+package docker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPortBindings(t *testing.T) {
+	exposed, bindings, requested, err := buildPortBindings([]PortMapping{
+		{Internal: 25565, External: 25565},
+		{Internal: 19132, External: 19133, Protocol: "udp", HostIP: "10.0.0.5"},
+		{Internal: 27015, External: 28015, Protocol: "udp", InternalEnd: 27017},
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, exposed, 5)
+	assert.Contains(t, exposed, nat.Port("25565/tcp"))
+	assert.Equal(t, []nat.PortBinding{{HostPort: "25565"}}, bindings["25565/tcp"])
+	assert.Equal(t, []nat.PortBinding{{HostIP: "10.0.0.5", HostPort: "19133"}}, bindings["19132/udp"])
+	assert.Equal(t, []nat.PortBinding{{HostPort: "28017"}}, bindings["27017/udp"])
+	assert.Len(t, requested, 5)
+}
+
+func TestBuildPortBindings_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping PortMapping
+	}{
+		{"zero internal", PortMapping{Internal: 0, External: 25565}},
+		{"external out of range", PortMapping{Internal: 25565, External: 70000}},
+		{"bad protocol", PortMapping{Internal: 25565, External: 25565, Protocol: "sctp"}},
+		{"bad host ip", PortMapping{Internal: 25565, External: 25565, HostIP: "not-an-ip"}},
+		{"reversed range", PortMapping{Internal: 27015, External: 27015, InternalEnd: 27010}},
+		{"range length mismatch", PortMapping{Internal: 27015, External: 27015, InternalEnd: 27017, ExternalEnd: 27020}},
+		{"range past 65535", PortMapping{Internal: 100, External: 65535, InternalEnd: 101}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := buildPortBindings([]PortMapping{tt.mapping})
+			var mappingErr *PortMappingError
+			assert.True(t, errors.As(err, &mappingErr), "expected PortMappingError, got %v", err)
+		})
+	}
+}
+
+func TestFindPortConflict(t *testing.T) {
+	existing := map[string][]hostBinding{
+		"other": bindingsFromPortMap(nat.PortMap{
+			"25565/tcp": {{HostPort: "25565"}},
+			"19132/udp": {{HostIP: "10.0.0.5", HostPort: "19132"}},
+		}),
+	}
+
+	tests := []struct {
+		name     string
+		mappings []PortMapping
+		conflict *PortConflictError
+	}{
+		{
+			name:     "free port",
+			mappings: []PortMapping{{Internal: 25565, External: 25566}},
+		},
+		{
+			name:     "same port different protocol",
+			mappings: []PortMapping{{Internal: 25565, External: 25565, Protocol: "udp"}},
+		},
+		{
+			name:     "wildcard binding held by another server",
+			mappings: []PortMapping{{Internal: 25565, External: 25565, HostIP: "10.0.0.7"}},
+			conflict: &PortConflictError{HostIP: "10.0.0.7", Port: 25565, Protocol: "tcp", ServerID: "other"},
+		},
+		{
+			name:     "different host ip",
+			mappings: []PortMapping{{Internal: 19132, External: 19132, Protocol: "udp", HostIP: "10.0.0.6"}},
+		},
+		{
+			name:     "wildcard request over specific binding",
+			mappings: []PortMapping{{Internal: 19130, External: 19130, Protocol: "udp", InternalEnd: 19135}},
+			conflict: &PortConflictError{Port: 19132, Protocol: "udp", ServerID: "other"},
+		},
+		{
+			name: "duplicate within request",
+			mappings: []PortMapping{
+				{Internal: 8080, External: 8080},
+				{Internal: 8081, External: 8080},
+			},
+			conflict: &PortConflictError{Port: 8080, Protocol: "tcp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, requested, err := buildPortBindings(tt.mappings)
+			require.NoError(t, err)
+
+			err = findPortConflict(requested, existing)
+			if tt.conflict == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var conflict *PortConflictError
+			require.True(t, errors.As(err, &conflict), "expected PortConflictError, got %v", err)
+			assert.Equal(t, tt.conflict, conflict)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// LabelDonePattern records the console pattern that marks a server as ready
+	LabelDonePattern = "ctrl-alt-play.done-pattern"
+	// LabelReadyTimeout records how long to wait for the done pattern, in seconds
+	LabelReadyTimeout = "ctrl-alt-play.ready-timeout"
+)
+
+// ErrInvalidDonePattern is returned for done patterns that do not compile
+var ErrInvalidDonePattern = errors.New("invalid done pattern")
+
+// readyLabels validates a done pattern and returns the labels recording it
+func readyLabels(pattern string, timeout int) (map[string]string, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDonePattern, err)
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("%w: readyTimeout must not be negative", ErrInvalidDonePattern)
+	}
+
+	labels := map[string]string{LabelDonePattern: pattern}
+	if timeout > 0 {
+		labels[LabelReadyTimeout] = strconv.Itoa(timeout)
+	}
+	return labels, nil
+}
+
+// ParseReadyCheck returns the done pattern stored in a container's labels
+// and how long to wait for it, using def when no timeout was given. The
+// pattern is nil for servers that are ready as soon as they start.
+func ParseReadyCheck(labels map[string]string, def time.Duration) (*regexp.Regexp, time.Duration) {
+	pattern, err := regexp.Compile(labels[LabelDonePattern])
+	if err != nil || pattern.String() == "" {
+		return nil, 0
+	}
+	if seconds, err := strconv.Atoi(labels[LabelReadyTimeout]); err == nil && seconds > 0 {
+		return pattern, time.Duration(seconds) * time.Second
+	}
+	return pattern, def
+}
@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyLabels(t *testing.T) {
+	labels, err := readyLabels(`\)! For help, type "help"`, 300)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		LabelDonePattern:  `\)! For help, type "help"`,
+		LabelReadyTimeout: "300",
+	}, labels)
+
+	labels, err = readyLabels("", 300)
+	assert.NoError(t, err)
+	assert.Nil(t, labels)
+
+	_, err = readyLabels("Done (", 0)
+	assert.ErrorIs(t, err, ErrInvalidDonePattern)
+}
+
+func TestParseReadyCheck(t *testing.T) {
+	pattern, timeout := ParseReadyCheck(map[string]string{LabelDonePattern: `^Done \(\d+\.\d+s\)`}, time.Minute)
+	require.NotNil(t, pattern)
+	assert.True(t, pattern.MatchString(`Done (4.213s)! For help, type "help"`))
+	assert.Equal(t, time.Minute, timeout)
+
+	_, timeout = ParseReadyCheck(map[string]string{LabelDonePattern: "ready", LabelReadyTimeout: "90"}, time.Minute)
+	assert.Equal(t, 90*time.Second, timeout)
+
+	pattern, _ = ParseReadyCheck(map[string]string{}, time.Minute)
+	assert.Nil(t, pattern)
+}
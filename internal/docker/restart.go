@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LabelRestartPolicy records a server's crash restart policy as JSON
+const LabelRestartPolicy = "ctrl-alt-play.restart-policy"
+
+// Crash restart policies. Docker's own restart policy is disabled on game
+// containers; the agent restarts them so crashes are reported and loops
+// can be stopped.
+const (
+	RestartNever   = "never"    // never restart
+	RestartOnCrash = "on-crash" // restart after a non-zero exit or OOM kill
+	RestartAlways  = "always"   // restart after any exit not requested by a user
+)
+
+// ErrInvalidRestartPolicy is returned for restart policies that cannot be applied
+var ErrInvalidRestartPolicy = errors.New("invalid restart policy")
+
+// RestartPolicy controls how the agent restarts a server that exited on its
+// own. Zero fields fall back to the agent's defaults, except MaxRestarts,
+// which falls back only when unset so that 0 can disable restarts.
+type RestartPolicy struct {
+	Policy      string `json:"policy,omitempty"`      // never, on-crash or always
+	MaxRestarts *int   `json:"maxRestarts,omitempty"` // restarts allowed within Window before giving up; 0 never restarts
+	Window      int    `json:"window,omitempty"`      // in seconds
+	Backoff     int    `json:"backoff,omitempty"`     // delay before the first restart in seconds, doubled per attempt
+}
+
+// WindowDuration returns the crash counting window
+func (p RestartPolicy) WindowDuration() time.Duration {
+	return time.Duration(p.Window) * time.Second
+}
+
+// RestartLimit returns the restarts allowed within the window
+func (p RestartPolicy) RestartLimit() int {
+	if p.MaxRestarts == nil {
+		return 0
+	}
+	return *p.MaxRestarts
+}
+
+// BackoffDuration returns the delay before the first restart
+func (p RestartPolicy) BackoffDuration() time.Duration {
+	return time.Duration(p.Backoff) * time.Second
+}
+
+// Merge fills the zero fields of p from defaults
+func (p RestartPolicy) Merge(defaults RestartPolicy) RestartPolicy {
+	if p.Policy == "" {
+		p.Policy = defaults.Policy
+	}
+	if p.MaxRestarts == nil {
+		p.MaxRestarts = defaults.MaxRestarts
+	}
+	if p.Window == 0 {
+		p.Window = defaults.Window
+	}
+	if p.Backoff == 0 {
+		p.Backoff = defaults.Backoff
+	}
+	return p
+}
+
+// Validate checks the policy name and bounds
+func (p RestartPolicy) Validate() error {
+	switch p.Policy {
+	case "", RestartNever, RestartOnCrash, RestartAlways:
+	default:
+		return fmt.Errorf("%w: unknown policy %q", ErrInvalidRestartPolicy, p.Policy)
+	}
+	if p.RestartLimit() < 0 || p.Window < 0 || p.Backoff < 0 {
+		return fmt.Errorf("%w: maxRestarts, window and backoff must not be negative", ErrInvalidRestartPolicy)
+	}
+	return nil
+}
+
+// ParseRestartPolicy decodes the policy stored in a container's labels. A
+// missing or unreadable label yields the zero policy.
+func ParseRestartPolicy(labels map[string]string) RestartPolicy {
+	var p RestartPolicy
+	if value := labels[LabelRestartPolicy]; value != "" {
+		if err := json.Unmarshal([]byte(value), &p); err != nil {
+			return RestartPolicy{}
+		}
+	}
+	return p
+}
+
+// label encodes the policy for LabelRestartPolicy
+func (p RestartPolicy) label() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
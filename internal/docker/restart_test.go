@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestRestartPolicy_Validate(t *testing.T) {
+	assert.NoError(t, RestartPolicy{}.Validate())
+	assert.NoError(t, RestartPolicy{Policy: RestartAlways, MaxRestarts: intPtr(5), Window: 60, Backoff: 2}.Validate())
+	assert.ErrorIs(t, RestartPolicy{Policy: "unless-stopped"}.Validate(), ErrInvalidRestartPolicy)
+	assert.ErrorIs(t, RestartPolicy{Policy: RestartOnCrash, Backoff: -1}.Validate(), ErrInvalidRestartPolicy)
+	assert.ErrorIs(t, RestartPolicy{MaxRestarts: intPtr(-1)}.Validate(), ErrInvalidRestartPolicy)
+}
+
+func TestRestartPolicy_LabelRoundTrip(t *testing.T) {
+	policy := RestartPolicy{Policy: RestartNever, MaxRestarts: intPtr(4)}
+	labels := map[string]string{LabelRestartPolicy: policy.label()}
+
+	assert.Equal(t, policy, ParseRestartPolicy(labels))
+	assert.Equal(t, RestartPolicy{}, ParseRestartPolicy(nil))
+	assert.Equal(t, RestartPolicy{}, ParseRestartPolicy(map[string]string{LabelRestartPolicy: "{"}))
+}
+
+func TestRestartPolicy_Merge(t *testing.T) {
+	defaults := RestartPolicy{Policy: RestartOnCrash, MaxRestarts: intPtr(3), Window: 600, Backoff: 5}
+
+	merged := RestartPolicy{Policy: RestartAlways, Backoff: 30}.Merge(defaults)
+
+	assert.Equal(t, RestartPolicy{Policy: RestartAlways, MaxRestarts: intPtr(3), Window: 600, Backoff: 30}, merged)
+}
+
+func TestRestartPolicy_MergeKeepsZeroMaxRestarts(t *testing.T) {
+	defaults := RestartPolicy{Policy: RestartOnCrash, MaxRestarts: intPtr(3)}
+
+	policy := ParseRestartPolicy(map[string]string{LabelRestartPolicy: `{"maxRestarts":0}`}).Merge(defaults)
+	assert.Equal(t, 0, policy.RestartLimit())
+
+	policy = ParseRestartPolicy(map[string]string{LabelRestartPolicy: `{}`}).Merge(defaults)
+	assert.Equal(t, 3, policy.RestartLimit())
+}
@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+// ContainerStats is a decoded resource usage sample of a container
+type ContainerStats struct {
+	CPUPercent    float64   `json:"cpuPercent"`
+	MemoryUsage   int64     `json:"memoryUsage"` // bytes, excluding page cache
+	MemoryLimit   int64     `json:"memoryLimit"` // bytes
+	MemoryPercent float64   `json:"memoryPercent"`
+	NetworkRx     int64     `json:"networkRx"`  // bytes received
+	NetworkTx     int64     `json:"networkTx"`  // bytes sent
+	BlockRead     int64     `json:"blockRead"`  // bytes read from block devices
+	BlockWrite    int64     `json:"blockWrite"` // bytes written to block devices
+	PIDs          uint64    `json:"pids"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// GetServerStats returns a decoded resource usage snapshot of a container.
+// Docker samples twice for non-streaming requests, so the CPU percentage
+// is computed over a real interval.
+func (m *Manager) GetServerStats(ctx context.Context, containerID string) (*ContainerStats, error) {
+	resp, err := m.client.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var raw container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+
+	return calculateStats(&raw), nil
+}
+
+// StreamServerStats follows the streaming Docker stats API for a container and
+// calls fn with every decoded sample until ctx is cancelled or the stream ends
+func (m *Manager) StreamServerStats(ctx context.Context, containerID string, fn func(*ContainerStats)) error {
+	resp, err := m.client.ContainerStats(ctx, containerID, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var raw container.StatsResponse
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to decode container stats: %w", err)
+		}
+		fn(calculateStats(&raw))
+	}
+}
+
+// ToServerStatsData converts a sample to the panel message format
+func (s *ContainerStats) ToServerStatsData() *messages.ServerStatsData {
+	data := &messages.ServerStatsData{
+		CPU:         s.CPUPercent,
+		Memory:      s.MemoryUsage,
+		MemoryLimit: s.MemoryLimit,
+		PIDs:        s.PIDs,
+		Timestamp:   s.Timestamp,
+	}
+	data.Network.In = s.NetworkRx
+	data.Network.Out = s.NetworkTx
+	data.BlockIO.Read = s.BlockRead
+	data.BlockIO.Write = s.BlockWrite
+	return data
+}
+
+// calculateStats derives usage figures from a raw Docker stats response
+func calculateStats(raw *container.StatsResponse) *ContainerStats {
+	stats := &ContainerStats{
+		CPUPercent:  cpuPercent(raw),
+		MemoryUsage: int64(memoryUsage(&raw.MemoryStats)),
+		MemoryLimit: int64(raw.MemoryStats.Limit),
+		PIDs:        raw.PidsStats.Current,
+		Timestamp:   raw.Read,
+	}
+
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100.0
+	}
+
+	for _, network := range raw.Networks {
+		stats.NetworkRx += int64(network.RxBytes)
+		stats.NetworkTx += int64(network.TxBytes)
+	}
+
+	for _, entry := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockRead += int64(entry.Value)
+		case "write":
+			stats.BlockWrite += int64(entry.Value)
+		}
+	}
+
+	if stats.Timestamp.IsZero() {
+		stats.Timestamp = time.Now()
+	}
+
+	return stats
+}
+
+// cpuPercent applies the docker CLI delta formula between the current and previous CPU sample
+func cpuPercent(raw *container.StatsResponse) float64 {
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+
+	onlineCPUs := float64(raw.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta <= 0 || systemDelta <= 0 || onlineCPUs == 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * onlineCPUs * 100.0
+}
+
+// memoryUsage returns memory usage excluding reclaimable page cache, like docker stats does
+func memoryUsage(mem *container.MemoryStats) uint64 {
+	// cgroup v1
+	if v, ok := mem.Stats["total_inactive_file"]; ok && v < mem.Usage {
+		return mem.Usage - v
+	}
+	// cgroup v2
+	if v, ok := mem.Stats["inactive_file"]; ok && v < mem.Usage {
+		return mem.Usage - v
+	}
+	return mem.Usage
+}
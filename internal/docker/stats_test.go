@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateStats(t *testing.T) {
+	read := time.Date(2025, 1, 23, 10, 0, 0, 0, time.UTC)
+	raw := &container.StatsResponse{
+		Read: read,
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 400_000_000},
+			SystemUsage: 20_000_000_000,
+			OnlineCPUs:  4,
+		},
+		PreCPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 200_000_000},
+			SystemUsage: 18_000_000_000,
+		},
+		MemoryStats: container.MemoryStats{
+			Usage: 600 * 1024 * 1024,
+			Limit: 2048 * 1024 * 1024,
+			Stats: map[string]uint64{"inactive_file": 88 * 1024 * 1024},
+		},
+		Networks: map[string]container.NetworkStats{
+			"eth0": {RxBytes: 1000, TxBytes: 500},
+			"eth1": {RxBytes: 24, TxBytes: 12},
+		},
+		BlkioStats: container.BlkioStats{
+			IoServiceBytesRecursive: []container.BlkioStatEntry{
+				{Op: "Read", Value: 4096},
+				{Op: "write", Value: 8192},
+				{Op: "Total", Value: 12288},
+			},
+		},
+		PidsStats: container.PidsStats{Current: 42},
+	}
+
+	stats := calculateStats(raw)
+
+	// 200ms of CPU over 2s of system time across 4 CPUs
+	assert.InDelta(t, 40.0, stats.CPUPercent, 0.001)
+	assert.Equal(t, int64(512*1024*1024), stats.MemoryUsage)
+	assert.Equal(t, int64(2048*1024*1024), stats.MemoryLimit)
+	assert.InDelta(t, 25.0, stats.MemoryPercent, 0.001)
+	assert.Equal(t, int64(1024), stats.NetworkRx)
+	assert.Equal(t, int64(512), stats.NetworkTx)
+	assert.Equal(t, int64(4096), stats.BlockRead)
+	assert.Equal(t, int64(8192), stats.BlockWrite)
+	assert.Equal(t, uint64(42), stats.PIDs)
+	assert.Equal(t, read, stats.Timestamp)
+}
+
+func TestCalculateStats_NoPreviousSample(t *testing.T) {
+	raw := &container.StatsResponse{
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 400_000_000, PercpuUsage: []uint64{1, 2}},
+			SystemUsage: 20_000_000_000,
+		},
+		MemoryStats: container.MemoryStats{
+			Usage: 1024,
+			Stats: map[string]uint64{"total_inactive_file": 4096},
+		},
+	}
+
+	stats := calculateStats(raw)
+
+	// Without a previous sample the delta spans all time, which is still a valid ratio
+	assert.InDelta(t, 4.0, stats.CPUPercent, 0.001)
+	// Cache larger than usage is ignored
+	assert.Equal(t, int64(1024), stats.MemoryUsage)
+	assert.Zero(t, stats.MemoryPercent)
+	assert.False(t, stats.Timestamp.IsZero())
+}
+
+func TestContainerStats_ToServerStatsData(t *testing.T) {
+	stats := &ContainerStats{
+		CPUPercent:  12.5,
+		MemoryUsage: 100,
+		MemoryLimit: 200,
+		NetworkRx:   1,
+		NetworkTx:   2,
+		BlockRead:   3,
+		BlockWrite:  4,
+		PIDs:        5,
+	}
+
+	data := stats.ToServerStatsData()
+
+	assert.Equal(t, 12.5, data.CPU)
+	assert.Equal(t, int64(100), data.Memory)
+	assert.Equal(t, int64(200), data.MemoryLimit)
+	assert.Equal(t, int64(1), data.Network.In)
+	assert.Equal(t, int64(2), data.Network.Out)
+	assert.Equal(t, int64(3), data.BlockIO.Read)
+	assert.Equal(t, int64(4), data.BlockIO.Write)
+	assert.Equal(t, uint64(5), data.PIDs)
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// LabelStopCommand records the console command that shuts a server down gracefully
+const LabelStopCommand = "ctrl-alt-play.stop-command"
+
+// DefaultStopTimeout is the grace period a server gets before it is killed
+const DefaultStopTimeout = 30 * time.Second
+
+// Stages reported by StopContainer
+const (
+	StopStageNotRunning = "not_running" // the container was already stopped
+	StopStageCommand    = "command"     // the game exited after its stop command
+	StopStageSignal     = "signal"      // the game exited after the stop signal
+	StopStageKill       = "kill"        // the game had to be killed
+)
+
+// StopOptions controls how a container is stopped. The stop command and the
+// stop signal together get Timeout before the container is killed; a stop
+// command gets the first half of it.
+type StopOptions struct {
+	Command string        // console command to try first; defaults to the server's stop command
+	Signal  string        // signal sent next; defaults to the image's stop signal (SIGTERM)
+	Timeout time.Duration // grace period before SIGKILL; defaults to DefaultStopTimeout
+}
+
+// StopContainer stops a container, first with the game's stop command when
+// one is known, then with the stop signal and finally SIGKILL. It returns
+// the stage that stopped the container.
+func (m *Manager) StopContainer(ctx context.Context, containerID string, opts StopOptions) (string, error) {
+	info, err := m.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.State == nil || !info.State.Running {
+		return StopStageNotRunning, nil
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	command := opts.Command
+	if command == "" && info.Config != nil {
+		command = info.Config.Labels[LabelStopCommand]
+	}
+	if command != "" && info.Config != nil && info.Config.OpenStdin {
+		stopped, err := m.waitExit(ctx, info.ID, timeout/2, func() error {
+			return m.SendCommand(ctx, info.ID, command)
+		})
+		if err != nil {
+			log.Printf("Stop command for container %s failed, sending signal: %v", containerID, err)
+		}
+		if stopped {
+			return StopStageCommand, nil
+		}
+	}
+
+	signal := opts.Signal
+	if signal == "" && info.Config != nil {
+		signal = info.Config.StopSignal
+	}
+	if signal == "" {
+		signal = "SIGTERM"
+	}
+	if !IsKillSignal(signal) {
+		stopped, err := m.waitExit(ctx, info.ID, time.Until(deadline), func() error {
+			return m.client.ContainerKill(ctx, info.ID, signal)
+		})
+		if err != nil {
+			return "", err
+		}
+		if stopped {
+			return StopStageSignal, nil
+		}
+	}
+
+	stopped, err := m.waitExit(ctx, info.ID, DefaultStopTimeout, func() error {
+		return m.client.ContainerKill(ctx, info.ID, "SIGKILL")
+	})
+	if err != nil {
+		return "", err
+	}
+	if !stopped {
+		return "", fmt.Errorf("container %s did not exit after SIGKILL", info.ID)
+	}
+	return StopStageKill, nil
+}
+
+// waitExit runs trigger and waits up to timeout for the container to exit.
+// It reports false once the timeout passes with the container still running.
+func (m *Manager) waitExit(ctx context.Context, containerID string, timeout time.Duration, trigger func() error) (bool, error) {
+	if timeout <= 0 {
+		return false, nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// Wait before triggering so a quick exit is not missed
+	exited, waitErr := m.client.ContainerWait(waitCtx, containerID, container.WaitConditionNotRunning)
+
+	if err := trigger(); err != nil {
+		// Signalling fails when the container exited on its own meanwhile
+		if info, inspectErr := m.client.ContainerInspect(ctx, containerID); inspectErr == nil && info.State != nil && !info.State.Running {
+			return true, nil
+		}
+		return false, err
+	}
+
+	select {
+	case <-exited:
+		return true, nil
+	case err := <-waitErr:
+		if waitCtx.Err() != nil && ctx.Err() == nil {
+			return false, nil // grace period over
+		}
+		return false, fmt.Errorf("failed waiting for container to exit: %w", err)
+	}
+}
+
+// KillContainer sends signal (SIGKILL if empty) to the main process of a
+// running container. The container and its data are left in place.
+func (m *Manager) KillContainer(ctx context.Context, containerID, signal string) error {
+	info, err := m.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if info.State == nil || !info.State.Running {
+		return ErrServerNotRunning
+	}
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	return m.client.ContainerKill(ctx, info.ID, signal)
+}
+
+// IsKillSignal reports whether signal terminates the process unconditionally
+func IsKillSignal(signal string) bool {
+	switch strings.ToUpper(signal) {
+	case "", "SIGKILL", "KILL", "9":
+		return true
+	}
+	return false
+}
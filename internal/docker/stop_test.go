@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsKillSignal(t *testing.T) {
+	for _, signal := range []string{"", "SIGKILL", "kill", "9"} {
+		assert.True(t, IsKillSignal(signal), signal)
+	}
+	for _, signal := range []string{"SIGTERM", "SIGHUP", "15"} {
+		assert.False(t, IsKillSignal(signal), signal)
+	}
+}
+
+func TestStopContainer_Stages(t *testing.T) {
+	tests := []struct {
+		name        string
+		stopCommand string
+		stopSignal  string
+		opts        StopOptions
+		obeys       string
+		wantStage   string
+		wantSignals []string
+	}{
+		{"stop command", "stop", "", StopOptions{}, "stop", StopStageCommand, nil},
+		{"default signal", "", "", StopOptions{}, "SIGTERM", StopStageSignal, []string{"SIGTERM"}},
+		{"image stop signal", "", "SIGINT", StopOptions{}, "SIGINT", StopStageSignal, []string{"SIGINT"}},
+		{"requested signal", "stop", "", StopOptions{Signal: "SIGHUP", Command: "quit"}, "SIGHUP", StopStageSignal, []string{"SIGHUP"}},
+		{"requested kill", "", "", StopOptions{Signal: "SIGKILL"}, "", StopStageKill, []string{"SIGKILL"}},
+		{"ignores everything", "stop", "", StopOptions{}, "", StopStageKill, []string{"SIGTERM", "SIGKILL"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, m := newFakeDaemon(t)
+			d.stopCommand = tt.stopCommand
+			d.stopSignal = tt.stopSignal
+			d.obeys[tt.obeys] = true
+			tt.opts.Timeout = 200 * time.Millisecond
+
+			stage, err := m.StopContainer(context.Background(), "mc", tt.opts)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStage, stage)
+			assert.Equal(t, tt.wantSignals, d.sentSignals())
+			assert.False(t, d.running())
+		})
+	}
+}
+
+func TestStopContainer_TimeoutBoundsGracePeriod(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	d.stopCommand = "stop"
+
+	start := time.Now()
+	stage, err := m.StopContainer(context.Background(), "mc", StopOptions{Timeout: 400 * time.Millisecond})
+
+	require.NoError(t, err)
+	assert.Equal(t, StopStageKill, stage)
+	assert.Equal(t, "stop", d.nextLine(t))
+	// The command and the signal share the timeout before SIGKILL
+	assert.Less(t, time.Since(start), 700*time.Millisecond)
+}
+
+func TestStopContainer_NotRunning(t *testing.T) {
+	d, m := newFakeDaemon(t)
+	close(d.exited)
+
+	stage, err := m.StopContainer(context.Background(), "mc", StopOptions{})
+
+	require.NoError(t, err)
+	assert.Equal(t, StopStageNotRunning, stage)
+	assert.Empty(t, d.sentSignals())
+}
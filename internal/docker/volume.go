@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// DataVolume describes where persistent server data lives. Each server gets
+// a subdirectory of HostDir bind-mounted at ContainerPath.
+type DataVolume struct {
+	HostDir       string // base directory on the host, one subdirectory per server
+	ContainerPath string // mount point and working directory inside the container
+	UID           int    // owner of newly created server directories
+	GID           int
+}
+
+// ServerDataDir returns the data directory of serverID below base. Server IDs
+// that could escape base are rejected.
+func ServerDataDir(base, serverID string) (string, error) {
+	if serverID == "" || serverID == "." || serverID == ".." || strings.ContainsAny(serverID, `/\`) {
+		return "", fmt.Errorf("invalid server ID %q", serverID)
+	}
+	return filepath.Join(base, serverID), nil
+}
+
+// SetDataVolume configures the data directory mounted into game containers
+func (m *Manager) SetDataVolume(volume DataVolume) {
+	m.volume = volume
+}
+
+// ensureDataDir creates the data directory of a server owned by the
+// configured user and returns its path
+func (m *Manager) ensureDataDir(serverID string) (string, error) {
+	dir, err := ServerDataDir(m.volume.HostDir, serverID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	// Only root may hand the directory to the container user
+	if os.Geteuid() == 0 {
+		if err := os.Chown(dir, m.volume.UID, m.volume.GID); err != nil {
+			return "", fmt.Errorf("failed to set data directory owner: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// dataMount returns the bind mount of a server's data directory
+func (m *Manager) dataMount(serverID string) ([]mount.Mount, error) {
+	if m.volume.HostDir == "" {
+		return nil, nil
+	}
+	dir, err := m.ensureDataDir(serverID)
+	if err != nil {
+		return nil, err
+	}
+	return []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: dir,
+		Target: m.volume.ContainerPath,
+	}}, nil
+}
+
+// dataDirUsage returns the bytes stored in a server's data directory.
+// Symlinks are not followed, so only files inside the directory count.
+func (m *Manager) dataDirUsage(serverID string) (int64, error) {
+	if m.volume.HostDir == "" {
+		return 0, nil
+	}
+	dir, err := ServerDataDir(m.volume.HostDir, serverID)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			var info fs.FileInfo
+			if info, err = d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		// The server keeps writing while we walk, so files may vanish
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+	return size, err
+}
+
+// PurgeServerData deletes a server's data directory and everything in it
+func (m *Manager) PurgeServerData(serverID string) error {
+	if m.volume.HostDir == "" {
+		return nil
+	}
+	dir, err := ServerDataDir(m.volume.HostDir, serverID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Purging data directory %s of server %s", dir, serverID)
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDataDir(t *testing.T) {
+	dir, err := ServerDataDir("/opt/gameservers", "minecraft-001")
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/gameservers/minecraft-001", dir)
+
+	for _, id := range []string{"", ".", "..", "../etc", "a/b", `a\b`} {
+		_, err := ServerDataDir("/opt/gameservers", id)
+		assert.Error(t, err, "server ID %q", id)
+	}
+}
+
+func TestManager_DataMount(t *testing.T) {
+	base := t.TempDir()
+	m := &Manager{}
+	m.SetDataVolume(DataVolume{HostDir: base, ContainerPath: "/home/container", UID: os.Getuid(), GID: os.Getgid()})
+
+	mounts, err := m.dataMount("minecraft-001")
+	require.NoError(t, err)
+	assert.Equal(t, []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: filepath.Join(base, "minecraft-001"),
+		Target: "/home/container",
+	}}, mounts)
+	assert.DirExists(t, filepath.Join(base, "minecraft-001"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(base, "minecraft-001", "world.dat"), []byte("data"), 0o644))
+	require.NoError(t, m.PurgeServerData("minecraft-001"))
+	assert.NoDirExists(t, filepath.Join(base, "minecraft-001"))
+	assert.DirExists(t, base)
+}
+
+func TestManager_DataMountDisabled(t *testing.T) {
+	mounts, err := (&Manager{}).dataMount("minecraft-001")
+	require.NoError(t, err)
+	assert.Empty(t, mounts)
+}
+
+func TestManager_DataDirUsage(t *testing.T) {
+	base := t.TempDir()
+	m := &Manager{}
+	m.SetDataVolume(DataVolume{HostDir: base})
+
+	usage, err := m.dataDirUsage("minecraft-001")
+	require.NoError(t, err)
+	assert.Zero(t, usage, "missing directory")
+
+	dir := filepath.Join(base, "minecraft-001")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "world"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "server.properties"), make([]byte, 100), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "world", "level.dat"), make([]byte, 400), 0o644))
+	// Links out of the directory do not count
+	outside := filepath.Join(base, "outside.bin")
+	require.NoError(t, os.WriteFile(outside, make([]byte, 1000), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.bin")))
+
+	usage, err = m.dataDirUsage("minecraft-001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), usage)
+}
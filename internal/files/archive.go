@@ -0,0 +1,646 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive formats
+const (
+	FormatTarGz  = "tar.gz"
+	FormatZip    = "zip"
+	FormatTarZst = "tar.zst"
+)
+
+var (
+	// ErrUnknownFormat rejects archives whose format is neither given nor
+	// recognizable from their extension
+	ErrUnknownFormat = errors.New("unknown archive format: use tar.gz, zip or tar.zst")
+	// ErrUnsafeArchive rejects archives with entries that would be written
+	// outside the destination (zip slip)
+	ErrUnsafeArchive = errors.New("archive entry escapes the destination")
+	// ErrArchiveTooLarge stops extracting an archive that exceeds the
+	// extraction limits (decompression bomb)
+	ErrArchiveTooLarge = errors.New("archive exceeds the extraction limits")
+)
+
+// ArchiveLimits bounds what extracting an archive may produce
+type ArchiveLimits struct {
+	MaxBytes   int64 // total size of the extracted files
+	MaxEntries int   // number of files and directories
+}
+
+// ArchiveResult summarizes a compressed or extracted archive
+type ArchiveResult struct {
+	Entries int   // files and directories written
+	Bytes   int64 // uncompressed size of the files
+	Skipped int   // links and special files that were not extracted
+}
+
+// ArchiveProgress reports how far an archive operation got. Bytes counts
+// the input files read when compressing and the archive read when
+// extracting; Total is what will be read in all.
+type ArchiveProgress struct {
+	Bytes int64
+	Total int64
+}
+
+// DetectFormat returns the archive format of a file name, or "" if its
+// extension is not recognized
+func DetectFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatTarZst
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip
+	default:
+		return ""
+	}
+}
+
+// archiveFormat returns format, or the format detected from name if empty
+func archiveFormat(format, name string) (string, error) {
+	if format == "" {
+		format = DetectFormat(name)
+	}
+	switch format {
+	case FormatTarGz, FormatZip, FormatTarZst:
+		return format, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// countingReader reports progress while reading
+type countingReader struct {
+	r        io.Reader
+	progress *progressCounter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.progress.add(int64(n))
+	return n, err
+}
+
+// countingReaderAt reports progress while a zip archive is read
+type countingReaderAt struct {
+	r        io.ReaderAt
+	progress *progressCounter
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.progress.add(int64(n))
+	return n, err
+}
+
+// progressCounter accumulates bytes read and passes them to a callback
+type progressCounter struct {
+	done, total int64
+	fn          func(ArchiveProgress)
+}
+
+func (p *progressCounter) add(n int64) {
+	p.done += n
+	if p.fn != nil && n > 0 {
+		p.fn(ArchiveProgress{Bytes: p.done, Total: p.total})
+	}
+}
+
+// Compress writes the files and directories at paths, recursively, into a
+// new archive at dest. Each path becomes a top-level entry under its base
+// name. Symlinks are stored as links. A failed archive is removed.
+func (d *Dir) Compress(paths []string, dest, format string, progress func(ArchiveProgress)) (ArchiveResult, error) {
+	destName, err := entry(dest)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	if within(destName, uploadDir) {
+		return ArchiveResult{}, fmt.Errorf("%s: %w", dest, ErrOutsideRoot)
+	}
+	if format, err = archiveFormat(format, destName); err != nil {
+		return ArchiveResult{}, err
+	}
+
+	sources := make([]string, 0, len(paths))
+	counter := &progressCounter{fn: progress}
+	for _, p := range paths {
+		name, err := entry(p)
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+		err = d.walk(name, destName, func(name string, info fs.FileInfo) error {
+			if info.Mode().IsRegular() {
+				counter.total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+		sources = append(sources, name)
+	}
+
+	out, err := d.root.OpenFile(destName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return ArchiveResult{}, rootError(err)
+	}
+
+	result, err := d.compress(out, format, sources, destName, counter)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = d.chown(destName)
+	}
+	if err != nil {
+		d.root.Remove(destName)
+		return ArchiveResult{}, err
+	}
+	return result, nil
+}
+
+func (d *Dir) compress(out io.Writer, format string, sources []string, destName string, counter *progressCounter) (ArchiveResult, error) {
+	w, err := newArchiveWriter(out, format)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+
+	var result ArchiveResult
+	for _, src := range sources {
+		parent := path.Dir(src)
+		err := d.walk(src, destName, func(name string, info fs.FileInfo) error {
+			rel := strings.TrimPrefix(name, parent+"/")
+			if parent == "." {
+				rel = name
+			}
+
+			var link string
+			var r io.Reader
+			switch {
+			case info.Mode()&fs.ModeSymlink != 0:
+				target, err := d.root.Readlink(name)
+				if err != nil {
+					return rootError(err)
+				}
+				link = target
+			case info.Mode().IsRegular():
+				f, err := d.root.Open(name)
+				if err != nil {
+					return rootError(err)
+				}
+				defer f.Close()
+				r = &countingReader{r: f, progress: counter}
+				result.Bytes += info.Size()
+			case !info.IsDir():
+				result.Skipped++
+				return nil
+			}
+
+			result.Entries++
+			return w.add(rel, info, link, r)
+		})
+		if err != nil {
+			w.Close()
+			return ArchiveResult{}, err
+		}
+	}
+	return result, w.Close()
+}
+
+// walk calls fn for name and, if it is a directory, everything below it
+// without following symlinks. The archive being written and partial
+// uploads are left out.
+func (d *Dir) walk(name, exclude string, fn func(name string, info fs.FileInfo) error) error {
+	if name == exclude || within(name, uploadDir) {
+		return nil
+	}
+	info, err := d.root.Lstat(name)
+	if err != nil {
+		return rootError(err)
+	}
+	if err := fn(name, info); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	entries, err := d.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := d.walk(path.Join(name, e.Name()), exclude, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveWriter writes the entries of one archive format
+type archiveWriter interface {
+	// add writes an entry; r is nil for directories and symlinks
+	add(name string, info fs.FileInfo, link string, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(out io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case FormatTarGz:
+		gz := gzip.NewWriter(out)
+		return &tarWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	case FormatZip:
+		return &zipWriter{zw: zip.NewWriter(out)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (w *tarWriter) add(name string, info fs.FileInfo, link string, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// Host user names mean nothing where the archive is extracted
+	hdr.Uname, hdr.Gname = "", ""
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(w.tw, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if closeErr := w.compressor.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) add(name string, info fs.FileInfo, link string, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	} else if info.Mode().IsRegular() {
+		hdr.Method = zip.Deflate
+	}
+
+	ew, err := w.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case link != "":
+		// Zip stores the target of a symlink as its content
+		_, err = io.WriteString(ew, link)
+	case r != nil:
+		_, err = io.Copy(ew, r)
+	}
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// Extract unpacks the archive at p into the directory dest, creating it if
+// needed. Entries leaving dest are rejected, links and special files are
+// skipped, and extraction stops once it exceeds limits. Existing files are
+// replaced; when extraction fails the files and directories it created are
+// removed and the files it replaced are put back.
+func (d *Dir) Extract(p, dest, format string, limits ArchiveLimits, progress func(ArchiveProgress)) (ArchiveResult, error) {
+	srcName, err := entry(p)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	destName, err := Clean(dest)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	if format, err = archiveFormat(format, srcName); err != nil {
+		return ArchiveResult{}, err
+	}
+
+	f, err := d.root.Open(srcName)
+	if err != nil {
+		return ArchiveResult{}, rootError(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	counter := &progressCounter{total: info.Size(), fn: progress}
+
+	x := &extractor{dir: d, dest: destName, limits: limits}
+	err = x.mkdirAll(destName)
+	if err == nil {
+		err = x.extract(f, info.Size(), format, counter)
+	}
+	if err != nil {
+		x.rollback()
+		return ArchiveResult{}, err
+	}
+	x.dropBackups()
+	return x.result, nil
+}
+
+// change is one file or directory an extraction wrote. saved holds the
+// file it replaced, if any.
+type change struct {
+	name  string
+	saved string
+}
+
+// extractor writes the entries of an archive below dest
+type extractor struct {
+	dir     *Dir
+	dest    string
+	limits  ArchiveLimits
+	result  ArchiveResult
+	changes []change // in order
+	backups string   // directory holding replaced files, once one was replaced
+}
+
+func (x *extractor) extract(f *os.File, size int64, format string, counter *progressCounter) error {
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(&countingReader{r: f, progress: counter})
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return x.tar(tar.NewReader(gz))
+
+	case FormatTarZst:
+		zr, err := zstd.NewReader(&countingReader{r: f, progress: counter}, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return x.tar(tar.NewReader(zr))
+
+	case FormatZip:
+		zr, err := zip.NewReader(&countingReaderAt{r: f, progress: counter}, size)
+		if err != nil {
+			return err
+		}
+		return x.zip(zr)
+
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func (x *extractor) tar(tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.entry(hdr.Name, true, 0, nil)
+		case tar.TypeReg:
+			err = x.entry(hdr.Name, false, hdr.FileInfo().Mode(), tr)
+		default:
+			x.result.Skipped++
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(zr *zip.Reader) error {
+	// Reject bombs by their declared size up front; the actual size is
+	// still counted while extracting
+	if x.limits.MaxEntries > 0 && len(zr.File) > x.limits.MaxEntries {
+		return fmt.Errorf("%w: %d entries", ErrArchiveTooLarge, len(zr.File))
+	}
+	var declared uint64
+	for _, zf := range zr.File {
+		declared += zf.UncompressedSize64
+	}
+	if x.limits.MaxBytes > 0 && declared > uint64(x.limits.MaxBytes) {
+		return fmt.Errorf("%w: %d bytes", ErrArchiveTooLarge, declared)
+	}
+
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := x.entry(zf.Name, true, 0, nil); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = x.entry(zf.Name, false, mode, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			x.result.Skipped++
+		}
+	}
+	return nil
+}
+
+// entry writes one directory or file of the archive
+func (x *extractor) entry(name string, isDir bool, mode fs.FileMode, r io.Reader) error {
+	x.result.Entries++
+	if x.limits.MaxEntries > 0 && x.result.Entries > x.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, x.limits.MaxEntries)
+	}
+
+	// Archives use forward slashes; anything absolute or climbing out is zip slip
+	rel := strings.TrimSuffix(name, "/")
+	if !filepath.IsLocal(rel) || strings.Contains(rel, `\`) {
+		return fmt.Errorf("%w: %s", ErrUnsafeArchive, name)
+	}
+	target := path.Join(x.dest, rel)
+	if within(target, uploadDir) {
+		return fmt.Errorf("%w: %s", ErrUnsafeArchive, name)
+	}
+
+	if isDir {
+		return x.mkdirAll(target)
+	}
+	if err := x.mkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	// Move whatever is there aside rather than write through a symlink,
+	// so a failed extraction can put it back
+	existing, err := x.dir.root.Lstat(target)
+	switch {
+	case err == nil && existing.IsDir():
+		return fmt.Errorf("%s: %w", target, fs.ErrExist)
+	case err == nil:
+		if err := x.backup(target); err != nil {
+			return err
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		x.changes = append(x.changes, change{name: target})
+	default:
+		return rootError(err)
+	}
+
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0o644
+	}
+	f, err := x.dir.root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return rootError(err)
+	}
+
+	// Count what is actually written, whatever the headers claim
+	limit := int64(-1)
+	if x.limits.MaxBytes > 0 {
+		limit = x.limits.MaxBytes - x.result.Bytes
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(f, r)
+	x.result.Bytes += n
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if limit >= 0 && n > limit {
+		return fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, x.limits.MaxBytes)
+	}
+	return x.dir.chown(target)
+}
+
+// backup moves the file at name into the backup directory
+func (x *extractor) backup(name string) error {
+	if x.backups == "" {
+		x.backups = path.Join(uploadDir, "extract-"+newUploadID())
+		if err := x.dir.root.MkdirAll(x.backups, 0o750); err != nil {
+			return rootError(err)
+		}
+	}
+	saved := path.Join(x.backups, strconv.Itoa(len(x.changes)))
+	if err := x.dir.root.Rename(name, saved); err != nil {
+		return rootError(err)
+	}
+	x.changes = append(x.changes, change{name: name, saved: saved})
+	return nil
+}
+
+// mkdirAll creates a directory and its missing parents, refusing to pass
+// through symlinks so entries stay below the destination
+func (x *extractor) mkdirAll(name string) error {
+	if name == "." {
+		return nil
+	}
+	if err := x.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	info, err := x.dir.root.Lstat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return nil
+	case err == nil:
+		return fmt.Errorf("%w: %s is not a directory", ErrUnsafeArchive, name)
+	case !errors.Is(err, fs.ErrNotExist):
+		return rootError(err)
+	}
+
+	if err := x.dir.root.Mkdir(name, 0o755); err != nil {
+		return rootError(err)
+	}
+	x.changes = append(x.changes, change{name: name})
+	return x.dir.chown(name)
+}
+
+// rollback undoes a failed extraction, newest change first: what it wrote
+// is removed and the files it replaced are moved back
+func (x *extractor) rollback() {
+	restored := true
+	for i := len(x.changes) - 1; i >= 0; i-- {
+		c := x.changes[i]
+		x.dir.root.Remove(c.name)
+		if c.saved == "" {
+			continue
+		}
+		if err := x.dir.root.Rename(c.saved, c.name); err != nil {
+			log.Printf("Failed to restore %s from %s after a failed extraction: %v", c.name, c.saved, err)
+			restored = false
+		}
+	}
+	// Keep the backups of files that could not be put back
+	if restored {
+		x.dropBackups()
+	}
+}
+
+// dropBackups removes the files replaced by the extraction
+func (x *extractor) dropBackups() {
+	if x.backups == "" {
+		return
+	}
+	if err := x.dir.root.RemoveAll(x.backups); err != nil {
+		log.Printf("Failed to remove extraction backups %s: %v", x.backups, err)
+	}
+}
@@ -0,0 +1,241 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var noLimits = ArchiveLimits{}
+
+// writeTarGz stores a tar.gz with the given entries in the server directory
+func writeTarGz(t *testing.T, dir *Dir, name string, entries map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for entryName, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: entryName, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, dir.WriteFile(name, buf.Bytes(), 0o644))
+}
+
+// writeZip stores a zip with the given entries in the server directory
+func writeZip(t *testing.T, dir *Dir, name string, entries map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for entryName, content := range entries {
+		w, err := zw.Create(entryName)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, dir.WriteFile(name, buf.Bytes(), 0o644))
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatTarGz, DetectFormat("backup.tar.gz"))
+	assert.Equal(t, FormatTarGz, DetectFormat("backup.TGZ"))
+	assert.Equal(t, FormatTarZst, DetectFormat("backup.tar.zst"))
+	assert.Equal(t, FormatZip, DetectFormat("modpack.zip"))
+	assert.Equal(t, "", DetectFormat("backup.rar"))
+}
+
+func TestDir_CompressExtract(t *testing.T) {
+	for _, format := range []string{FormatTarGz, FormatZip, FormatTarZst} {
+		t.Run(format, func(t *testing.T) {
+			base, dir := setup(t)
+			populate(t, dir)
+			require.NoError(t, os.Symlink("level.dat", filepath.Join(base, "abc", "world", "link.dat")))
+
+			var progress []ArchiveProgress
+			result, err := dir.Compress([]string{"world", "logs/latest.log"}, "backup."+format, "", func(p ArchiveProgress) {
+				progress = append(progress, p)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 6, result.Entries) // world, level.dat, link.dat, region, r.0.0.mca, latest.log
+			assert.Equal(t, int64(len("level")+len("region")+len("log")), result.Bytes)
+			require.NotEmpty(t, progress)
+			assert.Equal(t, result.Bytes, progress[len(progress)-1].Bytes)
+			assert.Equal(t, result.Bytes, progress[len(progress)-1].Total)
+
+			result, err = dir.Extract("backup."+format, "restore", "", noLimits, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 5, result.Entries)
+			assert.Equal(t, 1, result.Skipped) // the symlink
+
+			for name, want := range map[string]string{
+				"restore/world/level.dat":        "level",
+				"restore/world/region/r.0.0.mca": "region",
+				"restore/latest.log":             "log",
+			} {
+				got, err := dir.ReadFile(name)
+				require.NoError(t, err, name)
+				assert.Equal(t, want, string(got), name)
+			}
+			_, err = dir.Lstat("restore/world/link.dat")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
+
+func TestDir_CompressRejects(t *testing.T) {
+	_, dir := setup(t)
+	populate(t, dir)
+
+	_, err := dir.Compress([]string{"world"}, "backup.rar", "", nil)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = dir.Compress([]string{"../abcd"}, "backup.zip", "", nil)
+	assert.ErrorIs(t, err, ErrOutsideRoot)
+	_, err = dir.Compress([]string{"missing"}, "backup.zip", "", nil)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = dir.Compress([]string{"world"}, "logs/latest.log", FormatZip, nil)
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	// An archive written into a directory it compresses leaves itself out
+	_, err = dir.Compress([]string{"world"}, "world/backup.zip", "", nil)
+	require.NoError(t, err)
+	result, err := dir.Extract("world/backup.zip", "restore", "", noLimits, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Entries)
+}
+
+func TestDir_ExtractZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "/evil.txt", "world/../../evil.txt", `..\evil.txt`} {
+		t.Run(name, func(t *testing.T) {
+			base, dir := setup(t)
+			writeTarGz(t, dir, "evil.tar.gz", map[string]string{name: "evil"})
+			writeZip(t, dir, "evil.zip", map[string]string{name: "evil"})
+
+			_, err := dir.Extract("evil.tar.gz", "restore", "", noLimits, nil)
+			assert.ErrorIs(t, err, ErrUnsafeArchive)
+			_, err = dir.Extract("evil.zip", "restore", "", noLimits, nil)
+			assert.ErrorIs(t, err, ErrUnsafeArchive)
+
+			_, err = os.Stat(filepath.Join(base, "evil.txt"))
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+			_, err = dir.Stat("evil.txt")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
+
+func TestDir_ExtractThroughSymlink(t *testing.T) {
+	base, dir := setup(t)
+	require.NoError(t, dir.MkdirAll("restore", 0o755))
+	require.NoError(t, os.Symlink("../logs", filepath.Join(base, "abc", "restore", "logs")))
+	writeTarGz(t, dir, "evil.tar.gz", map[string]string{"logs/latest.log": "evil"})
+
+	_, err := dir.Extract("evil.tar.gz", "restore", "", noLimits, nil)
+	assert.ErrorIs(t, err, ErrUnsafeArchive)
+	_, err = dir.Stat("logs/latest.log")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDir_ExtractLimits(t *testing.T) {
+	_, dir := setup(t)
+	big := strings.Repeat("a", 1<<20)
+	entries := map[string]string{"a.txt": big, "b.txt": big, "c.txt": big}
+	writeTarGz(t, dir, "bomb.tar.gz", entries)
+	writeZip(t, dir, "bomb.zip", entries)
+
+	for _, archive := range []string{"bomb.tar.gz", "bomb.zip"} {
+		_, err := dir.Extract(archive, "restore", "", ArchiveLimits{MaxBytes: 2 << 20}, nil)
+		assert.ErrorIs(t, err, ErrArchiveTooLarge, archive)
+		_, err = dir.Extract(archive, "restore", "", ArchiveLimits{MaxEntries: 2}, nil)
+		assert.ErrorIs(t, err, ErrArchiveTooLarge, archive)
+
+		// A failed extraction removes what it created
+		_, err = dir.Stat("restore")
+		assert.ErrorIs(t, err, fs.ErrNotExist, archive)
+	}
+
+	result, err := dir.Extract("bomb.zip", "restore", "", ArchiveLimits{MaxBytes: 3 << 20, MaxEntries: 3}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3<<20), result.Bytes)
+}
+
+func TestDir_ExtractReplacesFiles(t *testing.T) {
+	_, dir := setup(t)
+	populate(t, dir)
+	writeZip(t, dir, "update.zip", map[string]string{"world/level.dat": "new", "world/extra.dat": "extra"})
+
+	_, err := dir.Extract("update.zip", ".", "", noLimits, nil)
+	require.NoError(t, err)
+	got, err := dir.ReadFile("world/level.dat")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(got))
+	got, err = dir.ReadFile("world/region/r.0.0.mca")
+	require.NoError(t, err)
+	assert.Equal(t, "region", string(got))
+}
+
+func TestDir_FailedExtractRestoresReplacedFiles(t *testing.T) {
+	base, dir := setup(t)
+	populate(t, dir)
+	require.NoError(t, os.Symlink("level.dat", filepath.Join(base, "abc", "world", "link")))
+
+	// The bad entry comes last, after files were already replaced
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct{ name, content string }{
+		{"world/level.dat", "corrupt"},
+		{"world/link", "not a link"},
+		{"world/level.dat", "twice"},
+		{"world/new/extra.dat", "extra"},
+		{"../../evil.txt", "evil"},
+	} {
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, dir.WriteFile("update.zip", buf.Bytes(), 0o644))
+
+	_, err := dir.Extract("update.zip", ".", "", noLimits, nil)
+	require.ErrorIs(t, err, ErrUnsafeArchive)
+
+	got, err := dir.ReadFile("world/level.dat")
+	require.NoError(t, err)
+	assert.Equal(t, "level", string(got))
+	target, err := os.Readlink(filepath.Join(base, "abc", "world", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "level.dat", target)
+	_, err = dir.Stat("world/new")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Backups are not left behind
+	entries, err := os.ReadDir(filepath.Join(base, "abc", uploadDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDir_ArchivesBelongToOwner(t *testing.T) {
+	base, dir, owner := openOwned(t)
+	populate(t, dir)
+
+	_, err := dir.Compress([]string{"world"}, "backup.tar.gz", "", nil)
+	require.NoError(t, err)
+	_, err = dir.Extract("backup.tar.gz", "restored", "", noLimits, nil)
+	require.NoError(t, err)
+
+	for _, name := range []string{"backup.tar.gz", "restored", "restored/world", "restored/world/level.dat", "restored/world/region/r.0.0.mca"} {
+		assertOwner(t, filepath.Join(base, "abc", name), owner)
+	}
+}
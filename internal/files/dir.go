@@ -0,0 +1,230 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+)
+
+// ErrOutsideRoot rejects paths that leave a server's data directory, either
+// through .. or through a symlink
+var ErrOutsideRoot = errors.New("path is outside the server directory")
+
+// Dir is the data directory of a server. Every path is resolved inside it
+// by os.Root, so neither .. nor a symlink planted by a game or mod can
+// reach a file outside it. Paths are relative to the directory; a leading
+// slash is allowed, as the panel sends them.
+type Dir struct {
+	root  *os.Root
+	owner *Owner // nil leaves new files to the agent's user
+}
+
+// Owner is the user and group that files created in a server directory are
+// handed to, so the game running as that user can change them
+type Owner struct {
+	UID int
+	GID int
+}
+
+// OpenServerDir opens the data directory of serverID below base, creating
+// it if it does not exist yet. Files and directories created through the
+// Dir belong to owner, if set.
+func OpenServerDir(base, serverID string, owner *Owner) (*Dir, error) {
+	if _, err := docker.ServerDataDir(base, serverID); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// The server directory is opened through the base so that it may not
+	// be a symlink out of it either
+	baseRoot, err := os.OpenRoot(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data directory: %w", err)
+	}
+	defer baseRoot.Close()
+
+	created := true
+	if err := baseRoot.Mkdir(serverID, 0o750); errors.Is(err, fs.ErrExist) {
+		created = false
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create server directory: %w", rootError(err))
+	}
+	root, err := baseRoot.OpenRoot(serverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open server directory: %w", rootError(err))
+	}
+
+	d := &Dir{root: root, owner: owner}
+	if created {
+		if err := d.chown("."); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("failed to set server directory owner: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// Clean converts a panel path to a name relative to the directory, "." for
+// the directory itself. Paths climbing out of the directory are rejected
+// rather than clamped to it.
+func Clean(p string) (string, error) {
+	name := strings.TrimLeft(filepath.ToSlash(p), "/")
+	if name == "" {
+		return ".", nil
+	}
+	name = path.Clean(name)
+	if name != "." && !filepath.IsLocal(name) {
+		return "", ErrOutsideRoot
+	}
+	return name, nil
+}
+
+// errPathEscapes is the message of the error os.Root returns when a path
+// leaves its directory. The error is not exported, so it is matched by its
+// text; TestRootEscapeMessage pins it against the toolchain in go.mod.
+const errPathEscapes = "path escapes from parent"
+
+// rootError reports os.Root refusing to leave its directory as ErrOutsideRoot
+func rootError(err error) error {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return err
+	}
+	for cause := pathErr.Err; cause != nil; cause = errors.Unwrap(cause) {
+		if cause.Error() == errPathEscapes {
+			return fmt.Errorf("%s: %w", pathErr.Path, ErrOutsideRoot)
+		}
+	}
+	return err
+}
+
+// chown hands the file at name, or the symlink itself, to the owner of the
+// directory. Only root may give files away; otherwise they stay with the
+// agent's user.
+func (d *Dir) chown(name string) error {
+	if d.owner == nil || os.Geteuid() != 0 {
+		return nil
+	}
+	return rootError(d.root.Lchown(name, d.owner.UID, d.owner.GID))
+}
+
+// mkdirAll creates the directory name and its missing parents, handing
+// each new directory to the owner
+func (d *Dir) mkdirAll(name string, perm fs.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	info, err := d.root.Stat(name)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return rootError(err)
+	}
+
+	if err := d.mkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+	if err := d.root.Mkdir(name, perm); err != nil {
+		return rootError(err)
+	}
+	return d.chown(name)
+}
+
+// Close releases the directory
+func (d *Dir) Close() error {
+	return d.root.Close()
+}
+
+// Stat returns information about the file at p, following symlinks inside the directory
+func (d *Dir) Stat(p string) (fs.FileInfo, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.root.Stat(name)
+	return info, rootError(err)
+}
+
+// ReadDir lists the directory at p
+func (d *Dir) ReadDir(p string) ([]fs.DirEntry, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.root.Open(name)
+	if err != nil {
+		return nil, rootError(err)
+	}
+	defer f.Close()
+	return f.ReadDir(-1)
+}
+
+// ReadFile returns the content of the file at p
+func (d *Dir) ReadFile(p string) ([]byte, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return nil, err
+	}
+	content, err := d.root.ReadFile(name)
+	return content, rootError(err)
+}
+
+// WriteFile stores content at p, creating parent directories as needed
+func (d *Dir) WriteFile(p string, content []byte, perm fs.FileMode) error {
+	name, err := Clean(p)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return fmt.Errorf("cannot write to the server directory itself")
+	}
+	if err := d.mkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if err := d.root.WriteFile(name, content, perm); err != nil {
+		return rootError(err)
+	}
+	return d.chown(name)
+}
+
+// MkdirAll creates the directory at p and any missing parents
+func (d *Dir) MkdirAll(p string, perm fs.FileMode) error {
+	name, err := Clean(p)
+	if err != nil {
+		return err
+	}
+	return d.mkdirAll(name, perm)
+}
+
+// Remove removes the file or empty directory at p. A symlink is removed
+// itself, never its target.
+func (d *Dir) Remove(p string) error {
+	name, err := Clean(p)
+	if err != nil {
+		return err
+	}
+	return rootError(d.root.Remove(name))
+}
+
+// Open opens the file at p for reading
+func (d *Dir) Open(p string) (*os.File, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.root.Open(name)
+	return f, rootError(err)
+}
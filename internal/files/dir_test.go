@@ -0,0 +1,179 @@
+package files
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setup creates a base directory holding the server "abc", a neighbouring
+// server "abcd" and a secret file outside the base
+func setup(t *testing.T) (base string, dir *Dir) {
+	t.Helper()
+	tmp := t.TempDir()
+	base = filepath.Join(tmp, "gameservers")
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "abcd"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "abcd", "server.properties"), []byte("neighbour"), 0o644))
+
+	dir, err := OpenServerDir(base, "abc", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { dir.Close() })
+	return base, dir
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"", ".", false},
+		{"/", ".", false},
+		{"world/level.dat", "world/level.dat", false},
+		{"/world/../server.properties", "server.properties", false},
+		{"//plugins///config.yml", "plugins/config.yml", false},
+		{"..", "", true},
+		{"/../abcd/server.properties", "", true},
+		{"world/../../secret.txt", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := Clean(tt.path)
+			if tt.err {
+				assert.ErrorIs(t, err, ErrOutsideRoot)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOpenServerDir(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "gameservers")
+
+	dir, err := OpenServerDir(base, "abc", nil)
+	require.NoError(t, err)
+	require.NoError(t, dir.WriteFile("/world/level.dat", []byte("level"), 0o644))
+	require.NoError(t, dir.Close())
+
+	content, err := os.ReadFile(filepath.Join(base, "abc", "world", "level.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, "level", string(content))
+
+	for _, id := range []string{"", ".", "..", "../abc", "a/b"} {
+		_, err := OpenServerDir(base, id, nil)
+		assert.Error(t, err, "server ID %q", id)
+	}
+}
+
+func TestOpenServerDir_RejectsSymlinkedServerDir(t *testing.T) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "gameservers")
+	require.NoError(t, os.MkdirAll(base, 0o755))
+	require.NoError(t, os.Symlink(tmp, filepath.Join(base, "abc")))
+
+	_, err := OpenServerDir(base, "abc", nil)
+	assert.ErrorIs(t, err, ErrOutsideRoot)
+}
+
+func TestDir_Traversal(t *testing.T) {
+	_, dir := setup(t)
+
+	// The prefix check this replaces let abc reach abcd
+	for _, p := range []string{"../abcd/server.properties", "/../secret.txt", "../../secret.txt", "world/../../abcd"} {
+		_, err := dir.ReadFile(p)
+		assert.ErrorIs(t, err, ErrOutsideRoot, "read %s", p)
+
+		err = dir.WriteFile(p, []byte("pwned"), 0o644)
+		assert.ErrorIs(t, err, ErrOutsideRoot, "write %s", p)
+
+		_, err = dir.ReadDir(p)
+		assert.ErrorIs(t, err, ErrOutsideRoot, "list %s", p)
+	}
+}
+
+func TestDir_SymlinkEscape(t *testing.T) {
+	base, dir := setup(t)
+	serverDir := filepath.Join(base, "abc")
+	outside := filepath.Dir(base)
+
+	// Links a game or mod could plant inside its data directory
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(serverDir, "absolute")))
+	require.NoError(t, os.Symlink("../../secret.txt", filepath.Join(serverDir, "relative")))
+	require.NoError(t, os.Symlink("../abcd", filepath.Join(serverDir, "neighbour")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(serverDir, "escape")))
+
+	for _, p := range []string{"absolute", "relative", "neighbour/server.properties", "escape/secret.txt"} {
+		_, err := dir.ReadFile(p)
+		assert.ErrorIs(t, err, ErrOutsideRoot, "read %s", p)
+
+		_, err = dir.Stat(p)
+		assert.ErrorIs(t, err, ErrOutsideRoot, "stat %s", p)
+	}
+
+	// Writing through a link must not create or change files outside
+	assert.ErrorIs(t, dir.WriteFile("escape/planted.txt", []byte("pwned"), 0o644), ErrOutsideRoot)
+	assert.ErrorIs(t, dir.WriteFile("neighbour/server.properties", []byte("pwned"), 0o644), ErrOutsideRoot)
+	assert.ErrorIs(t, dir.MkdirAll("escape/newdir", 0o755), ErrOutsideRoot)
+	_, err := os.Stat(filepath.Join(outside, "planted.txt"))
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	content, err := os.ReadFile(filepath.Join(base, "abcd", "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, "neighbour", string(content))
+
+	_, err = dir.ReadDir("escape")
+	assert.ErrorIs(t, err, ErrOutsideRoot)
+
+	// Removing a link removes the link, not its target
+	require.NoError(t, dir.Remove("relative"))
+	_, err = os.Stat(filepath.Join(outside, "secret.txt"))
+	assert.NoError(t, err)
+}
+
+// TestRootEscapeMessage fails when a toolchain update changes the error
+// os.Root returns for escaping paths, which rootError matches by text
+func TestRootEscapeMessage(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(tmp, "root"), 0o755))
+	require.NoError(t, os.Symlink("..", filepath.Join(tmp, "root", "up")))
+
+	root, err := os.OpenRoot(filepath.Join(tmp, "root"))
+	require.NoError(t, err)
+	defer root.Close()
+
+	for _, name := range []string{"../x", "up/x"} {
+		_, err := root.Open(name)
+		var pathErr *fs.PathError
+		require.ErrorAs(t, err, &pathErr, name)
+		assert.EqualError(t, pathErr.Err, errPathEscapes, name)
+		assert.ErrorIs(t, rootError(err), ErrOutsideRoot, name)
+	}
+}
+
+func TestDir_SymlinkInside(t *testing.T) {
+	_, dir := setup(t)
+	require.NoError(t, dir.WriteFile("world/level.dat", []byte("level"), 0o644))
+	require.NoError(t, os.Symlink("world", filepath.Join(dir.root.Name(), "current")))
+
+	// Links that stay inside the directory are followed
+	content, err := dir.ReadFile("current/level.dat")
+	require.NoError(t, err)
+	assert.Equal(t, "level", string(content))
+
+	entries, err := dir.ReadDir("/")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"world", "current"}, names)
+}
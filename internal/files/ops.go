@@ -0,0 +1,209 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrServerRoot rejects deleting, moving or replacing the server directory itself
+var ErrServerRoot = errors.New("cannot modify the server directory itself")
+
+// ErrDirNotEmpty rejects removing a non-empty directory without recursion
+var ErrDirNotEmpty = errors.New("directory is not empty")
+
+// ErrIntoItself rejects copying or moving a directory into itself
+var ErrIntoItself = errors.New("cannot copy or move a directory into itself")
+
+// entry cleans a path naming an entry of the directory, not the directory itself
+func entry(p string) (string, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return "", err
+	}
+	if name == "." {
+		return "", ErrServerRoot
+	}
+	return name, nil
+}
+
+// within reports whether name is dir or lies below it
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// Lstat returns information about the file at p without following a final symlink
+func (d *Dir) Lstat(p string) (fs.FileInfo, error) {
+	name, err := Clean(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.root.Lstat(name)
+	return info, rootError(err)
+}
+
+// CheckRemove reports why removing p would fail, without changing
+// anything. Unless recursive, only files and empty directories may be removed.
+func (d *Dir) CheckRemove(p string, recursive bool) error {
+	name, err := entry(p)
+	if err != nil {
+		return err
+	}
+	info, err := d.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !recursive {
+		entries, err := d.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("%s: %w", p, ErrDirNotEmpty)
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes the file or directory at p with everything below it.
+// Symlinks are removed, not followed.
+func (d *Dir) RemoveAll(p string) error {
+	name, err := entry(p)
+	if err != nil {
+		return err
+	}
+	return rootError(d.root.RemoveAll(name))
+}
+
+// CheckTransfer reports why renaming or copying from to to would fail,
+// without changing anything
+func (d *Dir) CheckTransfer(from, to string) error {
+	src, err := entry(from)
+	if err != nil {
+		return err
+	}
+	dst, err := entry(to)
+	if err != nil {
+		return err
+	}
+	if _, err := d.Lstat(src); err != nil {
+		return err
+	}
+	if within(dst, src) {
+		return ErrIntoItself
+	}
+	if _, err := d.Lstat(dst); err == nil {
+		return fmt.Errorf("%s: %w", to, fs.ErrExist)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	info, err := d.Stat(path.Dir(dst))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path.Dir(dst))
+	}
+	return nil
+}
+
+// Rename moves the file or directory at from to to. An existing file at to
+// is never replaced.
+func (d *Dir) Rename(from, to string) error {
+	if err := d.CheckTransfer(from, to); err != nil {
+		return err
+	}
+	src, _ := Clean(from)
+	dst, _ := Clean(to)
+	return rootError(d.root.Rename(src, dst))
+}
+
+// Copy copies the file or directory at from to to, recursively. Symlinks
+// are copied as links; an existing file at to is never replaced.
+func (d *Dir) Copy(from, to string) error {
+	if err := d.CheckTransfer(from, to); err != nil {
+		return err
+	}
+	src, _ := Clean(from)
+	dst, _ := Clean(to)
+	return d.copy(src, dst)
+}
+
+func (d *Dir) copy(src, dst string) error {
+	info, err := d.root.Lstat(src)
+	if err != nil {
+		return rootError(err)
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := d.root.Readlink(src)
+		if err != nil {
+			return rootError(err)
+		}
+		if err := d.root.Symlink(target, dst); err != nil {
+			return rootError(err)
+		}
+		return d.chown(dst)
+
+	case info.IsDir():
+		// Listed first, so a destination reached through a symlink into
+		// src is not copied into itself
+		entries, err := d.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		if err := d.root.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return rootError(err)
+		}
+		if err := d.chown(dst); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := d.copy(path.Join(src, e.Name()), path.Join(dst, e.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode().IsRegular():
+		return d.copyFile(src, dst, info.Mode().Perm())
+
+	default:
+		return fmt.Errorf("%s: cannot copy special file", src)
+	}
+}
+
+func (d *Dir) copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := d.root.Open(src)
+	if err != nil {
+		return rootError(err)
+	}
+	defer in.Close()
+
+	out, err := d.root.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return rootError(err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return d.chown(dst)
+}
+
+// Chmod changes the permission bits of the file at p
+func (d *Dir) Chmod(p string, mode fs.FileMode) error {
+	name, err := Clean(p)
+	if err != nil {
+		return err
+	}
+	return rootError(d.root.Chmod(name, mode))
+}
@@ -0,0 +1,155 @@
+package files
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// populate creates a small server directory tree
+func populate(t *testing.T, dir *Dir) {
+	t.Helper()
+	require.NoError(t, dir.WriteFile("world/level.dat", []byte("level"), 0o644))
+	require.NoError(t, dir.WriteFile("world/region/r.0.0.mca", []byte("region"), 0o644))
+	require.NoError(t, dir.WriteFile("logs/latest.log", []byte("log"), 0o644))
+	require.NoError(t, dir.MkdirAll("empty", 0o755))
+}
+
+// openOwned opens the server "abc" below a fresh base, handing created
+// files to a user other than the agent's
+func openOwned(t *testing.T) (base string, dir *Dir, owner Owner) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("changing file owners requires root")
+	}
+	base = t.TempDir()
+	owner = Owner{UID: 1234, GID: 5678}
+	dir, err := OpenServerDir(base, "abc", &owner)
+	require.NoError(t, err)
+	t.Cleanup(func() { dir.Close() })
+	return base, dir, owner
+}
+
+// assertOwner checks that the file at name, not following symlinks, belongs to owner
+func assertOwner(t *testing.T, name string, owner Owner) {
+	t.Helper()
+	info, err := os.Lstat(name)
+	require.NoError(t, err)
+	st := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, owner, Owner{UID: int(st.Uid), GID: int(st.Gid)}, name)
+}
+
+func TestDir_CreatedFilesBelongToOwner(t *testing.T) {
+	base, dir, owner := openOwned(t)
+	serverDir := filepath.Join(base, "abc")
+
+	populate(t, dir)
+	require.NoError(t, os.Symlink("level.dat", filepath.Join(serverDir, "world", "link")))
+	require.NoError(t, dir.MkdirAll("backup", 0o755))
+	require.NoError(t, dir.Copy("world", "backup/world"))
+
+	for _, name := range []string{
+		".", "world", "world/region", "world/level.dat", "empty",
+		"backup", "backup/world", "backup/world/level.dat", "backup/world/region/r.0.0.mca", "backup/world/link",
+	} {
+		assertOwner(t, filepath.Join(serverDir, name), owner)
+	}
+}
+
+func TestDir_Remove(t *testing.T) {
+	base, dir := setup(t)
+	populate(t, dir)
+
+	assert.NoError(t, dir.CheckRemove("logs/latest.log", false))
+	assert.NoError(t, dir.CheckRemove("empty", false))
+	assert.ErrorIs(t, dir.CheckRemove("world", false), ErrDirNotEmpty)
+	assert.NoError(t, dir.CheckRemove("world", true))
+	assert.ErrorIs(t, dir.CheckRemove("missing.txt", true), fs.ErrNotExist)
+	assert.ErrorIs(t, dir.CheckRemove("/", true), ErrServerRoot)
+	assert.ErrorIs(t, dir.CheckRemove("../abcd", true), ErrOutsideRoot)
+
+	require.NoError(t, dir.RemoveAll("world"))
+	_, err := dir.Stat("world/level.dat")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.ErrorIs(t, dir.RemoveAll("/"), ErrServerRoot)
+	_, err = os.Stat(filepath.Join(base, "abcd", "server.properties"))
+	assert.NoError(t, err)
+}
+
+func TestDir_RemoveAllDoesNotFollowSymlinks(t *testing.T) {
+	base, dir := setup(t)
+	require.NoError(t, dir.MkdirAll("plugins", 0o755))
+	require.NoError(t, os.Symlink("../../abcd", filepath.Join(base, "abc", "plugins", "neighbour")))
+
+	require.NoError(t, dir.RemoveAll("plugins"))
+	_, err := os.Stat(filepath.Join(base, "abcd", "server.properties"))
+	assert.NoError(t, err)
+}
+
+func TestDir_Rename(t *testing.T) {
+	_, dir := setup(t)
+	populate(t, dir)
+
+	assert.NoError(t, dir.CheckTransfer("world", "world_backup"))
+	assert.ErrorIs(t, dir.CheckTransfer("world", "logs"), fs.ErrExist)
+	assert.ErrorIs(t, dir.CheckTransfer("missing", "other"), fs.ErrNotExist)
+	assert.ErrorIs(t, dir.CheckTransfer("world", "world/region/world"), ErrIntoItself)
+	assert.ErrorIs(t, dir.CheckTransfer("world", "../abcd/world"), ErrOutsideRoot)
+	assert.ErrorIs(t, dir.CheckTransfer("/", "root"), ErrServerRoot)
+	assert.Error(t, dir.CheckTransfer("world", "nowhere/world"))
+
+	require.NoError(t, dir.Rename("/world", "/world_backup"))
+	content, err := dir.ReadFile("world_backup/level.dat")
+	require.NoError(t, err)
+	assert.Equal(t, "level", string(content))
+
+	// Renaming never replaces an existing file
+	assert.ErrorIs(t, dir.Rename("logs/latest.log", "world_backup/level.dat"), fs.ErrExist)
+	content, err = dir.ReadFile("world_backup/level.dat")
+	require.NoError(t, err)
+	assert.Equal(t, "level", string(content))
+}
+
+func TestDir_Copy(t *testing.T) {
+	base, dir := setup(t)
+	populate(t, dir)
+	require.NoError(t, os.Symlink("level.dat", filepath.Join(base, "abc", "world", "current")))
+
+	require.NoError(t, dir.Copy("world", "world_copy"))
+	for _, name := range []string{"world_copy/level.dat", "world_copy/region/r.0.0.mca", "world/level.dat"} {
+		_, err := dir.Stat(name)
+		assert.NoError(t, err, name)
+	}
+
+	// Symlinks are copied as links
+	target, err := os.Readlink(filepath.Join(base, "abc", "world_copy", "current"))
+	require.NoError(t, err)
+	assert.Equal(t, "level.dat", target)
+
+	assert.ErrorIs(t, dir.Copy("world", "world_copy"), fs.ErrExist)
+	assert.ErrorIs(t, dir.Copy("world", "world/region/copy"), ErrIntoItself)
+	assert.ErrorIs(t, dir.Copy("world", "../abcd/world"), ErrOutsideRoot)
+}
+
+func TestDir_Chmod(t *testing.T) {
+	base, dir := setup(t)
+	populate(t, dir)
+
+	require.NoError(t, dir.Chmod("logs/latest.log", 0o600))
+	info, err := os.Stat(filepath.Join(base, "abc", "logs", "latest.log"))
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+
+	// A link out of the directory cannot be used to change the host
+	require.NoError(t, os.Symlink("../abcd/server.properties", filepath.Join(base, "abc", "link")))
+	assert.ErrorIs(t, dir.Chmod("link", 0o777), ErrOutsideRoot)
+	info, err = os.Stat(filepath.Join(base, "abcd", "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o644), info.Mode().Perm())
+}
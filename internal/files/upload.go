@@ -0,0 +1,362 @@
+package files
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxChunkSize bounds one chunk of a chunked upload
+const MaxChunkSize = 64 << 20
+
+// uploadDir holds partial uploads and the files an extraction replaced
+// inside a server's data directory, so they are moved into place on the
+// same filesystem
+const uploadDir = ".agent-uploads"
+
+var (
+	// ErrUploadNotFound is returned for unknown, finished or expired uploads
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrChecksumMismatch rejects a chunk or file whose SHA-256 differs from the expected one
+	ErrChecksumMismatch = errors.New("sha256 checksum mismatch")
+	// ErrChunkTooLarge rejects a chunk beyond MaxChunkSize or the declared file size
+	ErrChunkTooLarge = errors.New("chunk exceeds the chunk size limit or the file size")
+	// ErrUploadIncomplete rejects completing an upload before every byte arrived
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+)
+
+// OffsetError rejects a chunk that does not continue the upload where it
+// stands. Offset is where the next chunk must start.
+type OffsetError struct {
+	Offset int64
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("chunk must start at offset %d", e.Offset)
+}
+
+// UploadInfo describes an upload in progress
+type UploadInfo struct {
+	ID        string    `json:"uploadId"`
+	ServerID  string    `json:"serverId"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"` // bytes received so far, where the next chunk starts
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// upload is the state of one upload session
+type upload struct {
+	mu     sync.Mutex // serializes chunks
+	info   UploadInfo
+	sha256 string // expected digest of the whole file
+	part   string // partial file inside the server directory
+	done   bool
+}
+
+// Uploads tracks chunked uploads into server data directories. An upload is
+// resumed by sending the next chunk at the offset it reached; uploads idle
+// for longer than the TTL are discarded. Sessions live in memory and do not
+// survive an agent restart.
+type Uploads struct {
+	baseDir string
+	owner   Owner // container user that finished files are handed to
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+	now     func() time.Time
+}
+
+// NewUploads creates an upload tracker for the server directories below
+// baseDir. Finished files belong to owner.
+func NewUploads(baseDir string, owner Owner, ttl time.Duration) *Uploads {
+	return &Uploads{
+		baseDir: baseDir,
+		owner:   owner,
+		ttl:     ttl,
+		uploads: make(map[string]*upload),
+		now:     time.Now,
+	}
+}
+
+// Begin starts an upload of size bytes to p in a server's data directory.
+// The finished file must have the SHA-256 digest checksum, in hex.
+func (u *Uploads) Begin(serverID, p string, size int64, checksum string) (UploadInfo, error) {
+	name, err := entry(p)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	if within(name, uploadDir) {
+		return UploadInfo{}, fmt.Errorf("%s: %w", p, ErrOutsideRoot)
+	}
+
+	u.expire()
+
+	dir, err := OpenServerDir(u.baseDir, serverID, &u.owner)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	defer dir.Close()
+
+	id := newUploadID()
+	part := path.Join(uploadDir, id+".part")
+	if err := dir.root.MkdirAll(uploadDir, 0o750); err != nil {
+		return UploadInfo{}, rootError(err)
+	}
+	// Created with the mode of write_file, which the rename keeps
+	f, err := dir.root.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return UploadInfo{}, rootError(err)
+	}
+	f.Close()
+
+	up := &upload{
+		info: UploadInfo{
+			ID:        id,
+			ServerID:  serverID,
+			Path:      p,
+			Size:      size,
+			ExpiresAt: u.now().Add(u.ttl),
+		},
+		sha256: strings.ToLower(checksum),
+		part:   part,
+	}
+
+	u.mu.Lock()
+	u.uploads[id] = up
+	u.mu.Unlock()
+	return up.info, nil
+}
+
+// Status returns the progress of an upload to serverID
+func (u *Uploads) Status(serverID, id string) (UploadInfo, error) {
+	up, err := u.lookup(serverID, id)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.info, nil
+}
+
+// WriteChunk appends the chunk read from r to an upload. The chunk must
+// start at the offset the upload reached; if checksum is set it must be the
+// hex SHA-256 digest of the chunk. A rejected chunk leaves the upload as it
+// was, so the same chunk can simply be sent again.
+func (u *Uploads) WriteChunk(serverID, id string, offset int64, r io.Reader, checksum string) (UploadInfo, error) {
+	up, err := u.lookup(serverID, id)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if up.done {
+		return UploadInfo{}, ErrUploadNotFound
+	}
+	if offset != up.info.Offset {
+		return UploadInfo{}, &OffsetError{Offset: up.info.Offset}
+	}
+
+	dir, err := OpenServerDir(u.baseDir, serverID, &u.owner)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	defer dir.Close()
+
+	f, err := dir.root.OpenFile(up.part, os.O_WRONLY, 0)
+	if err != nil {
+		return UploadInfo{}, rootError(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return UploadInfo{}, err
+	}
+
+	// Read one byte more than allowed to detect oversized chunks
+	limit := min(up.info.Size-offset, MaxChunkSize)
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, limit+1))
+	switch {
+	case err != nil:
+	case n > limit:
+		err = ErrChunkTooLarge
+	case checksum != "" && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), checksum):
+		err = fmt.Errorf("chunk at offset %d: %w", offset, ErrChecksumMismatch)
+	}
+	if err != nil {
+		// Drop whatever part of the chunk was written
+		if truncErr := f.Truncate(offset); truncErr != nil {
+			log.Printf("Failed to truncate upload %s: %v", id, truncErr)
+		}
+		return UploadInfo{}, err
+	}
+
+	up.info.Offset += n
+	up.info.ExpiresAt = u.now().Add(u.ttl)
+	return up.info, nil
+}
+
+// Complete verifies a fully received upload against its SHA-256 digest and
+// moves it to its path, replacing any file there
+func (u *Uploads) Complete(serverID, id string) (UploadInfo, error) {
+	up, err := u.lookup(serverID, id)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if up.done {
+		return UploadInfo{}, ErrUploadNotFound
+	}
+	if up.info.Offset != up.info.Size {
+		return UploadInfo{}, fmt.Errorf("%w: %d of %d bytes received", ErrUploadIncomplete, up.info.Offset, up.info.Size)
+	}
+
+	dir, err := OpenServerDir(u.baseDir, serverID, &u.owner)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	defer dir.Close()
+
+	sum, err := dir.digest(up.part, sha256.New())
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	if sum != up.sha256 {
+		// The data is wrong as a whole; start over
+		u.discard(dir, up)
+		return UploadInfo{}, fmt.Errorf("file %s: %w", up.info.Path, ErrChecksumMismatch)
+	}
+
+	name, _ := Clean(up.info.Path)
+	if info, err := dir.root.Lstat(name); err == nil && info.IsDir() {
+		return UploadInfo{}, fmt.Errorf("%s is a directory: %w", up.info.Path, fs.ErrExist)
+	}
+	if err := dir.mkdirAll(path.Dir(name), 0o755); err != nil {
+		return UploadInfo{}, err
+	}
+	if err := dir.root.Rename(up.part, name); err != nil {
+		return UploadInfo{}, rootError(err)
+	}
+	if err := dir.chown(name); err != nil {
+		return UploadInfo{}, err
+	}
+
+	up.done = true
+	u.mu.Lock()
+	delete(u.uploads, id)
+	u.mu.Unlock()
+	return up.info, nil
+}
+
+// Abort cancels an upload and removes the data received so far
+func (u *Uploads) Abort(serverID, id string) error {
+	up, err := u.lookup(serverID, id)
+	if err != nil {
+		return err
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if up.done {
+		return ErrUploadNotFound
+	}
+	dir, err := OpenServerDir(u.baseDir, serverID, &u.owner)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	u.discard(dir, up)
+	return nil
+}
+
+// lookup returns an upload of serverID; uploads of other servers are not found
+func (u *Uploads) lookup(serverID, id string) (*upload, error) {
+	u.expire()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	up, ok := u.uploads[id]
+	if !ok || up.info.ServerID != serverID {
+		return nil, fmt.Errorf("%s: %w", id, ErrUploadNotFound)
+	}
+	return up, nil
+}
+
+// discard forgets an upload and removes its partial file; up must be locked
+func (u *Uploads) discard(dir *Dir, up *upload) {
+	up.done = true
+	u.mu.Lock()
+	delete(u.uploads, up.info.ID)
+	u.mu.Unlock()
+
+	if err := dir.root.Remove(up.part); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to remove partial upload %s: %v", up.info.ID, err)
+	}
+}
+
+// expire discards uploads that have been idle for longer than the TTL
+func (u *Uploads) expire() {
+	now := u.now()
+
+	u.mu.Lock()
+	var expired []*upload
+	for _, up := range u.uploads {
+		// Uploads busy with a chunk are not idle
+		if up.mu.TryLock() {
+			if now.After(up.info.ExpiresAt) {
+				expired = append(expired, up)
+			} else {
+				up.mu.Unlock()
+			}
+		}
+	}
+	u.mu.Unlock()
+
+	for _, up := range expired {
+		log.Printf("Upload %s of %s to %s expired", up.info.ID, up.info.ServerID, up.info.Path)
+		if dir, err := OpenServerDir(u.baseDir, up.info.ServerID, &u.owner); err == nil {
+			u.discard(dir, up)
+			dir.Close()
+		}
+		up.mu.Unlock()
+	}
+}
+
+// digest returns the hex digest of the file at name
+func (d *Dir) digest(name string, h hash.Hash) (string, error) {
+	f, err := d.root.Open(name)
+	if err != nil {
+		return "", rootError(err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newUploadID returns a random upload session ID
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,174 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sum(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+func TestUploads_ChunkedResume(t *testing.T) {
+	base := t.TempDir()
+	uploads := NewUploads(base, Owner{}, time.Hour)
+	data := "hello, chunked world"
+
+	info, err := uploads.Begin("abc", "/world/level.dat", int64(len(data)), sum(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Offset)
+
+	info, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader(data[:5]), sum(data[:5]))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Offset)
+
+	// A client that lost track resends from the wrong offset and is told where to resume
+	_, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader(data[:5]), "")
+	var offsetErr *OffsetError
+	require.True(t, errors.As(err, &offsetErr))
+	assert.Equal(t, int64(5), offsetErr.Offset)
+
+	status, err := uploads.Status("abc", info.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), status.Offset)
+
+	_, err = uploads.Complete("abc", info.ID)
+	assert.ErrorIs(t, err, ErrUploadIncomplete)
+
+	info, err = uploads.WriteChunk("abc", info.ID, 5, strings.NewReader(data[5:]), "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Offset)
+
+	_, err = uploads.Complete("abc", info.ID)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(base, "abc", "world", "level.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, data, string(content))
+
+	// The session and its partial file are gone
+	_, err = uploads.Status("abc", info.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	entries, err := os.ReadDir(filepath.Join(base, "abc", uploadDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestUploads_ChunkChecksum(t *testing.T) {
+	uploads := NewUploads(t.TempDir(), Owner{}, time.Hour)
+	info, err := uploads.Begin("abc", "a.bin", 10, sum("0123456789"))
+	require.NoError(t, err)
+
+	// A corrupted chunk is rejected and leaves the upload where it was
+	_, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader("01234"), sum("01235"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	info, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader("01234"), sum("01234"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Offset)
+}
+
+func TestUploads_FileChecksum(t *testing.T) {
+	base := t.TempDir()
+	uploads := NewUploads(base, Owner{}, time.Hour)
+	info, err := uploads.Begin("abc", "a.bin", 4, sum("abcd"))
+	require.NoError(t, err)
+
+	_, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader("abce"), "")
+	require.NoError(t, err)
+
+	_, err = uploads.Complete("abc", info.ID)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, err = os.Stat(filepath.Join(base, "abc", "a.bin"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = uploads.Status("abc", info.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestUploads_ChunkTooLarge(t *testing.T) {
+	uploads := NewUploads(t.TempDir(), Owner{}, time.Hour)
+	info, err := uploads.Begin("abc", "a.bin", 4, sum("abcd"))
+	require.NoError(t, err)
+
+	_, err = uploads.WriteChunk("abc", info.ID, 0, bytes.NewReader([]byte("abcde")), "")
+	assert.ErrorIs(t, err, ErrChunkTooLarge)
+
+	status, err := uploads.Status("abc", info.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), status.Offset)
+}
+
+func TestUploads_Confinement(t *testing.T) {
+	uploads := NewUploads(t.TempDir(), Owner{}, time.Hour)
+
+	for _, p := range []string{"../abcd/evil", "/", ".agent-uploads/x.part"} {
+		_, err := uploads.Begin("abc", p, 1, sum("x"))
+		assert.Error(t, err, p)
+	}
+
+	// Uploads belong to their server
+	info, err := uploads.Begin("abc", "a.bin", 1, sum("x"))
+	require.NoError(t, err)
+	_, err = uploads.WriteChunk("abcd", info.ID, 0, strings.NewReader("x"), "")
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestUploads_AbortAndExpire(t *testing.T) {
+	base := t.TempDir()
+	uploads := NewUploads(base, Owner{}, time.Minute)
+	now := time.Now()
+	uploads.now = func() time.Time { return now }
+
+	aborted, err := uploads.Begin("abc", "a.bin", 1, sum("x"))
+	require.NoError(t, err)
+	require.NoError(t, uploads.Abort("abc", aborted.ID))
+	_, err = uploads.Status("abc", aborted.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+
+	idle, err := uploads.Begin("abc", "b.bin", 1, sum("x"))
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = uploads.Status("abc", idle.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+
+	entries, err := os.ReadDir(filepath.Join(base, "abc", uploadDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestUploads_FinishedFileBelongsToOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file owners requires root")
+	}
+	base := t.TempDir()
+	owner := Owner{UID: 1234, GID: 5678}
+	uploads := NewUploads(base, owner, time.Hour)
+	data := "mod jar"
+
+	info, err := uploads.Begin("abc", "mods/new/mod.jar", int64(len(data)), sum(data))
+	require.NoError(t, err)
+	_, err = uploads.WriteChunk("abc", info.ID, 0, strings.NewReader(data), "")
+	require.NoError(t, err)
+	_, err = uploads.Complete("abc", info.ID)
+	require.NoError(t, err)
+
+	for _, name := range []string{"mods", "mods/new", "mods/new/mod.jar"} {
+		assertOwner(t, filepath.Join(base, "abc", name), owner)
+	}
+	stat, err := os.Stat(filepath.Join(base, "abc", "mods/new/mod.jar"))
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o644), stat.Mode().Perm())
+}
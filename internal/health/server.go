@@ -3,6 +3,7 @@ package health
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -22,6 +23,7 @@ type Server struct {
 	nodeID    string
 	version   string
 	connected bool
+	mu        sync.RWMutex
 }
 
 // NewServer creates a new health check server
@@ -36,9 +38,18 @@ func NewServer(nodeID, version string) *Server {
 
 // SetConnectionStatus updates the connection status
 func (s *Server) SetConnectionStatus(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.connected = connected
 }
 
+// isConnected returns the current connection status
+func (s *Server) isConnected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connected
+}
+
 // Handler returns the HTTP handler for health checks
 func (s *Server) Handler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -47,16 +58,18 @@ func (s *Server) Handler() http.HandlerFunc {
 			return
 		}
 
+		connected := s.isConnected()
+
 		status := HealthStatus{
 			Status:    "healthy",
 			Timestamp: time.Now(),
 			Version:   s.version,
 			NodeID:    s.nodeID,
 			Uptime:    time.Since(s.startTime).String(),
-			Connected: s.connected,
+			Connected: connected,
 		}
 
-		if !s.connected {
+		if !connected {
 			status.Status = "degraded"
 			w.WriteHeader(http.StatusServiceUnavailable)
 		}
@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// maxFrameHeader bounds the JSON header of a binary frame
+const maxFrameHeader = 64 << 10
+
+// UploadChunkHeader starts a binary WebSocket frame carrying one chunk of a
+// chunked upload. The frame is laid out as a 4-byte big-endian header
+// length, the JSON header and the chunk data.
+type UploadChunkHeader struct {
+	ID       string `json:"id"`               // command ID the response answers
+	UploadID string `json:"uploadId"`         // session from upload_begin
+	ServerID string `json:"serverId"`         // server the upload belongs to
+	Offset   int64  `json:"offset"`           // where the chunk starts in the file
+	SHA256   string `json:"sha256,omitempty"` // hex digest of the chunk, verified if set
+}
+
+// ReadUploadChunkHeader reads the header of a binary upload frame, leaving
+// r at the start of the chunk data
+func ReadUploadChunkHeader(r io.Reader) (*UploadChunkHeader, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, fmt.Errorf("failed to read frame header length: %w", err)
+	}
+	if size == 0 || size > maxFrameHeader {
+		return nil, fmt.Errorf("invalid frame header length %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read frame header: %w", err)
+	}
+
+	var header UploadChunkHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid frame header: %w", err)
+	}
+	return &header, nil
+}
+
+// AppendUploadChunkHeader appends the encoded header of a binary upload
+// frame to b; the chunk data follows it
+func AppendUploadChunkHeader(b []byte, header *UploadChunkHeader) ([]byte, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...), nil
+}
@@ -1,7 +1,9 @@
 package messages
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"testing"
 	"time"
 
@@ -57,10 +59,7 @@ func TestPanelCommand(t *testing.T) {
 				AgentID:   "test-agent",
 				Action:    "stop_server",
 				ServerID:  "server_456",
-				Payload: map[string]interface{}{
-					"signal":  "SIGTERM",
-					"timeout": float64(30),
-				},
+				Payload:   json.RawMessage(`{"signal": "SIGTERM", "timeout": 30}`),
 			},
 			wantErr: false,
 		},
@@ -88,7 +87,7 @@ func TestPanelCommand(t *testing.T) {
 			assert.Equal(t, tt.expected.ServerID, cmd.ServerID)
 
 			if tt.expected.Payload != nil {
-				assert.Equal(t, tt.expected.Payload, cmd.Payload)
+				assert.JSONEq(t, string(tt.expected.Payload), string(cmd.Payload))
 			}
 		})
 	}
@@ -276,3 +275,24 @@ func TestSystemInfoData(t *testing.T) {
 	assert.Equal(t, sysInfo.Memory, parsed.Memory)
 	assert.Equal(t, sysInfo.Capabilities, parsed.Capabilities)
 }
+
+func TestUploadChunkFrame(t *testing.T) {
+	header := &UploadChunkHeader{ID: "cmd_1", UploadID: "u1", ServerID: "s1", Offset: 1024, SHA256: "abc"}
+	frame, err := AppendUploadChunkHeader(nil, header)
+	require.NoError(t, err)
+	frame = append(frame, "chunk data"...)
+
+	r := bytes.NewReader(frame)
+	got, err := ReadUploadChunkHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, header, got)
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "chunk data", string(rest))
+
+	_, err = ReadUploadChunkHeader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.Error(t, err)
+	_, err = ReadUploadChunkHeader(bytes.NewReader([]byte{0, 0, 0, 2, '{'}))
+	assert.Error(t, err)
+}
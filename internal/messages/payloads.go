@@ -0,0 +1,405 @@
+package messages
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Payloads of the actions accepted over both the WebSocket (PanelCommand)
+// and HTTP (/api/command). The server an action applies to is given by the
+// command itself, not by its payload.
+
+// EmptyPayload is the payload of actions that take no parameters
+type EmptyPayload struct{}
+
+// StopServerPayload holds the optional stop options of stop_server and restart_server
+type StopServerPayload struct {
+	Signal  string  `json:"signal,omitempty"`  // stop signal for the second stage
+	Timeout float64 `json:"timeout,omitempty"` // grace period per stage in seconds
+	Command string  `json:"command,omitempty"` // console command for the first stage
+}
+
+// KillServerPayload holds the signal of kill_server
+type KillServerPayload struct {
+	Signal string `json:"signal,omitempty"` // SIGKILL if empty
+}
+
+// DeleteServerPayload holds the options of delete_server
+type DeleteServerPayload struct {
+	PurgeData bool `json:"purgeData,omitempty"` // also remove the server's data directory
+}
+
+// SendCommandPayload holds the console command of send_command
+type SendCommandPayload struct {
+	Command string `json:"command"`
+}
+
+// ContainerPayload names the container of the legacy docker.* actions
+type ContainerPayload struct {
+	ContainerID string `json:"containerId"`
+}
+
+// DirectoryPayload names a directory of list_files; the data directory itself if empty
+type DirectoryPayload struct {
+	Path string `json:"path,omitempty"`
+}
+
+// FilePayload names a path inside a server's data directory
+type FilePayload struct {
+	Path string `json:"path"`
+}
+
+// FileContentPayload holds a file to write
+type FileContentPayload struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// UploadFilePayload holds a file to upload as base64 content
+type UploadFilePayload struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// DeleteFilesPayload holds the paths of delete_files
+type DeleteFilesPayload struct {
+	Paths     []string `json:"paths"`
+	Recursive bool     `json:"recursive,omitempty"` // also delete non-empty directories
+	DryRun    bool     `json:"dryRun,omitempty"`    // check every path without deleting
+}
+
+// FileTransferPayload holds the source and destination of rename_file and copy_file
+type FileTransferPayload struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+// MoveFilesPayload holds the paths of move_files and the directory they move into
+type MoveFilesPayload struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`
+	DryRun      bool     `json:"dryRun,omitempty"`
+}
+
+// CreateDirectoryPayload holds the directory of create_directory
+type CreateDirectoryPayload struct {
+	Path   string `json:"path"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+// ChmodFilePayload holds the file and octal permissions of chmod_file, e.g. "0644"
+type ChmodFilePayload struct {
+	Path   string `json:"path"`
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+// UploadBeginPayload starts a chunked upload of size bytes to path
+type UploadBeginPayload struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // hex digest of the whole file
+}
+
+// UploadPayload names an upload session
+type UploadPayload struct {
+	UploadID string `json:"uploadId"`
+}
+
+// SignFileURLPayload asks for a signed URL granting downloads of path, or
+// the chunk uploads of an upload session, for ExpiresIn seconds
+type SignFileURLPayload struct {
+	Operation string `json:"operation"` // download or upload
+	Path      string `json:"path,omitempty"`
+	UploadID  string `json:"uploadId,omitempty"`
+	ExpiresIn int    `json:"expiresIn,omitempty"` // defaults to SIGNED_URL_TTL
+}
+
+// CompressFilesPayload holds the paths of compress_files and the archive
+// they are written to. Format is tar.gz, zip or tar.zst; if empty it is taken
+// from the extension of Destination.
+type CompressFilesPayload struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`
+	Format      string   `json:"format,omitempty"`
+}
+
+// DecompressFilePayload holds the archive of decompress_file and the
+// directory it is extracted into, by default the one holding the archive
+type DecompressFilePayload struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+	Format      string `json:"format,omitempty"`
+}
+
+// ModPayload identifies a mod to install or uninstall
+type ModPayload struct {
+	ModID   string `json:"modId"`
+	ModURL  string `json:"modUrl,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// maxPathLength bounds the paths accepted in file payloads
+const maxPathLength = 4096
+
+// maxCommandLength bounds a console command
+const maxCommandLength = 4096
+
+// maxBatchPaths bounds the paths of one batch file operation
+const maxBatchPaths = 1000
+
+// maxStopTimeout bounds the grace period of a stop stage, in seconds
+const maxStopTimeout = 3600
+
+var (
+	sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	signalPattern = regexp.MustCompile(`^([A-Z][A-Z0-9+-]*|[0-9]+)$`)
+	modIDPattern  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// oneOf reports whether value is one of the allowed values
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// signal records an error unless value is empty or a signal name or number
+func (v *validation) signal(field, value string) {
+	v.check(value == "" || signalPattern.MatchString(value), field, "must be a signal name such as SIGTERM or a signal number")
+}
+
+// path records an error if a path is malformed; required paths must be set
+func (v *validation) path(field, value string, required bool) {
+	if required {
+		v.required(field, value)
+	}
+	v.check(!strings.ContainsRune(value, 0), field, "must not contain NUL bytes")
+	v.check(len(value) <= maxPathLength, field, fmt.Sprintf("must be at most %d characters", maxPathLength))
+}
+
+// Validate checks the stop options
+func (p *StopServerPayload) Validate() []FieldError {
+	var v validation
+	v.signal("signal", p.Signal)
+	v.check(p.Timeout >= 0 && p.Timeout <= maxStopTimeout, "timeout", fmt.Sprintf("must be between 0 and %d seconds", maxStopTimeout))
+	v.check(!strings.ContainsAny(p.Command, "\r\n"), "command", "must be a single line")
+	return v.errs
+}
+
+// Validate checks the kill signal
+func (p *KillServerPayload) Validate() []FieldError {
+	var v validation
+	v.signal("signal", p.Signal)
+	return v.errs
+}
+
+// Validate checks the console command
+func (p *SendCommandPayload) Validate() []FieldError {
+	var v validation
+	v.required("command", p.Command)
+	v.check(!strings.ContainsAny(p.Command, "\r\n"), "command", "must be a single line")
+	v.check(len(p.Command) <= maxCommandLength, "command", fmt.Sprintf("must be at most %d characters", maxCommandLength))
+	return v.errs
+}
+
+// Validate checks that a container is named
+func (p *ContainerPayload) Validate() []FieldError {
+	var v validation
+	v.required("containerId", p.ContainerID)
+	return v.errs
+}
+
+// Validate checks the directory path
+func (p *DirectoryPayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, false)
+	return v.errs
+}
+
+// Validate checks the file path
+func (p *FilePayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	return v.errs
+}
+
+// Validate checks the file path
+func (p *FileContentPayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	return v.errs
+}
+
+// Validate checks the file path and that the content is base64
+func (p *UploadFilePayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	_, err := base64.StdEncoding.DecodeString(p.Content)
+	v.check(err == nil, "content", "must be base64 encoded")
+	return v.errs
+}
+
+// paths records errors for a missing, oversized or malformed list of paths
+func (v *validation) paths(field string, paths []string) {
+	v.check(len(paths) > 0, field, "must list at least one path")
+	v.check(len(paths) <= maxBatchPaths, field, fmt.Sprintf("must list at most %d paths", maxBatchPaths))
+	for i, p := range paths {
+		v.path(fmt.Sprintf("%s[%d]", field, i), p, true)
+	}
+}
+
+// Validate checks the paths to delete
+func (p *DeleteFilesPayload) Validate() []FieldError {
+	var v validation
+	v.paths("paths", p.Paths)
+	return v.errs
+}
+
+// Validate checks the source and destination
+func (p *FileTransferPayload) Validate() []FieldError {
+	var v validation
+	v.path("from", p.From, true)
+	v.path("to", p.To, true)
+	return v.errs
+}
+
+// Validate checks the paths to move and their destination
+func (p *MoveFilesPayload) Validate() []FieldError {
+	var v validation
+	v.paths("paths", p.Paths)
+	v.path("destination", p.Destination, true)
+	return v.errs
+}
+
+// Validate checks the directory path
+func (p *CreateDirectoryPayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	return v.errs
+}
+
+// Validate checks the file path and permissions
+func (p *ChmodFilePayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	_, err := p.FileMode()
+	v.check(err == nil, "mode", "must be octal permissions between 0000 and 0777, e.g. 0644")
+	return v.errs
+}
+
+// FileMode parses the octal permissions of the payload
+func (p *ChmodFilePayload) FileMode() (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(p.Mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode > 0o777 {
+		return 0, fmt.Errorf("mode %s has bits beyond 0777", p.Mode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// Validate checks the destination, size and digest of the upload
+func (p *UploadBeginPayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	v.check(p.Size >= 0, "size", "must not be negative")
+	v.check(sha256Pattern.MatchString(p.SHA256), "sha256", "must be a hex SHA-256 digest")
+	return v.errs
+}
+
+// Validate checks that an upload is named
+func (p *UploadPayload) Validate() []FieldError {
+	var v validation
+	v.required("uploadId", p.UploadID)
+	return v.errs
+}
+
+// Validate checks the operation and what it is granted on
+func (p *SignFileURLPayload) Validate() []FieldError {
+	var v validation
+	v.check(oneOf(p.Operation, "download", "upload"), "operation", "must be download or upload")
+	switch p.Operation {
+	case "download":
+		v.path("path", p.Path, true)
+	case "upload":
+		v.required("uploadId", p.UploadID)
+	}
+	v.check(p.ExpiresIn >= 0, "expiresIn", "must not be negative")
+	return v.errs
+}
+
+// Validate checks the paths to compress, the archive and its format
+func (p *CompressFilesPayload) Validate() []FieldError {
+	var v validation
+	v.paths("paths", p.Paths)
+	v.path("destination", p.Destination, true)
+	v.check(oneOf(p.Format, "", "tar.gz", "zip", "tar.zst"), "format", "must be tar.gz, zip or tar.zst")
+	return v.errs
+}
+
+// Validate checks the archive, its destination and format
+func (p *DecompressFilePayload) Validate() []FieldError {
+	var v validation
+	v.path("path", p.Path, true)
+	v.path("destination", p.Destination, false)
+	v.check(oneOf(p.Format, "", "tar.gz", "zip", "tar.zst"), "format", "must be tar.gz, zip or tar.zst")
+	return v.errs
+}
+
+// Validate checks the mod ID and download URL
+func (p *ModPayload) Validate() []FieldError {
+	var v validation
+	v.required("modId", p.ModID)
+	v.check(p.ModID == "" || modIDPattern.MatchString(p.ModID), "modId", "may only contain letters, digits, '.', '_' and '-'")
+	if p.ModURL != "" {
+		u, err := url.Parse(p.ModURL)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "modUrl", "must be an http or https URL")
+	}
+	return v.errs
+}
+
+// Validate checks the server definition of create_server
+func (d *ServerCreateData) Validate() []FieldError {
+	var v validation
+	v.required("image", d.Image)
+	v.check(d.ReadyTimeout >= 0, "readyTimeout", "must not be negative")
+	v.check(oneOf(d.PullPolicy, "", "missing", "always", "never"), "pullPolicy", "must be missing, always or never")
+
+	for i, port := range d.Ports {
+		field := fmt.Sprintf("ports[%d].", i)
+		v.check(port.Internal >= 1 && port.Internal <= 65535, field+"internal", "must be between 1 and 65535")
+		v.check(port.External >= 1 && port.External <= 65535, field+"external", "must be between 1 and 65535")
+		v.check(port.InternalEnd == 0 || port.InternalEnd >= port.Internal && port.InternalEnd <= 65535, field+"internalEnd", "must be between internal and 65535")
+		v.check(port.ExternalEnd == 0 || port.ExternalEnd >= port.External && port.ExternalEnd <= 65535, field+"externalEnd", "must be between external and 65535")
+		v.check(port.Protocol == "" || port.Protocol == "tcp" || port.Protocol == "udp", field+"protocol", "must be tcp or udp")
+		v.check(port.HostIP == "" || net.ParseIP(port.HostIP) != nil, field+"hostIp", "must be an IP address")
+	}
+
+	limits := d.Limits
+	v.check(limits.Memory >= 0, "limits.memory", "must not be negative")
+	v.check(limits.Swap >= -1, "limits.swap", "must be -1 (unlimited) or more")
+	v.check(limits.Disk >= 0, "limits.disk", "must not be negative")
+	v.check(limits.IO == 0 || limits.IO >= 10 && limits.IO <= 1000, "limits.io", "must be 0 or between 10 and 1000")
+	v.check(limits.CPU >= 0, "limits.cpu", "must not be negative")
+	v.check(limits.CPULimit >= 0, "limits.cpuLimit", "must not be negative")
+
+	if rp := d.RestartPolicy; rp != nil {
+		v.check(oneOf(rp.Policy, "", "never", "on-crash", "always"), "restartPolicy.policy", "must be never, on-crash or always")
+		v.check(rp.MaxRestarts == nil || *rp.MaxRestarts >= 0, "restartPolicy.maxRestarts", "must not be negative")
+		v.check(rp.Window >= 0, "restartPolicy.window", "must not be negative")
+		v.check(rp.Backoff >= 0, "restartPolicy.backoff", "must not be negative")
+	}
+	return v.errs
+}
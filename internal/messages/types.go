@@ -69,22 +69,49 @@ type ServerCreateData struct {
 	Environment map[string]string `json:"environment"`
 	Limits      ResourceLimits    `json:"limits"`
 	Ports       []PortMapping     `json:"ports"`
+
+	StopCommand   string         `json:"stopCommand,omitempty"`   // console command for a graceful stop
+	RestartPolicy *RestartPolicy `json:"restartPolicy,omitempty"` // crash restarts, agent defaults if omitted
+	DonePattern   string         `json:"donePattern,omitempty"`   // console regex marking the server as ready
+	ReadyTimeout  int            `json:"readyTimeout,omitempty"`  // seconds to wait for donePattern
+	PullPolicy    string         `json:"pullPolicy,omitempty"`    // missing (default), always or never
+	RegistryAuth  *RegistryAuth  `json:"registryAuth,omitempty"`  // private registry credentials
+}
+
+// RestartPolicy controls automatic restarts after a crash
+type RestartPolicy struct {
+	Policy      string `json:"policy,omitempty"`      // never, on-crash or always
+	MaxRestarts *int   `json:"maxRestarts,omitempty"` // restarts allowed within window; 0 never restarts
+	Window      int    `json:"window,omitempty"`      // in seconds
+	Backoff     int    `json:"backoff,omitempty"`     // initial delay in seconds, doubled per attempt
+}
+
+// RegistryAuth holds credentials for a private image registry
+type RegistryAuth struct {
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // ResourceLimits defines resource constraints
 type ResourceLimits struct {
-	Memory int64 `json:"memory"` // in bytes
-	Swap   int64 `json:"swap"`   // in bytes
-	Disk   int64 `json:"disk"`   // in bytes
-	IO     int64 `json:"io"`     // IO weight
-	CPU    int64 `json:"cpu"`    // CPU shares
+	Memory   int64   `json:"memory"`   // in bytes
+	Swap     int64   `json:"swap"`     // in bytes, -1 unlimited
+	Disk     int64   `json:"disk"`     // in bytes
+	IO       int64   `json:"io"`       // IO weight
+	CPU      int64   `json:"cpu"`      // CPU shares
+	CPULimit float64 `json:"cpuLimit"` // percent of one core
+	CPUSet   string  `json:"cpuset"`   // pinned CPUs, e.g. "0-3"
 }
 
 // PortMapping defines port forwarding
 type PortMapping struct {
-	Internal int    `json:"internal"`
-	External int    `json:"external"`
-	Protocol string `json:"protocol"` // tcp/udp
+	Internal    int    `json:"internal"`
+	External    int    `json:"external"`
+	Protocol    string `json:"protocol"`              // tcp/udp
+	HostIP      string `json:"hostIp,omitempty"`      // bind address, all interfaces if empty
+	InternalEnd int    `json:"internalEnd,omitempty"` // last container port of a range
+	ExternalEnd int    `json:"externalEnd,omitempty"` // last host port of a range
 }
 
 // ServerStatusData represents server status information
@@ -97,13 +124,19 @@ type ServerStatusData struct {
 
 // ServerStatsData represents server performance statistics
 type ServerStatsData struct {
-	CPU     float64 `json:"cpu"`    // CPU usage percentage
-	Memory  int64   `json:"memory"` // Memory usage in bytes
-	Disk    int64   `json:"disk"`   // Disk usage in bytes
-	Network struct {
+	CPU         float64 `json:"cpu"`         // CPU usage percentage
+	Memory      int64   `json:"memory"`      // Memory usage in bytes
+	MemoryLimit int64   `json:"memoryLimit"` // Memory limit in bytes
+	Disk        int64   `json:"disk"`        // Disk usage in bytes
+	Network     struct {
 		In  int64 `json:"in"`  // Network bytes in
 		Out int64 `json:"out"` // Network bytes out
 	} `json:"network"`
+	BlockIO struct {
+		Read  int64 `json:"read"`  // Block device bytes read
+		Write int64 `json:"write"` // Block device bytes written
+	} `json:"blockIo"`
+	PIDs      uint64    `json:"pids"`    // Number of processes
 	Players   int       `json:"players"` // Number of players (if applicable)
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -177,19 +210,19 @@ func (m *Message) UnmarshalData(v interface{}) error {
 
 // PanelCommand represents the new Panel Issue #27 command format
 type PanelCommand struct {
-	ID        string                 `json:"id"`
-	Type      string                 `json:"type"`      // Always "command"
-	Timestamp string                 `json:"timestamp"` // ISO 8601 timestamp
-	AgentID   string                 `json:"agentId"`
-	Action    string                 `json:"action"`    // start_server, stop_server, etc.
-	ServerID  string                 `json:"serverId,omitempty"`
-	Payload   map[string]interface{} `json:"payload,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`      // Always "command"
+	Timestamp string          `json:"timestamp"` // ISO 8601 timestamp
+	AgentID   string          `json:"agentId"`
+	Action    string          `json:"action"` // start_server, stop_server, etc.
+	ServerID  string          `json:"serverId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"` // decoded by the action into its payload type
 }
 
 // AgentResponse represents the standardized response format
 type AgentResponse struct {
 	ID        string                 `json:"id"`
-	Type      string                 `json:"type"`    // Always "response"
+	Type      string                 `json:"type"` // Always "response"
 	Timestamp string                 `json:"timestamp"`
 	Success   bool                   `json:"success"`
 	Message   string                 `json:"message,omitempty"`
@@ -199,18 +232,24 @@ type AgentResponse struct {
 
 // ErrorInfo represents structured error information
 type ErrorInfo struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"` // every invalid field of a VALIDATION_ERROR
 }
 
 // AgentEvent represents events sent from Agent to Panel
 type AgentEvent struct {
-	Type      string                 `json:"type"`      // Always "event"
+	Type      string                 `json:"type"` // Always "event"
 	Timestamp string                 `json:"timestamp"`
-	Event     string                 `json:"event"`     // server_status_changed, server_log, etc.
+	Event     string                 `json:"event"` // server_status_changed, server_log, etc.
 	Data      map[string]interface{} `json:"data"`
 }
 
+// EventSink receives events destined for the Panel
+type EventSink interface {
+	SendEvent(event string, data map[string]interface{})
+}
+
 // ParsePanelCommand parses a PanelCommand from JSON bytes
 func ParsePanelCommand(data []byte) (*PanelCommand, error) {
 	var cmd PanelCommand
@@ -0,0 +1,172 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldError describes one invalid payload field
+type FieldError struct {
+	Field   string `json:"field"` // JSON name, e.g. path or ports[0].internal
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a payload
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid payload: " + strings.Join(parts, "; ")
+}
+
+// Validator is implemented by payloads that check their own fields
+type Validator interface {
+	Validate() []FieldError
+}
+
+// DecodePayload strictly decodes an action payload into the struct v
+// points to. Unknown fields, values of the wrong type and fields failing
+// v's own validation are all reported in a single *ValidationError. An
+// empty payload decodes as {}.
+func DecodePayload(data json.RawMessage, v interface{}) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		data = []byte("{}")
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return &ValidationError{Fields: []FieldError{{Field: "payload", Message: "must be a JSON object"}}}
+	}
+
+	target := reflect.ValueOf(v).Elem()
+	fields := jsonFields(target.Type())
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []FieldError
+	failed := make(map[string]bool)
+	for _, name := range names {
+		index, ok := fields[name]
+		if !ok {
+			errs = append(errs, FieldError{Field: name, Message: "unknown field"})
+			continue
+		}
+		if err := decodeStrict(raw[name], target.Field(index).Addr().Interface()); err != nil {
+			errs = append(errs, decodeError(name, err))
+			failed[name] = true
+		}
+	}
+
+	if validator, ok := v.(Validator); ok {
+		for _, fieldErr := range validator.Validate() {
+			// A field that could not be decoded is already reported
+			if !failed[topLevel(fieldErr.Field)] {
+				errs = append(errs, fieldErr)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// decodeStrict decodes a JSON value, rejecting unknown fields of nested objects
+func decodeStrict(data json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// decodeError converts a decoding error of a field to a field error
+func decodeError(name string, err error) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		field := name
+		if typeErr.Field != "" {
+			field += "." + typeErr.Field
+		}
+		return FieldError{Field: field, Message: fmt.Sprintf("must be %s, not %s", jsonType(typeErr.Type), typeErr.Value)}
+	}
+	return FieldError{Field: name, Message: strings.TrimPrefix(err.Error(), "json: ")}
+}
+
+// jsonType names the JSON type expected for a Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// jsonFields maps the JSON names of a struct's fields to their index
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// topLevel returns the top-level field of a field path such as ports[0].internal
+func topLevel(field string) string {
+	if i := strings.IndexAny(field, ".["); i >= 0 {
+		return field[:i]
+	}
+	return field
+}
+
+// validation collects field errors while a payload checks itself
+type validation struct {
+	errs []FieldError
+}
+
+// check records message for field unless ok
+func (v *validation) check(ok bool, field, message string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Message: message})
+	}
+}
+
+// required records an error if value is empty
+func (v *validation) required(field, value string) {
+	v.check(strings.TrimSpace(value) != "", field, "is required")
+}
@@ -0,0 +1,117 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldErrors decodes payload into v and returns the reported field errors
+func fieldErrors(t *testing.T, payload string, v interface{}) []FieldError {
+	t.Helper()
+	err := DecodePayload(json.RawMessage(payload), v)
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+	return validationErr.Fields
+}
+
+func TestDecodePayload(t *testing.T) {
+	var p StopServerPayload
+	require.NoError(t, DecodePayload(json.RawMessage(`{"signal":"SIGINT","timeout":30,"command":"stop"}`), &p))
+	assert.Equal(t, StopServerPayload{Signal: "SIGINT", Timeout: 30, Command: "stop"}, p)
+
+	// An empty payload is an empty object
+	var empty EmptyPayload
+	assert.NoError(t, DecodePayload(nil, &empty))
+	assert.NoError(t, DecodePayload(json.RawMessage("null"), &empty))
+
+	// Required fields are still checked for an empty payload
+	assert.Equal(t, []FieldError{{Field: "path", Message: "is required"}}, fieldErrors(t, "", &FilePayload{}))
+}
+
+func TestDecodePayload_ReportsEveryField(t *testing.T) {
+	fields := fieldErrors(t, `{"timeout":"soon","signal":"term","force":true}`, &StopServerPayload{})
+
+	assert.Equal(t, []FieldError{
+		{Field: "force", Message: "unknown field"},
+		{Field: "timeout", Message: "must be a number, not string"},
+		{Field: "signal", Message: "must be a signal name such as SIGTERM or a signal number"},
+	}, fields)
+}
+
+func TestDecodePayload_NotAnObject(t *testing.T) {
+	fields := fieldErrors(t, `["path"]`, &FilePayload{})
+	assert.Equal(t, []FieldError{{Field: "payload", Message: "must be a JSON object"}}, fields)
+}
+
+func TestPayloadValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		v       interface{}
+		fields  []string
+	}{
+		{"stop defaults", `{}`, &StopServerPayload{}, nil},
+		{"stop timeout range", `{"timeout":-1}`, &StopServerPayload{}, []string{"timeout"}},
+		{"kill by number", `{"signal":"9"}`, &KillServerPayload{}, nil},
+		{"command required", `{}`, &SendCommandPayload{}, []string{"command"}},
+		{"command single line", `{"command":"say hi\nop me"}`, &SendCommandPayload{}, []string{"command"}},
+		{"container required", `{"containerId":""}`, &ContainerPayload{}, []string{"containerId"}},
+		{"directory optional", `{}`, &DirectoryPayload{}, nil},
+		{"path with NUL", `{"path":"a\u0000b"}`, &FilePayload{}, []string{"path"}},
+		{"upload not base64", `{"path":"a.txt","content":"not base64!"}`, &UploadFilePayload{}, []string{"content"}},
+		{"delete needs paths", `{"paths":[]}`, &DeleteFilesPayload{}, []string{"paths"}},
+		{"delete empty path", `{"paths":["logs/latest.log",""],"recursive":true}`, &DeleteFilesPayload{}, []string{"paths[1]"}},
+		{"move", `{"paths":["world"],"destination":"/backups"}`, &MoveFilesPayload{}, nil},
+		{"rename needs both", `{"from":"world"}`, &FileTransferPayload{}, []string{"to"}},
+		{"chmod octal", `{"path":"start.sh","mode":"0755"}`, &ChmodFilePayload{}, nil},
+		{"chmod setuid", `{"path":"start.sh","mode":"4755"}`, &ChmodFilePayload{}, []string{"mode"}},
+		{"chmod not octal", `{"path":"start.sh","mode":"rwxr-xr-x"}`, &ChmodFilePayload{}, []string{"mode"}},
+		{"sign download", `{"operation":"download","path":"/world.zip","expiresIn":300}`, &SignFileURLPayload{}, nil},
+		{"sign upload needs id", `{"operation":"upload","expiresIn":-1}`, &SignFileURLPayload{}, []string{"uploadId", "expiresIn"}},
+		{"sign operation", `{"operation":"delete","path":"/world.zip"}`, &SignFileURLPayload{}, []string{"operation"}},
+		{"compress", `{"paths":["world"],"destination":"backups/world.tar.zst"}`, &CompressFilesPayload{}, nil},
+		{"compress format", `{"paths":["world"],"destination":"world.rar","format":"rar"}`, &CompressFilesPayload{}, []string{"format"}},
+		{"decompress needs path", `{"destination":"modpack"}`, &DecompressFilePayload{}, []string{"path"}},
+		{"mod id format", `{"modId":"../evil"}`, &ModPayload{}, []string{"modId"}},
+		{"mod url scheme", `{"modId":"worldedit","modUrl":"file:///etc/passwd"}`, &ModPayload{}, []string{"modUrl"}},
+		{"mod ok", `{"modId":"worldedit-7.2","modUrl":"https://example.com/we.jar"}`, &ModPayload{}, nil},
+		{
+			"create server",
+			`{"image":"itzg/minecraft-server","ports":[{"internal":25565,"external":25565,"protocol":"tcp"}],"limits":{"memory":1073741824,"swap":-1}}`,
+			&ServerCreateData{},
+			nil,
+		},
+		{
+			"create server fields",
+			`{"ports":[{"internal":0,"external":70000,"protocol":"sctp"}],"limits":{"io":5},"pullPolicy":"sometimes","restartPolicy":{"policy":"maybe"}}`,
+			&ServerCreateData{},
+			[]string{"image", "pullPolicy", "ports[0].internal", "ports[0].external", "ports[0].protocol", "limits.io", "restartPolicy.policy"},
+		},
+		{"create server nested unknown field", `{"image":"alpine","limits":{"ram":1}}`, &ServerCreateData{}, []string{"limits"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, f := range fieldErrors(t, tt.payload, tt.v) {
+				got = append(got, f.Field)
+			}
+			assert.Equal(t, tt.fields, got)
+		})
+	}
+}
+
+func TestValidationError_Error(t *testing.T) {
+	err := &ValidationError{Fields: []FieldError{
+		{Field: "path", Message: "is required"},
+		{Field: "mode", Message: "unknown field"},
+	}}
+	assert.Equal(t, "invalid payload: path: is required; mode: unknown field", err.Error())
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+// syncInterval is how often the set of streamed containers is re-checked in
+// case a start or exit event was missed
+const syncInterval = 15 * time.Second
+
+// StatsSource is the subset of docker.Manager used by the streamer
+type StatsSource interface {
+	ListManagedContainers(ctx context.Context) ([]container.Summary, error)
+	StreamServerStats(ctx context.Context, containerID string, fn func(*docker.ContainerStats)) error
+}
+
+// serverStream is the stats state of one running server
+type serverStream struct {
+	containerID string
+	cancel      context.CancelFunc
+	latest      *docker.ContainerStats
+	dirty       bool
+}
+
+// Streamer pushes resource usage of every running managed container to the
+// panel on a fixed interval. Publishing runs apart from sampling, so while
+// a slow panel holds up one publish the samples keep coming in and only the
+// newest one per server goes out next.
+type Streamer struct {
+	source   StatsSource
+	events   messages.EventSink
+	interval time.Duration
+	mu       sync.Mutex
+	streams  map[string]*serverStream
+	last     map[string]*docker.ContainerStats
+	// ctx bounds every stream and is cancelled when Run returns
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewStreamer creates a stats streamer publishing every interval
+func NewStreamer(source StatsSource, events messages.EventSink, interval time.Duration) *Streamer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Streamer{
+		source:   source,
+		events:   events,
+		interval: interval,
+		streams:  make(map[string]*serverStream),
+		last:     make(map[string]*docker.ContainerStats),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Run streams stats until ctx is cancelled
+func (s *Streamer) Run(ctx context.Context) {
+	defer s.cancel()
+
+	s.sync(ctx)
+
+	// A tick while a publish is in flight leaves one pending publish behind
+	pending := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pending:
+				s.publish()
+			}
+		}
+	}()
+
+	publish := time.NewTicker(s.interval)
+	defer publish.Stop()
+	resync := time.NewTicker(syncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-resync.C:
+			s.sync(ctx)
+		case <-publish.C:
+			select {
+			case pending <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// HandleExit stops streaming a server whose container exited
+func (s *Streamer) HandleExit(_ context.Context, exit watch.Exit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.streams[exit.ServerID]; ok && st.containerID == exit.ContainerID {
+		st.cancel()
+		delete(s.streams, exit.ServerID)
+	}
+}
+
+// Track starts streaming stats for a running server
+func (s *Streamer) Track(serverID, containerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	if st, ok := s.streams[serverID]; ok {
+		if st.containerID == containerID {
+			return
+		}
+		st.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	st := &serverStream{containerID: containerID, cancel: cancel}
+	s.streams[serverID] = st
+
+	go s.stream(ctx, serverID, st)
+}
+
+// Untrack stops streaming stats for a server
+func (s *Streamer) Untrack(serverID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.streams[serverID]; ok {
+		st.cancel()
+		delete(s.streams, serverID)
+	}
+}
+
+// Latest returns the most recent sample of a server, if any. The sample is
+// kept after the container stops so it can describe its final state.
+func (s *Streamer) Latest(serverID string) *docker.ContainerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last[serverID]
+}
+
+// Forget stops streaming a server and discards its last sample
+func (s *Streamer) Forget(serverID string) {
+	s.Untrack(serverID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.last, serverID)
+}
+
+// sync starts streams for running containers and stops streams for the rest
+func (s *Streamer) sync(ctx context.Context) {
+	containers, err := s.source.ListManagedContainers(ctx)
+	if err != nil {
+		log.Printf("Error listing containers for stats: %v", err)
+		return
+	}
+
+	running := make(map[string]string)
+	for _, c := range containers {
+		serverID := c.Labels[docker.LabelServerID]
+		if serverID != "" && c.State == "running" {
+			running[serverID] = c.ID
+		}
+	}
+
+	s.mu.Lock()
+	var stale []string
+	for serverID := range s.streams {
+		if _, ok := running[serverID]; !ok {
+			stale = append(stale, serverID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, serverID := range stale {
+		s.Untrack(serverID)
+	}
+	for serverID, containerID := range running {
+		s.Track(serverID, containerID)
+	}
+}
+
+// stream records samples from the Docker stats stream of one container
+func (s *Streamer) stream(ctx context.Context, serverID string, st *serverStream) {
+	err := s.source.StreamServerStats(ctx, st.containerID, func(stats *docker.ContainerStats) {
+		s.mu.Lock()
+		st.latest = stats
+		st.dirty = true
+		s.last[serverID] = stats
+		s.mu.Unlock()
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Stats stream for server %s ended: %v", serverID, err)
+	}
+
+	// Let the next sync restart the stream if the container is still running
+	s.mu.Lock()
+	if s.streams[serverID] == st {
+		delete(s.streams, serverID)
+	}
+	s.mu.Unlock()
+}
+
+// publish sends the newest unsent sample of every server
+func (s *Streamer) publish() {
+	type sample struct {
+		serverID    string
+		containerID string
+		stats       *docker.ContainerStats
+	}
+
+	s.mu.Lock()
+	var samples []sample
+	for serverID, st := range s.streams {
+		if !st.dirty || st.latest == nil {
+			continue
+		}
+		samples = append(samples, sample{serverID: serverID, containerID: st.containerID, stats: st.latest})
+		st.dirty = false
+	}
+	s.mu.Unlock()
+
+	for _, smp := range samples {
+		s.events.SendEvent("server_stats", map[string]interface{}{
+			"serverId":    smp.serverID,
+			"containerId": smp.containerID,
+			"stats":       smp.stats.ToServerStatsData(),
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/watch"
+)
+
+// mockSource emits a burst of samples per container and then blocks
+type mockSource struct {
+	containers []container.Summary
+	samples    int
+}
+
+func (m *mockSource) ListManagedContainers(ctx context.Context) ([]container.Summary, error) {
+	return m.containers, nil
+}
+
+func (m *mockSource) StreamServerStats(ctx context.Context, containerID string, fn func(*docker.ContainerStats)) error {
+	for i := 1; i <= m.samples; i++ {
+		fn(&docker.ContainerStats{CPUPercent: float64(i)})
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// recordingSink captures emitted events
+type recordingSink struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data["event"] = event
+	s.events = append(s.events, data)
+}
+
+func (s *recordingSink) snapshot() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.events...)
+}
+
+// feedSource runs container c1 of server_1 and streams the samples sent on feed
+type feedSource struct {
+	feed chan float64
+}
+
+func (f *feedSource) ListManagedContainers(ctx context.Context) ([]container.Summary, error) {
+	return []container.Summary{managed("c1", "server_1", "running")}, nil
+}
+
+func (f *feedSource) StreamServerStats(ctx context.Context, containerID string, fn func(*docker.ContainerStats)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case cpu := <-f.feed:
+			fn(&docker.ContainerStats{CPUPercent: cpu})
+		}
+	}
+}
+
+// blockingSink holds every event until release is closed
+type blockingSink struct {
+	recordingSink
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSink) SendEvent(event string, data map[string]interface{}) {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	s.recordingSink.SendEvent(event, data)
+}
+
+func managed(id, serverID, state string) container.Summary {
+	return container.Summary{
+		ID:     id,
+		State:  state,
+		Labels: map[string]string{docker.LabelManaged: "true", docker.LabelServerID: serverID},
+	}
+}
+
+func TestStreamer_PublishesCoalescedSamples(t *testing.T) {
+	source := &mockSource{
+		containers: []container.Summary{
+			managed("c1", "server_1", "running"),
+			managed("c2", "server_2", "exited"),
+		},
+		samples: 5,
+	}
+	sink := &recordingSink{}
+	streamer := NewStreamer(source, sink, 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go streamer.Run(ctx)
+
+	require.Eventually(t, func() bool { return len(sink.snapshot()) > 0 }, time.Second, 5*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+
+	events := sink.snapshot()
+	// Five samples arrived before the first tick; only the newest is published, once
+	require.Len(t, events, 1)
+	assert.Equal(t, "server_stats", events[0]["event"])
+	assert.Equal(t, "server_1", events[0]["serverId"])
+	stats := events[0]["stats"].(*messages.ServerStatsData)
+	assert.Equal(t, 5.0, stats.CPU)
+
+	assert.Equal(t, 5.0, streamer.Latest("server_1").CPUPercent)
+	assert.Nil(t, streamer.Latest("server_2"))
+}
+
+func TestStreamer_UntrackStopsStream(t *testing.T) {
+	source := &mockSource{samples: 1}
+	sink := &recordingSink{}
+	streamer := NewStreamer(source, sink, time.Hour)
+
+	streamer.Track("server_1", "c1")
+	require.Eventually(t, func() bool { return streamer.Latest("server_1") != nil }, time.Second, 5*time.Millisecond)
+
+	streamer.Untrack("server_1")
+	streamer.mu.Lock()
+	_, tracked := streamer.streams["server_1"]
+	streamer.mu.Unlock()
+	assert.False(t, tracked)
+
+	// The last sample survives until the server is forgotten
+	assert.NotNil(t, streamer.Latest("server_1"))
+	streamer.Forget("server_1")
+	assert.Nil(t, streamer.Latest("server_1"))
+}
+
+func (s *Streamer) tracked(serverID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.streams[serverID]
+	return ok
+}
+
+func TestStreamer_FollowsStartsAndExits(t *testing.T) {
+	source := &mockSource{samples: 1}
+	streamer := NewStreamer(source, &recordingSink{}, time.Hour)
+
+	// Tracking works before Run, as start events may come first
+	streamer.Track("server_1", "c1")
+	require.Eventually(t, func() bool { return streamer.Latest("server_1") != nil }, time.Second, 5*time.Millisecond)
+
+	// The exit of an older container leaves the current stream alone
+	streamer.HandleExit(context.Background(), watch.Exit{ServerID: "server_1", ContainerID: "c0"})
+	assert.True(t, streamer.tracked("server_1"))
+
+	streamer.HandleExit(context.Background(), watch.Exit{ServerID: "server_1", ContainerID: "c1", ExitCode: 1})
+	assert.False(t, streamer.tracked("server_1"))
+	assert.NotNil(t, streamer.Latest("server_1"), "the final sample is kept for crash reports")
+}
+
+func TestStreamer_StopsStreamsWhenRunEnds(t *testing.T) {
+	streamer := NewStreamer(&feedSource{feed: make(chan float64)}, &recordingSink{}, time.Hour)
+	streamer.Track("server_1", "c1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		streamer.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	require.Eventually(t, func() bool { return !streamer.tracked("server_1") }, time.Second, 5*time.Millisecond)
+	streamer.Track("server_2", "c2")
+	assert.False(t, streamer.tracked("server_2"))
+}
+
+func TestStreamer_SlowPanelDoesNotStallSampling(t *testing.T) {
+	source := &feedSource{feed: make(chan float64)}
+	sink := &blockingSink{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	streamer := NewStreamer(source, sink, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go streamer.Run(ctx)
+	streamer.Track("server_1", "c1")
+
+	source.feed <- 1
+	select {
+	case <-sink.entered:
+	case <-time.After(time.Second):
+		t.Fatal("first sample was not published")
+	}
+
+	// The panel holds up the first publish while samples keep arriving
+	for cpu := 2.0; cpu <= 5; cpu++ {
+		source.feed <- cpu
+	}
+	assert.Equal(t, 5.0, streamer.Latest("server_1").CPUPercent)
+	time.Sleep(30 * time.Millisecond)
+
+	close(sink.release)
+	require.Eventually(t, func() bool { return len(sink.snapshot()) == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	// Many ticks passed, but only the newest sample went out after the first
+	events := sink.snapshot()
+	require.Len(t, events, 2)
+	assert.Equal(t, 1.0, events[0]["stats"].(*messages.ServerStatsData).CPU)
+	assert.Equal(t, 5.0, events[1]["stats"].(*messages.ServerStatsData).CPU)
+}
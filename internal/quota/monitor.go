@@ -0,0 +1,100 @@
+package quota
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+)
+
+// DiskSource is the subset of docker.Manager used by the monitor
+type DiskSource interface {
+	ListManagedContainers(ctx context.Context) ([]container.Summary, error)
+	DiskUsage(ctx context.Context, serverID, containerID string) (int64, error)
+}
+
+// ServerStopper stops a server the way the panel does, so its power state
+// and desired state follow
+type ServerStopper interface {
+	StopServer(ctx context.Context, serverID string, opts docker.StopOptions) (string, error)
+}
+
+// Monitor enforces disk limits the storage driver cannot. Running servers
+// labelled with docker.LabelDiskLimit are measured on an interval and
+// stopped once their writable layer and data directory together exceed
+// their limit.
+type Monitor struct {
+	source   DiskSource
+	stopper  ServerStopper
+	events   messages.EventSink
+	interval time.Duration
+}
+
+// NewMonitor creates a disk quota monitor checking every interval
+func NewMonitor(source DiskSource, stopper ServerStopper, events messages.EventSink, interval time.Duration) *Monitor {
+	return &Monitor{
+		source:   source,
+		stopper:  stopper,
+		events:   events,
+		interval: interval,
+	}
+}
+
+// Run checks disk usage until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Check measures every limited running server once and stops offenders
+func (m *Monitor) Check(ctx context.Context) {
+	containers, err := m.source.ListManagedContainers(ctx)
+	if err != nil {
+		log.Printf("Error listing containers for disk quota: %v", err)
+		return
+	}
+
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		limit, err := strconv.ParseInt(c.Labels[docker.LabelDiskLimit], 10, 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+
+		serverID := c.Labels[docker.LabelServerID]
+		usage, err := m.source.DiskUsage(ctx, serverID, c.ID)
+		if err != nil {
+			log.Printf("Error measuring disk usage of server %s: %v", serverID, err)
+			continue
+		}
+		if usage <= limit {
+			continue
+		}
+
+		log.Printf("Server %s uses %d bytes of disk, over its %d byte limit; stopping", serverID, usage, limit)
+		m.events.SendEvent("disk_limit_exceeded", map[string]interface{}{
+			"serverId":    serverID,
+			"containerId": c.ID,
+			"usage":       usage,
+			"limit":       limit,
+		})
+		if _, err := m.stopper.StopServer(ctx, serverID, docker.StopOptions{}); err != nil {
+			log.Printf("Error stopping server %s over disk limit: %v", serverID, err)
+		}
+	}
+}
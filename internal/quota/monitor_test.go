@@ -0,0 +1,70 @@
+package quota
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+)
+
+type mockSource struct {
+	containers []container.Summary
+	usage      map[string]int64
+	stopped    []string
+}
+
+func (m *mockSource) ListManagedContainers(ctx context.Context) ([]container.Summary, error) {
+	return m.containers, nil
+}
+
+func (m *mockSource) DiskUsage(ctx context.Context, serverID, containerID string) (int64, error) {
+	return m.usage[containerID], nil
+}
+
+func (m *mockSource) StopServer(ctx context.Context, serverID string, opts docker.StopOptions) (string, error) {
+	m.stopped = append(m.stopped, serverID)
+	return docker.StopStageSignal, nil
+}
+
+type recordingSink struct {
+	events []map[string]interface{}
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	data["event"] = event
+	s.events = append(s.events, data)
+}
+
+func limited(id, serverID, state, limit string) container.Summary {
+	labels := map[string]string{docker.LabelManaged: "true", docker.LabelServerID: serverID}
+	if limit != "" {
+		labels[docker.LabelDiskLimit] = limit
+	}
+	return container.Summary{ID: id, State: state, Labels: labels}
+}
+
+func TestMonitor_Check(t *testing.T) {
+	source := &mockSource{
+		containers: []container.Summary{
+			limited("c1", "over", "running", "1000"),
+			limited("c2", "under", "running", "1000"),
+			limited("c3", "unlimited", "running", ""),
+			limited("c4", "stopped", "exited", "1000"),
+		},
+		usage: map[string]int64{"c1": 1500, "c2": 900, "c3": 1 << 40, "c4": 5000},
+	}
+	sink := &recordingSink{}
+
+	NewMonitor(source, source, sink, 0).Check(context.Background())
+
+	assert.Equal(t, []string{"over"}, source.stopped)
+	if assert.Len(t, sink.events, 1) {
+		assert.Equal(t, "disk_limit_exceeded", sink.events[0]["event"])
+		assert.Equal(t, "over", sink.events[0]["serverId"])
+		assert.Equal(t, int64(1500), sink.events[0]["usage"])
+		assert.Equal(t, int64(1000), sink.events[0]["limit"])
+	}
+}
@@ -0,0 +1,210 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+)
+
+// DockerAPI is the subset of docker.Manager used by the reconciler
+type DockerAPI interface {
+	ListManagedContainers(ctx context.Context) ([]container.Summary, error)
+}
+
+// ServerControl starts and stops servers the way the panel does, so restored
+// servers go through the state machine, get their console and stats, and
+// are supervised like any other
+type ServerControl interface {
+	StartServer(ctx context.Context, serverID string) error
+	StopServer(ctx context.Context, serverID string, opts docker.StopOptions) (string, error)
+}
+
+// Reconciler compares managed containers with the registry on agent startup
+type Reconciler struct {
+	docker  DockerAPI
+	servers *registry.Registry
+	control ServerControl
+	events  messages.EventSink
+	restore bool
+}
+
+// Report summarises a reconciliation run
+type Report struct {
+	InSync   []string `json:"inSync"`   // server IDs whose container matches the desired state
+	Drifted  []string `json:"drifted"`  // server IDs whose container is not in the desired state
+	Restored []string `json:"restored"` // server IDs brought back to the desired state
+	Missing  []string `json:"missing"`  // server IDs with no container
+	Orphans  []string `json:"orphans"`  // container IDs labelled as managed but not registered
+}
+
+// NewReconciler creates a new reconciler. When restore is true, servers
+// are started or stopped through control to match the desired state in the
+// registry.
+func NewReconciler(dockerAPI DockerAPI, servers *registry.Registry, control ServerControl, events messages.EventSink, restore bool) *Reconciler {
+	return &Reconciler{
+		docker:  dockerAPI,
+		servers: servers,
+		control: control,
+		events:  events,
+		restore: restore,
+	}
+}
+
+// Run performs a single reconciliation pass and reports differences to the panel
+func (r *Reconciler) Run(ctx context.Context) (*Report, error) {
+	containers, err := r.docker.ListManagedContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list managed containers: %w", err)
+	}
+
+	byServerID := make(map[string]container.Summary)
+	for _, c := range containers {
+		serverID := c.Labels[docker.LabelServerID]
+		if serverID == "" {
+			continue
+		}
+		if _, exists := byServerID[serverID]; !exists {
+			byServerID[serverID] = c
+		}
+	}
+
+	report := &Report{}
+	claimed := make(map[string]bool)
+
+	for _, rec := range r.servers.List() {
+		c, found := findContainer(containers, byServerID, rec)
+		if !found {
+			report.Missing = append(report.Missing, rec.ServerID)
+			r.events.SendEvent("server_missing", map[string]interface{}{
+				"serverId":     rec.ServerID,
+				"containerId":  rec.ContainerID,
+				"desiredState": rec.DesiredState,
+			})
+			continue
+		}
+		claimed[c.ID] = true
+
+		if c.ID != rec.ContainerID {
+			log.Printf("Server %s container changed from %s to %s", rec.ServerID, rec.ContainerID, c.ID)
+			rec.ContainerID = c.ID
+			if err := r.servers.Put(rec); err != nil {
+				log.Printf("Failed to update registry for server %s: %v", rec.ServerID, err)
+			}
+		}
+
+		r.reconcileState(ctx, rec, c, report)
+	}
+
+	for _, c := range containers {
+		if claimed[c.ID] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, c.ID)
+		r.events.SendEvent("server_orphaned", map[string]interface{}{
+			"serverId":    c.Labels[docker.LabelServerID],
+			"containerId": c.ID,
+			"name":        containerName(c),
+			"state":       c.State,
+		})
+	}
+
+	log.Printf("Reconciliation complete: %d in sync, %d drifted, %d restored, %d missing, %d orphaned",
+		len(report.InSync), len(report.Drifted), len(report.Restored), len(report.Missing), len(report.Orphans))
+
+	r.events.SendEvent("reconciliation_completed", map[string]interface{}{
+		"inSync":   len(report.InSync),
+		"drifted":  report.Drifted,
+		"restored": report.Restored,
+		"missing":  report.Missing,
+		"orphans":  report.Orphans,
+	})
+
+	return report, nil
+}
+
+// reconcileState compares a container's state with the desired state and optionally restores it
+func (r *Reconciler) reconcileState(ctx context.Context, rec *registry.Record, c container.Summary, report *Report) {
+	running := isRunning(c.State)
+	wantRunning := rec.DesiredState == registry.DesiredRunning
+
+	if running == wantRunning {
+		report.InSync = append(report.InSync, rec.ServerID)
+		return
+	}
+
+	if !r.restore {
+		report.Drifted = append(report.Drifted, rec.ServerID)
+		r.events.SendEvent("server_state_drift", map[string]interface{}{
+			"serverId":     rec.ServerID,
+			"containerId":  c.ID,
+			"desiredState": rec.DesiredState,
+			"actualState":  c.State,
+		})
+		return
+	}
+
+	var err error
+	if wantRunning {
+		log.Printf("Restoring server %s to running", rec.ServerID)
+		err = r.control.StartServer(ctx, rec.ServerID)
+	} else {
+		log.Printf("Restoring server %s to stopped", rec.ServerID)
+		_, err = r.control.StopServer(ctx, rec.ServerID, docker.StopOptions{})
+	}
+
+	if err != nil {
+		log.Printf("Failed to restore server %s: %v", rec.ServerID, err)
+		report.Drifted = append(report.Drifted, rec.ServerID)
+		r.events.SendEvent("server_state_drift", map[string]interface{}{
+			"serverId":     rec.ServerID,
+			"containerId":  c.ID,
+			"desiredState": rec.DesiredState,
+			"actualState":  c.State,
+			"error":        err.Error(),
+		})
+		return
+	}
+
+	report.Restored = append(report.Restored, rec.ServerID)
+	r.events.SendEvent("server_state_restored", map[string]interface{}{
+		"serverId":       rec.ServerID,
+		"containerId":    c.ID,
+		"previousStatus": c.State,
+		"currentStatus":  string(rec.DesiredState),
+	})
+}
+
+// findContainer locates a record's container by ID first, then by server ID label
+func findContainer(containers []container.Summary, byServerID map[string]container.Summary, rec *registry.Record) (container.Summary, bool) {
+	if rec.ContainerID != "" {
+		for _, c := range containers {
+			if c.ID == rec.ContainerID {
+				return c, true
+			}
+		}
+	}
+	c, ok := byServerID[rec.ServerID]
+	return c, ok
+}
+
+// isRunning reports whether a Docker container state counts as running
+func isRunning(state string) bool {
+	return state == "running" || state == "restarting"
+}
+
+// containerName returns a container's primary name without the leading slash
+func containerName(c container.Summary) string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	name := c.Names[0]
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	return name
+}
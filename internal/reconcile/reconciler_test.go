@@ -0,0 +1,115 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/registry"
+)
+
+// mockDocker serves a fixed container list and records the servers it is
+// asked to start and stop
+type mockDocker struct {
+	containers []container.Summary
+	started    []string
+	stopped    []string
+}
+
+func (m *mockDocker) ListManagedContainers(ctx context.Context) ([]container.Summary, error) {
+	return m.containers, nil
+}
+
+func (m *mockDocker) StartServer(ctx context.Context, serverID string) error {
+	m.started = append(m.started, serverID)
+	return nil
+}
+
+func (m *mockDocker) StopServer(ctx context.Context, serverID string, opts docker.StopOptions) (string, error) {
+	m.stopped = append(m.stopped, serverID)
+	return docker.StopStageSignal, nil
+}
+
+// recordingSink captures emitted events
+type recordingSink struct {
+	events []string
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	s.events = append(s.events, event)
+}
+
+func managedContainer(id, serverID, state string) container.Summary {
+	return container.Summary{
+		ID:     id,
+		Names:  []string{"/" + docker.ContainerName(serverID)},
+		State:  state,
+		Labels: map[string]string{docker.LabelManaged: "true", docker.LabelServerID: serverID},
+	}
+}
+
+func newTestRegistry(t *testing.T, records ...*registry.Record) *registry.Registry {
+	reg, err := registry.Open(t.TempDir(), nil)
+	require.NoError(t, err)
+	for _, rec := range records {
+		require.NoError(t, reg.Put(rec))
+	}
+	return reg
+}
+
+func TestReconciler_ReportsDifferences(t *testing.T) {
+	reg := newTestRegistry(t,
+		&registry.Record{ServerID: "synced", ContainerID: "c-synced", DesiredState: registry.DesiredRunning},
+		&registry.Record{ServerID: "drifted", ContainerID: "c-drifted", DesiredState: registry.DesiredRunning},
+		&registry.Record{ServerID: "missing", ContainerID: "c-missing", DesiredState: registry.DesiredStopped},
+		&registry.Record{ServerID: "recreated", ContainerID: "c-old", DesiredState: registry.DesiredStopped},
+	)
+	dockerAPI := &mockDocker{containers: []container.Summary{
+		managedContainer("c-synced", "synced", "running"),
+		managedContainer("c-drifted", "drifted", "exited"),
+		managedContainer("c-new", "recreated", "exited"),
+		managedContainer("c-orphan", "unknown", "running"),
+	}}
+	sink := &recordingSink{}
+
+	report, err := NewReconciler(dockerAPI, reg, dockerAPI, sink, false).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"synced", "recreated"}, report.InSync)
+	assert.Equal(t, []string{"drifted"}, report.Drifted)
+	assert.Equal(t, []string{"missing"}, report.Missing)
+	assert.Equal(t, []string{"c-orphan"}, report.Orphans)
+	assert.Empty(t, report.Restored)
+	assert.Empty(t, dockerAPI.started)
+
+	assert.Contains(t, sink.events, "server_missing")
+	assert.Contains(t, sink.events, "server_orphaned")
+	assert.Contains(t, sink.events, "server_state_drift")
+	assert.Equal(t, "reconciliation_completed", sink.events[len(sink.events)-1])
+
+	rec, ok := reg.Get("recreated")
+	require.True(t, ok)
+	assert.Equal(t, "c-new", rec.ContainerID)
+}
+
+func TestReconciler_RestoresDesiredState(t *testing.T) {
+	reg := newTestRegistry(t,
+		&registry.Record{ServerID: "should-run", ContainerID: "c1", DesiredState: registry.DesiredRunning},
+		&registry.Record{ServerID: "should-stop", ContainerID: "c2", DesiredState: registry.DesiredStopped},
+	)
+	dockerAPI := &mockDocker{containers: []container.Summary{
+		managedContainer("c1", "should-run", "exited"),
+		managedContainer("c2", "should-stop", "running"),
+	}}
+
+	report, err := NewReconciler(dockerAPI, reg, dockerAPI, &recordingSink{}, true).Run(context.Background())
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"should-run", "should-stop"}, report.Restored)
+	assert.Equal(t, []string{"should-run"}, dockerAPI.started)
+	assert.Equal(t, []string{"should-stop"}, dockerAPI.stopped)
+}
@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+)
+
+// registryFile is the name of the registry file inside the data directory
+const registryFile = "servers.json"
+
+// ErrServerNotFound is returned when a server is not present in the registry
+var ErrServerNotFound = errors.New("server not found in registry")
+
+// DesiredState is the state the panel last asked a server to be in
+type DesiredState string
+
+const (
+	DesiredRunning DesiredState = "running"
+	DesiredStopped DesiredState = "stopped"
+)
+
+// Record describes a game server managed by this agent
+type Record struct {
+	ServerID     string              `json:"serverId"`
+	ContainerID  string              `json:"containerId"`
+	Config       docker.ServerConfig `json:"config"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	DesiredState DesiredState        `json:"desiredState"`
+}
+
+// ContainerFinder looks up the container labelled with a server ID
+type ContainerFinder interface {
+	FindServerContainer(ctx context.Context, serverID string) (string, error)
+}
+
+// Registry is a durable serverID-to-container store persisted as JSON
+type Registry struct {
+	path    string
+	finder  ContainerFinder
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// Open loads the registry from dataDir, creating the directory if needed.
+// The finder is optional and used to resolve servers missing from the registry.
+func Open(dataDir string, finder ContainerFinder) (*Registry, error) {
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	r := &Registry{
+		path:    filepath.Join(dataDir, registryFile),
+		finder:  finder,
+		records: make(map[string]*Record),
+	}
+
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", r.path, err)
+	}
+	for _, rec := range records {
+		r.records[rec.ServerID] = rec
+	}
+
+	return r, nil
+}
+
+// Get returns a copy of the record for a server
+func (r *Registry) Get(serverID string) (*Record, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.records[serverID]
+	if !ok {
+		return nil, false
+	}
+	copied := *rec
+	return &copied, true
+}
+
+// List returns copies of all records ordered by server ID
+func (r *Registry) List() []*Record {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]*Record, 0, len(r.records))
+	for _, rec := range r.records {
+		copied := *rec
+		records = append(records, &copied)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ServerID < records[j].ServerID
+	})
+	return records
+}
+
+// Put inserts or replaces a record and persists the registry
+func (r *Registry) Put(rec *Record) error {
+	if rec.ServerID == "" {
+		return errors.New("record is missing serverId")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *rec
+	if copied.CreatedAt.IsZero() {
+		copied.CreatedAt = time.Now().UTC()
+	}
+	if copied.DesiredState == "" {
+		copied.DesiredState = DesiredStopped
+	}
+
+	previous, existed := r.records[rec.ServerID]
+	r.records[rec.ServerID] = &copied
+	if err := r.saveLocked(); err != nil {
+		if existed {
+			r.records[rec.ServerID] = previous
+		} else {
+			delete(r.records, rec.ServerID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a server from the registry
+func (r *Registry) Delete(serverID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.records[serverID]
+	if !ok {
+		return nil
+	}
+
+	delete(r.records, serverID)
+	if err := r.saveLocked(); err != nil {
+		r.records[serverID] = previous
+		return err
+	}
+	return nil
+}
+
+// SetDesiredState records the state the panel wants a server to be in
+func (r *Registry) SetDesiredState(serverID string, state DesiredState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.records[serverID]
+	if !ok {
+		return ErrServerNotFound
+	}
+	if rec.DesiredState == state {
+		return nil
+	}
+
+	previous := rec.DesiredState
+	rec.DesiredState = state
+	if err := r.saveLocked(); err != nil {
+		rec.DesiredState = previous
+		return err
+	}
+	return nil
+}
+
+// Resolve returns the container reference for a server. Registered servers
+// resolve to their recorded container ID; otherwise the container labelled
+// with the server ID is used, and as a last resort the server ID itself is
+// treated as a container ID or name for backward compatibility.
+func (r *Registry) Resolve(ctx context.Context, serverID string) string {
+	r.mu.RLock()
+	rec, ok := r.records[serverID]
+	r.mu.RUnlock()
+
+	if ok && rec.ContainerID != "" {
+		return rec.ContainerID
+	}
+
+	if r.finder != nil {
+		if containerID, err := r.finder.FindServerContainer(ctx, serverID); err == nil && containerID != "" {
+			return containerID
+		}
+	}
+
+	return serverID
+}
+
+// saveLocked atomically writes the registry to disk. Callers must hold r.mu.
+func (r *Registry) saveLocked() error {
+	records := make([]*Record, 0, len(r.records))
+	for _, rec := range r.records {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ServerID < records[j].ServerID
+	})
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), registryFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+)
+
+// mockFinder resolves servers from a fixed label map
+type mockFinder map[string]string
+
+func (f mockFinder) FindServerContainer(ctx context.Context, serverID string) (string, error) {
+	if id, ok := f[serverID]; ok {
+		return id, nil
+	}
+	return "", errors.New("not found")
+}
+
+func TestRegistry_PersistsRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	reg, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	err = reg.Put(&Record{
+		ServerID:    "server_123",
+		ContainerID: "abc123",
+		Config: docker.ServerConfig{
+			ServerID: "server_123",
+			Image:    "minecraft:latest",
+			Startup:  "java -jar server.jar",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, reg.SetDesiredState("server_123", DesiredRunning))
+
+	reopened, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	rec, ok := reopened.Get("server_123")
+	require.True(t, ok)
+	assert.Equal(t, "abc123", rec.ContainerID)
+	assert.Equal(t, "minecraft:latest", rec.Config.Image)
+	assert.Equal(t, DesiredRunning, rec.DesiredState)
+	assert.False(t, rec.CreatedAt.IsZero())
+}
+
+func TestRegistry_DoesNotPersistRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+
+	reg, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	err = reg.Put(&Record{
+		ServerID: "server_123",
+		Config: docker.ServerConfig{
+			ServerID:     "server_123",
+			Image:        "ghcr.io/acme/private:latest",
+			RegistryAuth: &docker.RegistryAuth{Username: "panel", Password: "hunter2"},
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, registryFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.NotContains(t, string(data), "registryAuth")
+
+	reopened, err := Open(dir, nil)
+	require.NoError(t, err)
+	rec, ok := reopened.Get("server_123")
+	require.True(t, ok)
+	assert.Nil(t, rec.Config.RegistryAuth)
+}
+
+func TestRegistry_Delete(t *testing.T) {
+	dir := t.TempDir()
+
+	reg, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, reg.Put(&Record{ServerID: "server_1", ContainerID: "c1"}))
+	require.NoError(t, reg.Put(&Record{ServerID: "server_2", ContainerID: "c2"}))
+	require.NoError(t, reg.Delete("server_1"))
+	require.NoError(t, reg.Delete("missing"))
+
+	reopened, err := Open(dir, nil)
+	require.NoError(t, err)
+
+	records := reopened.List()
+	require.Len(t, records, 1)
+	assert.Equal(t, "server_2", records[0].ServerID)
+}
+
+func TestRegistry_SetDesiredStateUnknownServer(t *testing.T) {
+	reg, err := Open(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	err = reg.SetDesiredState("missing", DesiredRunning)
+	assert.ErrorIs(t, err, ErrServerNotFound)
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	reg, err := Open(t.TempDir(), mockFinder{"labelled": "label-container"})
+	require.NoError(t, err)
+	require.NoError(t, reg.Put(&Record{ServerID: "registered", ContainerID: "registered-container"}))
+
+	ctx := context.Background()
+	assert.Equal(t, "registered-container", reg.Resolve(ctx, "registered"))
+	assert.Equal(t, "label-container", reg.Resolve(ctx, "labelled"))
+	assert.Equal(t, "raw-container-id", reg.Resolve(ctx, "raw-container-id"))
+}
+
+func TestRegistry_RejectsCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, registryFile), []byte("{not json"), 0600))
+
+	_, err := Open(dir, nil)
+	assert.Error(t, err)
+}
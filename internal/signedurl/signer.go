@@ -0,0 +1,110 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations a URL can be signed for
+const (
+	OpDownload = "download"
+	OpUpload   = "upload"
+)
+
+// version starts the signed string, so the scheme can change without old
+// signatures being accepted for new URLs
+const version = "v2"
+
+// keyLabel derives the signing key from the agent secret, so a signature
+// never doubles as proof of the secret used elsewhere
+const keyLabel = "ctrl-alt-play signed URL key"
+
+var (
+	// ErrInvalidSignature rejects URLs whose signature does not match their
+	// operation, server, path and expiry
+	ErrInvalidSignature = errors.New("invalid URL signature")
+	// ErrExpired rejects signed URLs past their expiry
+	ErrExpired = errors.New("signed URL has expired")
+)
+
+// Signer signs and verifies file transfer URLs with an HMAC-SHA256 keyed
+// by a key derived from the agent secret. A signed URL grants one operation
+// on one path of one server, as often as it is used, until it expires,
+// without the secret itself. The panel may sign URLs too: the key is the
+// HMAC-SHA256 of keyLabel keyed by the secret, and the signature the hex
+// HMAC-SHA256 of "v2" followed by operation, server ID, path and expiry
+// (Unix seconds), each written as "{byte length}:{value}".
+type Signer struct {
+	key    []byte
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// NewSigner creates a signer keyed by secret that accepts URLs expiring at
+// most maxTTL from now
+func NewSigner(secret string, maxTTL time.Duration) *Signer {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(keyLabel))
+	return &Signer{key: mac.Sum(nil), maxTTL: maxTTL, now: time.Now}
+}
+
+// MaxTTL returns the longest lifetime of a signed URL
+func (s *Signer) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// Sign returns the query parameters granting operation on p of serverID
+// until expires
+func (s *Signer) Sign(operation, serverID, p string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		"serverId":  {serverID},
+		"path":      {p},
+		"expires":   {exp},
+		"signature": {s.signature(operation, serverID, p, exp)},
+	}
+}
+
+// Verify checks that query is signed for operation and has not expired.
+// It returns the server and path the URL is scoped to.
+func (s *Signer) Verify(operation string, query url.Values) (serverID, p string, err error) {
+	serverID, p = query.Get("serverId"), query.Get("path")
+	exp, signature := query.Get("expires"), query.Get("signature")
+
+	want := s.signature(operation, serverID, p, exp)
+	if serverID == "" || p == "" || !hmac.Equal([]byte(strings.ToLower(signature)), []byte(want)) {
+		return "", "", ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", "", ErrInvalidSignature
+	}
+	now := s.now()
+	if now.Unix() >= expires {
+		return "", "", ErrExpired
+	}
+	// Refuse URLs minted to outlive the configured lifetime
+	if time.Unix(expires, 0).Sub(now) > s.maxTTL {
+		return "", "", fmt.Errorf("%w: expiry is more than %s away", ErrInvalidSignature, s.maxTTL)
+	}
+	return serverID, p, nil
+}
+
+// signature returns the hex HMAC of the signed fields. Each field carries
+// its length, so no choice of values can shift bytes between fields.
+func (s *Signer) signature(operation, serverID, p, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(version))
+	for _, field := range []string{operation, serverID, p, expires} {
+		mac.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
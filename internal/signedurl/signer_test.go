@@ -0,0 +1,126 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(now time.Time) *Signer {
+	s := NewSigner("agent-secret", time.Hour)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSigner_SignVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+
+	query := s.Sign(OpDownload, "minecraft-001", "/world.zip", now.Add(15*time.Minute))
+	serverID, p, err := s.Verify(OpDownload, query)
+	require.NoError(t, err)
+	assert.Equal(t, "minecraft-001", serverID)
+	assert.Equal(t, "/world.zip", p)
+
+	// The grant covers one operation
+	_, _, err = s.Verify(OpUpload, query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestSigner_RejectsTampering(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+
+	for field, value := range map[string]string{
+		"serverId":  "minecraft-002",
+		"path":      "/server.properties",
+		"expires":   strconv.FormatInt(now.Add(30*time.Minute).Unix(), 10),
+		"signature": "00",
+	} {
+		query := s.Sign(OpDownload, "minecraft-001", "/world.zip", now.Add(15*time.Minute))
+		query.Set(field, value)
+		_, _, err := s.Verify(OpDownload, query)
+		assert.ErrorIs(t, err, ErrInvalidSignature, field)
+	}
+
+	_, _, err := s.Verify(OpDownload, url.Values{"serverId": {"minecraft-001"}, "path": {"/world.zip"}})
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// Another secret signs differently
+	query := NewSigner("other-secret", time.Hour).Sign(OpDownload, "minecraft-001", "/world.zip", now.Add(time.Minute))
+	_, _, err = s.Verify(OpDownload, query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestSigner_Expiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+
+	query := s.Sign(OpUpload, "minecraft-001", "/world.zip", now.Add(-time.Second))
+	_, _, err := s.Verify(OpUpload, query)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	query = s.Sign(OpUpload, "minecraft-001", "/world.zip", now.Add(2*time.Hour))
+	_, _, err = s.Verify(OpUpload, query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestSigner_PanelSignature(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+	expires := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	// What the panel computes from the documented scheme
+	keyMAC := hmac.New(sha256.New, []byte("agent-secret"))
+	keyMAC.Write([]byte("ctrl-alt-play signed URL key"))
+	mac := hmac.New(sha256.New, keyMAC.Sum(nil))
+	mac.Write([]byte("v2" + "8:download" + "13:minecraft-001" + "10:/world.zip" + "10:" + expires))
+	query := url.Values{
+		"serverId":  {"minecraft-001"},
+		"path":      {"/world.zip"},
+		"expires":   {expires},
+		"signature": {hex.EncodeToString(mac.Sum(nil))},
+	}
+
+	_, _, err := s.Verify(OpDownload, query)
+	assert.NoError(t, err)
+}
+
+func TestSigner_FieldsCannotShift(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+	expires := now.Add(time.Minute)
+
+	// Moving a separator between server ID and path keeps the joined bytes
+	query := s.Sign(OpDownload, "minecraft-001\n/world", ".zip", expires)
+	query.Set("serverId", "minecraft-001")
+	query.Set("path", "/world\n.zip")
+	_, _, err := s.Verify(OpDownload, query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestSigner_KeyIsDerived(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestSigner(now)
+	expires := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	// A signature keyed by the raw secret is not accepted
+	mac := hmac.New(sha256.New, []byte("agent-secret"))
+	mac.Write([]byte("v2" + "8:download" + "13:minecraft-001" + "10:/world.zip" + "10:" + expires))
+	query := url.Values{
+		"serverId":  {"minecraft-001"},
+		"path":      {"/world.zip"},
+		"expires":   {expires},
+		"signature": {hex.EncodeToString(mac.Sum(nil))},
+	}
+
+	_, _, err := s.Verify(OpDownload, query)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
@@ -0,0 +1,358 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+)
+
+// State is the power state of a game server
+type State string
+
+const (
+	Offline    State = "offline"
+	Installing State = "installing"
+	Starting   State = "starting"
+	Running    State = "running"
+	Stopping   State = "stopping"
+	Crashed    State = "crashed"
+	Deleting   State = "deleting"
+	Deleted    State = "deleted" // terminal; the server is forgotten afterwards
+)
+
+// transitions lists the states each state may be moved to on request.
+// Observed changes, such as a container exiting on its own, bypass it.
+var transitions = map[State][]State{
+	Offline:    {Installing, Starting, Deleting},
+	Installing: {Offline, Crashed},
+	Starting:   {Running, Offline, Crashed, Stopping, Deleting},
+	Running:    {Stopping, Offline, Crashed, Deleting},
+	Stopping:   {Offline, Running, Crashed},
+	Crashed:    {Installing, Starting, Stopping, Offline, Deleting},
+	Deleting:   {Deleted, Offline},
+}
+
+// transitional states are owned by an operation in progress
+var transitional = map[State]bool{
+	Installing: true,
+	Starting:   true,
+	Stopping:   true,
+	Deleting:   true,
+}
+
+// CanTransition reports whether a server may be moved from one state to another
+func CanTransition(from, to State) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when an operation is not allowed in the
+// server's current state
+type TransitionError struct {
+	ServerID string
+	From     State
+	To       State
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("server %s cannot go from %s to %s", e.ServerID, e.From, e.To)
+}
+
+// Change describes a state change of a server. Err is set when the change
+// undoes a failed operation; Details carries what the operation reported.
+type Change struct {
+	ServerID string
+	From     State
+	To       State
+	Err      error
+	Details  map[string]interface{}
+}
+
+// server is the tracked state of one server, guarded by its own lock
+type server struct {
+	mu      sync.Mutex
+	state   State
+	version uint64 // incremented on every state change
+	op      uint64 // incremented whenever an operation takes over the server
+	// waiting is set while an operation waits in the background; another
+	// operation may then take over, e.g. to stop a server still booting
+	waiting bool
+	// pending holds changes not yet delivered to the notifier; flushing
+	// is set while a goroutine delivers them
+	pending  []Change
+	flushing bool
+}
+
+// changeLocked moves the server to change.To and queues the change for
+// delivery. Callers must hold s.mu.
+func (s *server) changeLocked(change Change) {
+	s.state = change.To
+	s.version++
+	s.pending = append(s.pending, change)
+}
+
+// Machine tracks the power state of every server and serialises operations
+// on the same server: an operation moves the server into a transitional
+// state, which rejects any other operation until it completes. Neither the
+// observer nor the notifier is called with a server locked, so a slow
+// Docker daemon or panel connection does not hold up other operations.
+type Machine struct {
+	observe func(serverID string) State
+	mu      sync.Mutex
+	servers map[string]*server
+	notify  func(Change)
+}
+
+// NewMachine creates a state machine. observe reports the state a server's
+// container is actually in, or "" if it cannot be determined.
+func NewMachine(observe func(serverID string) State) *Machine {
+	return &Machine{
+		observe: observe,
+		servers: make(map[string]*server),
+	}
+}
+
+// SetNotifier registers a callback invoked on every state change. Changes
+// of one server are delivered in order, after the change has been made.
+func (m *Machine) SetNotifier(fn func(Change)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notify = fn
+}
+
+// Get returns the current state of a server
+func (m *Machine) Get(serverID string) State {
+	s := m.server(serverID)
+	observed, version := m.look(serverID, s)
+
+	s.mu.Lock()
+	current := m.syncLocked(s, serverID, observed, version)
+	s.mu.Unlock()
+	m.flush(s)
+	return current
+}
+
+// Peek returns the tracked state of a server without refreshing it from
+// the container, for callers that are themselves reacting to a container
+// change and must not report it a second time
+func (m *Machine) Peek(serverID string) State {
+	s := m.server(serverID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == "" {
+		return Offline
+	}
+	return s.state
+}
+
+// Set records a state observed outside of an operation, such as a container
+// exiting on its own. It is ignored while an operation owns the server.
+func (m *Machine) Set(serverID string, to State) {
+	s := m.server(serverID)
+	s.mu.Lock()
+	if s.state != to && !transitional[s.state] {
+		s.changeLocked(Change{ServerID: serverID, From: s.state, To: to})
+	}
+	s.mu.Unlock()
+	m.flush(s)
+}
+
+// Do runs fn with the server moved to during, then moves it to done. If fn
+// fails the server returns to the state it was in before. A server not
+// allowed to enter during is rejected with a *TransitionError. Details fn
+// adds to the map are attached to the final change.
+func (m *Machine) Do(serverID string, during, done State, fn func(details map[string]interface{}) error) error {
+	return m.DoWait(serverID, during, done, fn, nil)
+}
+
+// DoWait is Do for operations that complete in the background. Once fn
+// succeeds DoWait returns, but the server stays in during until wait
+// returns, and is then moved to done, or to Crashed if wait failed. Another
+// operation allowed from during may take over the server meanwhile, in
+// which case the outcome of wait is dropped.
+func (m *Machine) DoWait(serverID string, during, done State, fn, wait func(details map[string]interface{}) error) error {
+	s := m.server(serverID)
+	observed, version := m.look(serverID, s)
+
+	s.mu.Lock()
+	from := m.syncLocked(s, serverID, observed, version)
+	if (transitional[from] && !s.waiting) || !CanTransition(from, during) {
+		s.mu.Unlock()
+		m.flush(s)
+		return &TransitionError{ServerID: serverID, From: from, To: during}
+	}
+	s.op++
+	op := s.op
+	s.waiting = false
+	s.changeLocked(Change{ServerID: serverID, From: from, To: during})
+	s.mu.Unlock()
+	m.flush(s)
+
+	details := make(map[string]interface{})
+	if err := fn(details); err != nil {
+		m.finish(serverID, s, op, from, err, details)
+		return err
+	}
+	if wait == nil {
+		m.finish(serverID, s, op, done, nil, details)
+		return nil
+	}
+
+	s.mu.Lock()
+	if s.op == op {
+		s.waiting = true
+	}
+	s.mu.Unlock()
+
+	go func() {
+		if err := wait(details); err != nil {
+			m.finish(serverID, s, op, Crashed, err, details)
+			return
+		}
+		m.finish(serverID, s, op, done, nil, details)
+	}()
+	return nil
+}
+
+// ReadyCheck prepares the wait of a start operation for DoWait. It is
+// called before the container starts and returns nil when the server is
+// running as soon as its container is.
+type ReadyCheck func(serverID, containerID string) func(details map[string]interface{}) error
+
+// finish moves a server out of the transitional state of operation op,
+// unless another operation has taken it over since
+func (m *Machine) finish(serverID string, s *server, op uint64, to State, err error, details map[string]interface{}) {
+	s.mu.Lock()
+	if s.op != op {
+		s.mu.Unlock()
+		return
+	}
+	s.waiting = false
+	s.changeLocked(Change{ServerID: serverID, From: s.state, To: to, Err: err, Details: details})
+	if to == Deleted {
+		m.forget(serverID, s)
+	}
+	s.mu.Unlock()
+	m.flush(s)
+}
+
+// server returns the tracked entry of a server, creating it on first use
+func (m *Machine) server(serverID string) *server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.servers[serverID]
+	if !ok {
+		s = &server{}
+		m.servers[serverID] = s
+	}
+	return s
+}
+
+// forget drops a deleted server so a new one may reuse its ID
+func (m *Machine) forget(serverID string, s *server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.servers[serverID] == s {
+		delete(m.servers, serverID)
+	}
+}
+
+// look asks the observer for the state of a settled server's container,
+// without holding its lock. It returns the observation, "" if there is
+// none, and the version of the state it was taken at. Transitional states
+// are owned by an operation and Crashed is kept until an operation clears
+// it, so neither is observed.
+func (m *Machine) look(serverID string, s *server) (State, uint64) {
+	s.mu.Lock()
+	version := s.version
+	settled := !transitional[s.state] && s.state != Crashed
+	s.mu.Unlock()
+
+	if !settled || m.observe == nil {
+		return "", version
+	}
+	return m.observe(serverID), version
+}
+
+// syncLocked applies an observation made by look so changes made behind
+// the agent's back are not mistaken for the current state. An observation
+// is dropped if the state changed while it was made. Callers must hold s.mu.
+func (m *Machine) syncLocked(s *server, serverID string, observed State, version uint64) State {
+	if observed != "" && observed != s.state && s.version == version {
+		if s.state == "" {
+			s.state = observed
+		} else {
+			s.changeLocked(Change{ServerID: serverID, From: s.state, To: observed})
+		}
+	}
+	if s.state == "" {
+		s.state = Offline
+	}
+	return s.state
+}
+
+// flush delivers the queued changes of a server to the notifier, in order.
+// Callers must not hold s.mu. If another goroutine is delivering already,
+// it takes over the queued changes and flush returns at once.
+func (m *Machine) flush(s *server) {
+	m.mu.Lock()
+	notify := m.notify
+	m.mu.Unlock()
+
+	s.mu.Lock()
+	if s.flushing {
+		s.mu.Unlock()
+		return
+	}
+	s.flushing = true
+	for len(s.pending) > 0 {
+		changes := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+		if notify != nil {
+			for _, change := range changes {
+				notify(change)
+			}
+		}
+		s.mu.Lock()
+	}
+	s.flushing = false
+	s.mu.Unlock()
+}
+
+// ContainerResolver maps server IDs to container IDs
+type ContainerResolver interface {
+	Resolve(ctx context.Context, serverID string) string
+}
+
+// ContainerInspector is the subset of docker.Manager used to observe states
+type ContainerInspector interface {
+	InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error)
+}
+
+// ObserveContainers returns an observer deriving a server's state from its
+// container: running containers are Running, any other container or a
+// missing one is Offline.
+func ObserveContainers(servers ContainerResolver, inspector ContainerInspector) func(serverID string) State {
+	return func(serverID string) State {
+		ctx := context.Background()
+		info, err := inspector.InspectContainer(ctx, servers.Resolve(ctx, serverID))
+		switch {
+		case err != nil && docker.IsNotFound(err):
+			return Offline
+		case err != nil:
+			return ""
+		case info.State != nil && (info.State.Running || info.State.Restarting):
+			return Running
+		default:
+			return Offline
+		}
+	}
+}
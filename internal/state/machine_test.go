@@ -0,0 +1,320 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+func (r *recorder) record(change Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+// newTestMachine creates a machine whose observer always reports observed;
+// "" leaves the tracked state alone
+func newTestMachine(observed State) (*Machine, *recorder) {
+	m := NewMachine(func(string) State { return observed })
+	r := &recorder{}
+	m.SetNotifier(r.record)
+	return m, r
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		want     bool
+	}{
+		{Offline, Starting, true},
+		{Offline, Stopping, false},
+		{Running, Stopping, true},
+		{Running, Starting, false},
+		{Starting, Stopping, true},
+		{Stopping, Starting, false},
+		{Crashed, Starting, true},
+		{Crashed, Stopping, true},
+		{Deleting, Starting, false},
+		{Deleted, Installing, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			assert.Equal(t, tt.want, CanTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestMachine_Do(t *testing.T) {
+	m, r := newTestMachine(Offline)
+
+	err := m.Do("s1", Starting, Running, func(details map[string]interface{}) error {
+		assert.Equal(t, Starting, m.server("s1").state)
+		details["note"] = "ok"
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, r.changes, 2)
+	assert.Equal(t, Change{ServerID: "s1", From: Offline, To: Starting}, r.changes[0])
+	assert.Equal(t, Running, r.changes[1].To)
+	assert.Equal(t, "ok", r.changes[1].Details["note"])
+}
+
+func TestMachine_DoFailureRestoresState(t *testing.T) {
+	m, r := newTestMachine(Offline)
+	boom := errors.New("boom")
+
+	err := m.Do("s1", Starting, Running, func(map[string]interface{}) error { return boom })
+	assert.ErrorIs(t, err, boom)
+
+	require.Len(t, r.changes, 2)
+	last := r.changes[1]
+	assert.Equal(t, Starting, last.From)
+	assert.Equal(t, Offline, last.To)
+	assert.ErrorIs(t, last.Err, boom)
+}
+
+func TestMachine_RejectsInvalidTransition(t *testing.T) {
+	m, r := newTestMachine(Offline)
+
+	called := false
+	err := m.Do("s1", Stopping, Offline, func(map[string]interface{}) error {
+		called = true
+		return nil
+	})
+
+	var transitionErr *TransitionError
+	require.ErrorAs(t, err, &transitionErr)
+	assert.Equal(t, Offline, transitionErr.From)
+	assert.Equal(t, Stopping, transitionErr.To)
+	assert.False(t, called)
+	assert.Empty(t, r.changes)
+}
+
+func TestMachine_RejectsConcurrentOperation(t *testing.T) {
+	m, _ := newTestMachine(Offline)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- m.Do("s1", Starting, Running, func(map[string]interface{}) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	// A second start while the first is in progress is rejected
+	err := m.Do("s1", Starting, Running, func(map[string]interface{}) error { return nil })
+	var transitionErr *TransitionError
+	require.ErrorAs(t, err, &transitionErr)
+	assert.Equal(t, Starting, transitionErr.From)
+
+	// Observed states do not override an operation in progress
+	m.Set("s1", Offline)
+	assert.Equal(t, Starting, m.Get("s1"))
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, Running, m.server("s1").state)
+}
+
+func TestMachine_DoWait(t *testing.T) {
+	m, r := newTestMachine("")
+
+	ready := make(chan error)
+	err := m.DoWait("s1", Starting, Running,
+		func(map[string]interface{}) error { return nil },
+		func(details map[string]interface{}) error {
+			details["ready"] = true
+			return <-ready
+		})
+	require.NoError(t, err)
+	assert.Equal(t, Starting, m.Get("s1"), "the server stays starting until ready")
+
+	ready <- nil
+	assert.Eventually(t, func() bool { return m.Get("s1") == Running }, time.Second, time.Millisecond)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Equal(t, true, r.changes[len(r.changes)-1].Details["ready"])
+}
+
+func TestMachine_DoWaitFailureCrashes(t *testing.T) {
+	m, _ := newTestMachine("")
+	boom := errors.New("exited before ready")
+
+	require.NoError(t, m.DoWait("s1", Starting, Running,
+		func(map[string]interface{}) error { return nil },
+		func(map[string]interface{}) error { return boom }))
+
+	assert.Eventually(t, func() bool { return m.Get("s1") == Crashed }, time.Second, time.Millisecond)
+}
+
+func TestMachine_StopWhileWaiting(t *testing.T) {
+	m, _ := newTestMachine("")
+
+	ready := make(chan error)
+	require.NoError(t, m.DoWait("s1", Starting, Running,
+		func(map[string]interface{}) error { return nil },
+		func(map[string]interface{}) error { return <-ready }))
+
+	// A booting server can be stopped, and the late readiness is ignored
+	require.NoError(t, m.Do("s1", Stopping, Offline, func(map[string]interface{}) error { return nil }))
+	ready <- nil
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, Offline, m.Get("s1"))
+}
+
+func TestMachine_SyncsSettledStateFromObserver(t *testing.T) {
+	observed := Running
+	m := NewMachine(func(string) State { return observed })
+	r := &recorder{}
+	m.SetNotifier(r.record)
+
+	assert.Equal(t, Running, m.Get("s1"))
+	assert.Empty(t, r.changes, "first observation is not a change")
+
+	observed = Offline
+	assert.Equal(t, Offline, m.Get("s1"))
+	require.Len(t, r.changes, 1)
+	assert.Equal(t, Change{ServerID: "s1", From: Running, To: Offline}, r.changes[0])
+}
+
+func TestMachine_CrashedIsKept(t *testing.T) {
+	m, _ := newTestMachine(Offline)
+
+	m.Set("s1", Crashed)
+	assert.Equal(t, Crashed, m.Get("s1"))
+
+	require.NoError(t, m.Do("s1", Starting, Running, func(map[string]interface{}) error { return nil }))
+	assert.Equal(t, Running, m.server("s1").state)
+}
+
+func TestMachine_StopCrashed(t *testing.T) {
+	m, _ := newTestMachine(Offline)
+
+	m.Set("s1", Crashed)
+
+	require.NoError(t, m.Do("s1", Stopping, Offline, func(map[string]interface{}) error { return nil }))
+	assert.Equal(t, Offline, m.server("s1").state)
+}
+
+func TestMachine_DeletedIsForgotten(t *testing.T) {
+	m, r := newTestMachine(Offline)
+
+	require.NoError(t, m.Do("s1", Deleting, Deleted, func(map[string]interface{}) error { return nil }))
+	assert.Equal(t, Deleted, r.changes[len(r.changes)-1].To)
+
+	m.mu.Lock()
+	_, tracked := m.servers["s1"]
+	m.mu.Unlock()
+	assert.False(t, tracked)
+
+	// The ID can be reused by a new server
+	assert.NoError(t, m.Do("s1", Installing, Offline, func(map[string]interface{}) error { return nil }))
+}
+
+func TestMachine_SlowNotifierDoesNotBlockServer(t *testing.T) {
+	m := NewMachine(nil)
+	release := make(chan struct{})
+	var delivered []State
+	var mu sync.Mutex
+	m.SetNotifier(func(change Change) {
+		if change.To == Running {
+			<-release // a stalled panel connection
+		}
+		mu.Lock()
+		delivered = append(delivered, change.To)
+		mu.Unlock()
+	})
+
+	observed := make(chan struct{})
+	go func() {
+		m.Set("s1", Running)
+		close(observed)
+	}()
+	require.Eventually(t, func() bool { return m.Get("s1") == Running }, time.Second, time.Millisecond)
+
+	// Operations go ahead while the first change is still being delivered,
+	// and their changes are delivered after it
+	require.NoError(t, m.Do("s1", Stopping, Offline, func(map[string]interface{}) error { return nil }))
+	assert.Equal(t, Offline, m.Get("s1"))
+	mu.Lock()
+	assert.Empty(t, delivered)
+	mu.Unlock()
+
+	close(release)
+	<-observed
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []State{Running, Stopping, Offline}, delivered)
+}
+
+func TestMachine_ObservesWithoutLock(t *testing.T) {
+	observing := make(chan struct{})
+	release := make(chan struct{})
+	m := NewMachine(func(string) State {
+		close(observing)
+		<-release // a slow Docker daemon
+		return Running
+	})
+	m.Set("s1", Offline)
+
+	got := make(chan State, 1)
+	go func() { got <- m.Get("s1") }()
+	<-observing
+
+	// The server can change while its container is inspected, and the
+	// stale observation is then dropped
+	m.Set("s1", Crashed)
+	close(release)
+	assert.Equal(t, Crashed, <-got)
+}
+
+type mockResolver struct{}
+
+func (mockResolver) Resolve(ctx context.Context, serverID string) string { return "c-" + serverID }
+
+type mockInspector map[string]*container.State
+
+func (m mockInspector) InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	st, ok := m[containerID]
+	if !ok {
+		return container.InspectResponse{}, errdefs.NotFound(errors.New("no such container"))
+	}
+	if st == nil {
+		return container.InspectResponse{}, errors.New("daemon unavailable")
+	}
+	return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{State: st}}, nil
+}
+
+func TestObserveContainers(t *testing.T) {
+	observe := ObserveContainers(mockResolver{}, mockInspector{
+		"c-running":    {Running: true},
+		"c-restarting": {Restarting: true},
+		"c-exited":     {Status: "exited"},
+		"c-broken":     nil,
+	})
+
+	assert.Equal(t, Running, observe("running"))
+	assert.Equal(t, Running, observe("restarting"))
+	assert.Equal(t, Offline, observe("exited"))
+	assert.Equal(t, Offline, observe("missing"))
+	assert.Equal(t, State(""), observe("broken"))
+}
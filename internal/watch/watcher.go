@@ -0,0 +1,341 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/messages"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+)
+
+const (
+	// resubscribeDelay is the initial wait before resubscribing to events
+	resubscribeDelay = time.Second
+	// resubscribeMaxDelay caps the wait between resubscription attempts
+	resubscribeMaxDelay = 30 * time.Second
+)
+
+// EventSource is the subset of docker.Manager used by the watcher
+type EventSource interface {
+	ContainerEvents(ctx context.Context, since time.Time) (<-chan events.Message, <-chan error)
+	InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error)
+}
+
+// StateTracker is the subset of state.Machine updated by the watcher. The
+// watcher reads states with Peek, as the event it handles is the change a
+// refresh from the container would report.
+type StateTracker interface {
+	Peek(serverID string) state.State
+	Set(serverID string, to state.State)
+}
+
+// Exit describes a container that stopped running
+type Exit struct {
+	ServerID      string    `json:"serverId"`
+	ContainerID   string    `json:"containerId"`
+	ExitCode      int       `json:"exitCode"`
+	OOMKilled     bool      `json:"oomKilled"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	UserInitiated bool      `json:"userInitiated"`
+}
+
+// Crashed reports whether the exit was not asked for and not clean
+func (e Exit) Crashed() bool {
+	return !e.UserInitiated && (e.ExitCode != 0 || e.OOMKilled)
+}
+
+// Watcher follows Docker events of managed containers and reports exits,
+// OOM kills and health changes to the panel as they happen
+type Watcher struct {
+	source EventSource
+	states StateTracker
+	events messages.EventSink
+
+	mu      sync.Mutex
+	killed  map[string]bool // containers signalled since they last started
+	last    time.Time       // time of the last event handled
+	onExit  []func(ctx context.Context, exit Exit)
+	onStart []func(serverID, containerID string)
+	report  func(ctx context.Context, exit Exit) map[string]interface{}
+
+	subscribed     chan struct{} // closed once Run has subscribed
+	subscribedOnce sync.Once
+}
+
+// NewWatcher creates a container event watcher
+func NewWatcher(source EventSource, states StateTracker, events messages.EventSink) *Watcher {
+	return &Watcher{
+		source: source,
+		states: states,
+		events: events,
+		killed: make(map[string]bool),
+
+		subscribed: make(chan struct{}),
+	}
+}
+
+// Subscribed returns a channel closed once Run has subscribed to events.
+// Events from the moment Run was called on are delivered, so changes made
+// after this fires are never missed.
+func (w *Watcher) Subscribed() <-chan struct{} {
+	return w.subscribed
+}
+
+// SetCrashReporter registers fn to capture evidence of a crash before it is
+// reported; the summary fn returns is sent with the crash event
+func (w *Watcher) SetCrashReporter(fn func(ctx context.Context, exit Exit) map[string]interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.report = fn
+}
+
+// OnExit registers fn to be called after every container exit is reported
+func (w *Watcher) OnExit(fn func(ctx context.Context, exit Exit)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onExit = append(w.onExit, fn)
+}
+
+// OnStart registers fn to be called whenever a container starts, including
+// starts the agent did not ask for
+func (w *Watcher) OnStart(fn func(serverID, containerID string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onStart = append(w.onStart, fn)
+}
+
+// Run follows container events until ctx is cancelled, resubscribing from
+// the last handled event whenever the stream breaks
+func (w *Watcher) Run(ctx context.Context) {
+	// The first subscription replays from now, covering events that happen
+	// while it is being set up
+	w.mu.Lock()
+	if w.last.IsZero() {
+		w.last = time.Now().Add(-time.Nanosecond)
+	}
+	w.mu.Unlock()
+
+	delay := resubscribeDelay
+	for {
+		started := time.Now()
+		err := w.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > resubscribeMaxDelay {
+			delay = resubscribeDelay
+		}
+		log.Printf("Container event stream ended: %v; resubscribing in %s", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, resubscribeMaxDelay)
+	}
+}
+
+// follow handles events from one subscription until it fails
+func (w *Watcher) follow(ctx context.Context) error {
+	w.mu.Lock()
+	since := w.last
+	w.mu.Unlock()
+	if !since.IsZero() {
+		since = since.Add(time.Nanosecond)
+	}
+
+	msgs, errs := w.source.ContainerEvents(ctx, since)
+	w.subscribedOnce.Do(func() { close(w.subscribed) })
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return errors.New("event stream closed")
+			}
+			w.Handle(ctx, msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// Handle translates a single container event
+func (w *Watcher) Handle(ctx context.Context, msg events.Message) {
+	serverID := msg.Actor.Attributes[docker.LabelServerID]
+	if serverID == "" {
+		return
+	}
+	containerID := msg.Actor.ID
+
+	w.mu.Lock()
+	if at := eventTime(msg); at.After(w.last) {
+		w.last = at
+	}
+	w.mu.Unlock()
+
+	switch {
+	case msg.Action == events.ActionStart:
+		w.setKilled(containerID, false)
+		w.states.Set(serverID, state.Running)
+		for _, fn := range w.startHandlers() {
+			fn(serverID, containerID)
+		}
+
+	case msg.Action == events.ActionKill:
+		// Signals such as SIGHUP or SIGUSR1 leave the game running, so the
+		// next exit may still be a crash
+		if terminating(msg.Actor.Attributes["signal"]) {
+			w.setKilled(containerID, true)
+		}
+
+	case msg.Action == events.ActionOOM:
+		w.events.SendEvent("server_oom", map[string]interface{}{
+			"serverId":    serverID,
+			"containerId": containerID,
+		})
+
+	case msg.Action == events.ActionDie:
+		w.handleDie(ctx, serverID, msg)
+
+	case msg.Action == events.ActionStop:
+		// A stop follows die; only settle servers that did not crash
+		if w.states.Peek(serverID) != state.Crashed {
+			w.states.Set(serverID, state.Offline)
+		}
+
+	case strings.HasPrefix(string(msg.Action), string(events.ActionHealthStatus)):
+		health := strings.TrimSpace(strings.TrimPrefix(string(msg.Action), string(events.ActionHealthStatus)+":"))
+		w.events.SendEvent("server_health_changed", map[string]interface{}{
+			"serverId":    serverID,
+			"containerId": containerID,
+			"health":      health,
+		})
+	}
+}
+
+// handleDie reports a container exit, telling crashes from requested stops
+func (w *Watcher) handleDie(ctx context.Context, serverID string, msg events.Message) {
+	exit := w.exitOf(ctx, serverID, msg)
+
+	if exit.Crashed() {
+		log.Printf("Server %s crashed with exit code %d (OOM killed: %t)", serverID, exit.ExitCode, exit.OOMKilled)
+		w.states.Set(serverID, state.Crashed)
+
+		event := exitEvent(exit)
+		w.mu.Lock()
+		report := w.report
+		w.mu.Unlock()
+		if report != nil {
+			event["crashReport"] = report(ctx, exit)
+		}
+		w.events.SendEvent("server_crashed", event)
+	} else {
+		w.states.Set(serverID, state.Offline)
+		w.events.SendEvent("server_exited", exitEvent(exit))
+	}
+
+	for _, fn := range w.exitHandlers() {
+		fn(ctx, exit)
+	}
+}
+
+// exitHandlers returns the registered exit callbacks
+func (w *Watcher) exitHandlers() []func(context.Context, Exit) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.onExit
+}
+
+// startHandlers returns the registered start callbacks
+func (w *Watcher) startHandlers() []func(string, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.onStart
+}
+
+// exitOf gathers exit details from the container, falling back to the event
+// attributes if the container is already gone
+func (w *Watcher) exitOf(ctx context.Context, serverID string, msg events.Message) Exit {
+	exit := Exit{
+		ServerID:    serverID,
+		ContainerID: msg.Actor.ID,
+		FinishedAt:  eventTime(msg),
+	}
+	exit.ExitCode, _ = strconv.Atoi(msg.Actor.Attributes["exitCode"])
+
+	info, err := w.source.InspectContainer(ctx, msg.Actor.ID)
+	if err == nil && info.ContainerJSONBase != nil && info.State != nil {
+		exit.ExitCode = info.State.ExitCode
+		exit.OOMKilled = info.State.OOMKilled
+		if finished, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt); err == nil {
+			exit.FinishedAt = finished
+		}
+	}
+
+	// The agent or an operator asked for the exit if the server was being
+	// stopped or deleted, or if the container was signalled beforehand
+	switch w.states.Peek(serverID) {
+	case state.Stopping, state.Deleting:
+		exit.UserInitiated = true
+	}
+	if w.setKilled(msg.Actor.ID, false) {
+		exit.UserInitiated = true
+	}
+	return exit
+}
+
+// setKilled records whether a container was signalled and returns the
+// previous value
+func (w *Watcher) setKilled(containerID string, killed bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	was := w.killed[containerID]
+	if killed {
+		w.killed[containerID] = true
+	} else {
+		delete(w.killed, containerID)
+	}
+	return was
+}
+
+// terminating reports whether a kill event's signal, a name or a number,
+// asks the process to exit
+func terminating(signal string) bool {
+	if docker.IsKillSignal(signal) {
+		return true
+	}
+	switch strings.TrimPrefix(strings.ToUpper(signal), "SIG") {
+	case "TERM", "15", "INT", "2", "QUIT", "3":
+		return true
+	}
+	return false
+}
+
+// exitEvent builds the panel event describing an exit
+func exitEvent(exit Exit) map[string]interface{} {
+	return map[string]interface{}{
+		"serverId":      exit.ServerID,
+		"containerId":   exit.ContainerID,
+		"exitCode":      exit.ExitCode,
+		"oomKilled":     exit.OOMKilled,
+		"finishedAt":    exit.FinishedAt.Format(time.RFC3339Nano),
+		"userInitiated": exit.UserInitiated,
+	}
+}
+
+// eventTime returns when an event happened
+func eventTime(msg events.Message) time.Time {
+	if msg.TimeNano != 0 {
+		return time.Unix(0, msg.TimeNano)
+	}
+	return time.Unix(msg.Time, 0)
+}
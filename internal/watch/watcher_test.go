@@ -0,0 +1,270 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/docker"
+	"github.com/scarecr0w12/ctrl-alt-play-agent/internal/state"
+)
+
+type mockSource struct {
+	states map[string]*container.State
+	since  []time.Time
+	msgs   chan events.Message
+	errs   chan error
+}
+
+func (m *mockSource) ContainerEvents(ctx context.Context, since time.Time) (<-chan events.Message, <-chan error) {
+	m.since = append(m.since, since)
+	return m.msgs, m.errs
+}
+
+func (m *mockSource) InspectContainer(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	st, ok := m.states[containerID]
+	if !ok {
+		return container.InspectResponse{}, errors.New("no such container")
+	}
+	return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{State: st}}, nil
+}
+
+type mockStates map[string]state.State
+
+func (m mockStates) Peek(serverID string) state.State {
+	if st, ok := m[serverID]; ok {
+		return st
+	}
+	return state.Offline
+}
+
+func (m mockStates) Set(serverID string, to state.State) { m[serverID] = to }
+
+type recordingSink struct {
+	events []map[string]interface{}
+}
+
+func (s *recordingSink) SendEvent(event string, data map[string]interface{}) {
+	data["event"] = event
+	s.events = append(s.events, data)
+}
+
+func event(action events.Action, containerID, serverID string, attrs map[string]string) events.Message {
+	attributes := map[string]string{docker.LabelServerID: serverID}
+	for k, v := range attrs {
+		attributes[k] = v
+	}
+	return events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: containerID, Attributes: attributes},
+		TimeNano: time.Now().UnixNano(),
+	}
+}
+
+func newTestWatcher(containers map[string]*container.State) (*Watcher, mockStates, *recordingSink) {
+	states := mockStates{}
+	sink := &recordingSink{}
+	return NewWatcher(&mockSource{states: containers}, states, sink), states, sink
+}
+
+func TestWatcher_Crash(t *testing.T) {
+	w, states, sink := newTestWatcher(map[string]*container.State{
+		"c1": {ExitCode: 137, OOMKilled: true, FinishedAt: "2025-01-23T10:00:00.5Z"},
+	})
+	ctx := context.Background()
+
+	w.Handle(ctx, event(events.ActionStart, "c1", "s1", nil))
+	assert.Equal(t, state.Running, states["s1"])
+
+	w.Handle(ctx, event(events.ActionOOM, "c1", "s1", nil))
+	w.Handle(ctx, event(events.ActionDie, "c1", "s1", map[string]string{"exitCode": "137"}))
+
+	assert.Equal(t, state.Crashed, states["s1"])
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, "server_oom", sink.events[0]["event"])
+
+	crash := sink.events[1]
+	assert.Equal(t, "server_crashed", crash["event"])
+	assert.Equal(t, 137, crash["exitCode"])
+	assert.Equal(t, true, crash["oomKilled"])
+	assert.Equal(t, false, crash["userInitiated"])
+	assert.Equal(t, "2025-01-23T10:00:00.5Z", crash["finishedAt"])
+
+	// The stop that may follow does not hide the crash
+	w.Handle(ctx, event(events.ActionStop, "c1", "s1", nil))
+	assert.Equal(t, state.Crashed, states["s1"])
+}
+
+func TestWatcher_CrashWithStateMachine(t *testing.T) {
+	source := &mockSource{states: map[string]*container.State{"c1": {ExitCode: 1}}}
+	// The container is gone by the time anything looks at it
+	machine := state.NewMachine(func(string) state.State { return state.Offline })
+	var changes []state.Change
+	machine.SetNotifier(func(change state.Change) { changes = append(changes, change) })
+	w := NewWatcher(source, machine, &recordingSink{})
+	ctx := context.Background()
+
+	w.Handle(ctx, event(events.ActionStart, "c1", "s1", nil))
+	w.Handle(ctx, event(events.ActionDie, "c1", "s1", map[string]string{"exitCode": "1"}))
+	w.Handle(ctx, event(events.ActionStop, "c1", "s1", nil))
+
+	// The crash is one change from running, not a detour through offline
+	require.NotEmpty(t, changes)
+	last := changes[len(changes)-1]
+	assert.Equal(t, state.Change{ServerID: "s1", From: state.Running, To: state.Crashed}, last)
+	for _, change := range changes {
+		assert.NotEqual(t, state.Offline, change.To, "unexpected change %v", change)
+	}
+	assert.Equal(t, state.Crashed, machine.Get("s1"))
+}
+
+func TestWatcher_UserInitiatedExits(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []events.Action
+		signal string
+		state  state.State
+	}{
+		{name: "killed by docker stop", before: []events.Action{events.ActionKill}, signal: "15", state: state.Running},
+		{name: "killed with SIGINT", before: []events.Action{events.ActionKill}, signal: "SIGINT", state: state.Running},
+		{name: "killed with SIGKILL", before: []events.Action{events.ActionKill}, signal: "9", state: state.Running},
+		{name: "stopped by the agent", state: state.Stopping},
+		{name: "deleted by the agent", state: state.Deleting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, states, sink := newTestWatcher(map[string]*container.State{"c1": {ExitCode: 143}})
+			states["s1"] = tt.state
+			ctx := context.Background()
+
+			for _, action := range tt.before {
+				w.Handle(ctx, event(action, "c1", "s1", map[string]string{"signal": tt.signal}))
+			}
+			w.Handle(ctx, event(events.ActionDie, "c1", "s1", nil))
+
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, "server_exited", sink.events[0]["event"])
+			assert.Equal(t, true, sink.events[0]["userInitiated"])
+			assert.Equal(t, 143, sink.events[0]["exitCode"])
+		})
+	}
+}
+
+func TestWatcher_NonTerminatingSignalIsNotAStop(t *testing.T) {
+	for _, signal := range []string{"1", "SIGHUP", "10", "SIGUSR1"} {
+		t.Run(signal, func(t *testing.T) {
+			w, states, sink := newTestWatcher(map[string]*container.State{"c1": {ExitCode: 1}})
+			states["s1"] = state.Running
+			ctx := context.Background()
+
+			// A reload signal, then a real crash later on
+			w.Handle(ctx, event(events.ActionKill, "c1", "s1", map[string]string{"signal": signal}))
+			w.Handle(ctx, event(events.ActionDie, "c1", "s1", nil))
+
+			assert.Equal(t, state.Crashed, states["s1"])
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, "server_crashed", sink.events[0]["event"])
+			assert.Equal(t, false, sink.events[0]["userInitiated"])
+		})
+	}
+}
+
+func TestWatcher_KillIsForgottenOnStart(t *testing.T) {
+	w, states, sink := newTestWatcher(map[string]*container.State{"c1": {ExitCode: 1}})
+	ctx := context.Background()
+
+	w.Handle(ctx, event(events.ActionKill, "c1", "s1", map[string]string{"signal": "15"}))
+	w.Handle(ctx, event(events.ActionStart, "c1", "s1", nil))
+	w.Handle(ctx, event(events.ActionDie, "c1", "s1", nil))
+
+	assert.Equal(t, state.Crashed, states["s1"])
+	assert.Equal(t, "server_crashed", sink.events[0]["event"])
+}
+
+func TestWatcher_CleanExitIsNotACrash(t *testing.T) {
+	w, states, sink := newTestWatcher(nil)
+	states["s1"] = state.Running
+
+	// The container is gone; the exit code comes from the event
+	w.Handle(context.Background(), event(events.ActionDie, "c1", "s1", map[string]string{"exitCode": "0"}))
+
+	assert.Equal(t, state.Offline, states["s1"])
+	assert.Equal(t, "server_exited", sink.events[0]["event"])
+	assert.Equal(t, false, sink.events[0]["userInitiated"])
+}
+
+func TestWatcher_HealthStatus(t *testing.T) {
+	w, _, sink := newTestWatcher(nil)
+
+	w.Handle(context.Background(), event(events.ActionHealthStatusUnhealthy, "c1", "s1", nil))
+
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, "server_health_changed", sink.events[0]["event"])
+	assert.Equal(t, "unhealthy", sink.events[0]["health"])
+}
+
+func TestWatcher_IgnoresUnlabelledContainers(t *testing.T) {
+	w, states, sink := newTestWatcher(nil)
+
+	w.Handle(context.Background(), event(events.ActionDie, "c1", "", nil))
+
+	assert.Empty(t, states)
+	assert.Empty(t, sink.events)
+}
+
+func TestWatcher_ResubscribesAfterLastEvent(t *testing.T) {
+	source := &mockSource{msgs: make(chan events.Message, 1), errs: make(chan error, 1)}
+	w := NewWatcher(source, mockStates{}, &recordingSink{})
+
+	msg := event(events.ActionStart, "c1", "s1", nil)
+	source.msgs <- msg
+	close(source.msgs)
+	assert.Error(t, w.follow(context.Background()))
+
+	source.msgs = make(chan events.Message)
+	source.errs <- errors.New("connection reset")
+	assert.Error(t, w.follow(context.Background()))
+
+	require.Len(t, source.since, 2)
+	assert.True(t, source.since[0].IsZero())
+	assert.Equal(t, time.Unix(0, msg.TimeNano+1), source.since[1])
+}
+
+func TestWatcher_RunReplaysFromStart(t *testing.T) {
+	source := &mockSource{msgs: make(chan events.Message), errs: make(chan error)}
+	w := NewWatcher(source, mockStates{}, &recordingSink{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before := time.Now()
+	go w.Run(ctx)
+
+	select {
+	case <-w.Subscribed():
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not subscribe")
+	}
+	require.Len(t, source.since, 1)
+	assert.False(t, source.since[0].Before(before), "the subscription must not miss events since Run")
+	assert.False(t, source.since[0].After(time.Now()))
+}
+
+func TestWatcher_CrashReport(t *testing.T) {
+	w, _, sink := newTestWatcher(map[string]*container.State{"c1": {ExitCode: 1}})
+	w.SetCrashReporter(func(ctx context.Context, exit Exit) map[string]interface{} {
+		return map[string]interface{}{"file": "crash-reports/crash.json", "exitCode": exit.ExitCode}
+	})
+
+	w.Handle(context.Background(), event(events.ActionDie, "c1", "s1", nil))
+
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, map[string]interface{}{"file": "crash-reports/crash.json", "exitCode": 1}, sink.events[0]["crashReport"])
+}